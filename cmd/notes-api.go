@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -9,10 +10,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -24,21 +32,41 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 
 	"github.com/mrshanahan/notes-api/internal/cache"
+	legacynotes "github.com/mrshanahan/notes-api/internal/notes"
 	"github.com/mrshanahan/notes-api/internal/utils"
 	"github.com/mrshanahan/notes-api/pkg/auth"
 	"github.com/mrshanahan/notes-api/pkg/middleware"
 	"github.com/mrshanahan/notes-api/pkg/notes"
+	notesarchive "github.com/mrshanahan/notes-api/pkg/notes-archive"
 	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
+	notesreminders "github.com/mrshanahan/notes-api/pkg/notes-reminders"
 )
 
 var (
-	DB                       *sql.DB
-	TokenCookieName          string = "access_token"
-	NoteLocalName            string = "note"
-	TokenLocalName           string = "token"
-	NotesConfigDirectory     string = path.Join(os.Getenv("HOME"), ".notes")
-	DefaultPort              int    = 3333
-	DefaultNotesDatabaseName string = "notes.sqlite"
+	TokenCookieName          string        = "access_token"
+	NoteLocalName            string        = "note"
+	NotebookLocalName        string        = "notebook"
+	TemplateLocalName        string        = "template"
+	TokenLocalName           string        = "token"
+	NotesConfigDirectory     string        = path.Join(os.Getenv("HOME"), ".notes")
+	DefaultPort              int           = 3333
+	DefaultNotesDatabaseName string        = "notes.sqlite"
+	DefaultContentDirName    string        = "content"
+	DefaultAttachmentDirName string        = "attachments"
+	DefaultMaxAttachmentSize int64         = 100 << 20
+	DefaultMaxImportSize     int64         = 100 << 20
+	DefaultMaxBodySize       int64         = fiber.DefaultBodyLimit
+	DefaultListLimit         int           = 100
+	MaxListLimit             int           = 1000
+	DefaultSearchLimit       int           = 50
+	MaxSearchLimit           int           = 200
+	DefaultMaxRevisions      int           = 50
+	DefaultTrashRetention    time.Duration = 30 * 24 * time.Hour
+	TrashPurgeInterval       time.Duration = time.Hour
+	DefaultBackupDirName     string        = "backups"
+	DefaultBackupInterval    time.Duration = 24 * time.Hour
+	DefaultBackupRetention   int           = 7
+	DefaultUpcomingWindow    time.Duration = 7 * 24 * time.Hour
 )
 
 func main() {
@@ -52,289 +80,2991 @@ func Run() int {
 		return 0
 	}
 
-	var dbPath string
-	dbPathDir := os.Getenv("NOTES_API_DB_DIR")
-	if dbPathDir == "" {
-		if err := os.MkdirAll(NotesConfigDirectory, 0777); err != nil {
-			slog.Error("failed to create notes directory",
-				"path", NotesConfigDirectory,
-				"err", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			return RunMigrate(os.Args[2:])
+		case "assign-owner":
+			return RunAssignOwner(os.Args[2:])
+		case "convert-content":
+			return RunConvertContent(os.Args[2:])
+		case "reindex-links":
+			return RunReindexLinks(os.Args[2:])
+		case "rekey":
+			return RunRekey(os.Args[2:])
+		case "recompress":
+			return RunRecompress(os.Args[2:])
+		case "backup":
+			return RunBackup(os.Args[2:])
+		case "restore":
+			return RunRestore(os.Args[2:])
+		case "import-legacy":
+			return RunImportLegacy(os.Args[2:])
+		case "export":
+			return RunExport(os.Args[2:])
+		default:
+			fmt.Fprintf(os.Stderr, "unrecognized command: %s\n", os.Args[1])
+			printHelp()
 			return 1
 		}
-		dbPath = path.Join(NotesConfigDirectory, DefaultNotesDatabaseName)
-		slog.Info("no path provided for DB; using default",
-			"path", dbPath)
+	}
+
+	return RunServer()
+}
+
+func RunServer() int {
+	var store notesdb.Store
+	var backups *notesdb.BackupStore
+	switch storage := strings.ToLower(os.Getenv("NOTES_API_STORAGE")); storage {
+	case "", "sqlite":
+		dbPath, err := getDBPath()
+		if err != nil {
+			return 1
+		}
+
+		db, err := notesdb.Initialize(dbPath)
+		if err != nil {
+			fmt.Printf("failed to initialize: %s\n", err)
+			return 1
+		}
+		defer db.Close()
+
+		contentFiles, err := initializeContentFiles(dbPath)
+		if err != nil {
+			return 1
+		}
+
+		attachmentFiles, err := initializeAttachmentFiles(dbPath)
+		if err != nil {
+			return 1
+		}
+
+		contentCipher, err := initializeContentCipher()
+		if err != nil {
+			return 1
+		}
+
+		compressionThreshold, err := getCompressionThreshold()
+		if err != nil {
+			return 1
+		}
+
+		defaultContentType, err := getDefaultContentType()
+		if err != nil {
+			return 1
+		}
+
+		store = notesdb.NewSQLiteStore(db, contentFiles, attachmentFiles, contentCipher, compressionThreshold, defaultContentType)
+
+		backups, err = initializeBackups(db, dbPath)
+		if err != nil {
+			return 1
+		}
+	case "memory":
+		slog.Warn("using in-memory storage; notes will be lost when the server stops")
+		store = notesdb.NewMemoryStore()
+	default:
+		slog.Error("invalid value for NOTES_API_STORAGE; must be 'sqlite' or 'memory'",
+			"storage", storage)
+		return 1
+	}
+
+	config := DefaultServerConfig()
+
+	portStr := os.Getenv("NOTES_API_PORT")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		port = DefaultPort
+		slog.Info("no valid port provided via NOTES_API_PORT, using default",
+			"portStr", portStr,
+			"defaultPort", port)
 	} else {
-		slog.Info("given DB directory", "dir", dbPathDir)
-		if err := os.MkdirAll(dbPathDir, 0777); err != nil {
-			slog.Error("failed to create custom notes DB path parent",
-				"path", dbPathDir,
-				"err", err)
+		slog.Info("using custom port",
+			"port", port)
+	}
+
+	maxRevisionsStr := os.Getenv("NOTES_API_MAX_REVISIONS")
+	if maxRevisionsStr != "" {
+		maxRevisions, err := strconv.Atoi(maxRevisionsStr)
+		if err != nil || maxRevisions < 0 {
+			slog.Error("invalid value for NOTES_API_MAX_REVISIONS; must be a non-negative integer",
+				"maxRevisionsStr", maxRevisionsStr)
 			return 1
 		}
-		dbPath = path.Join(dbPathDir, DefaultNotesDatabaseName)
+		config.MaxRevisions = maxRevisions
 	}
+	slog.Info("using max revisions per note", "maxRevisions", config.MaxRevisions)
 
-	if _, err := os.Open(dbPath); err != nil && errors.Is(err, os.ErrNotExist) {
-		slog.Info("DB does not exist; it will be created during initialization",
-			"path", dbPath)
+	trashRetentionStr := os.Getenv("NOTES_API_TRASH_RETENTION")
+	if trashRetentionStr != "" {
+		config.TrashRetention, err = time.ParseDuration(trashRetentionStr)
+		if err != nil || config.TrashRetention < 0 {
+			slog.Error("invalid value for NOTES_API_TRASH_RETENTION; must be a non-negative duration (e.g. 720h)",
+				"trashRetentionStr", trashRetentionStr)
+			return 1
+		}
+	}
+
+	maxAttachmentSizeStr := os.Getenv("NOTES_API_MAX_ATTACHMENT_SIZE")
+	if maxAttachmentSizeStr != "" {
+		config.MaxAttachmentSize, err = strconv.ParseInt(maxAttachmentSizeStr, 10, 64)
+		if err != nil || config.MaxAttachmentSize <= 0 {
+			slog.Error("invalid value for NOTES_API_MAX_ATTACHMENT_SIZE; must be a positive number of bytes",
+				"maxAttachmentSizeStr", maxAttachmentSizeStr)
+			return 1
+		}
+	}
+	slog.Info("using max attachment size", "maxAttachmentSize", config.MaxAttachmentSize)
+
+	maxImportSizeStr := os.Getenv("NOTES_API_MAX_IMPORT_SIZE")
+	if maxImportSizeStr != "" {
+		config.MaxImportSize, err = strconv.ParseInt(maxImportSizeStr, 10, 64)
+		if err != nil || config.MaxImportSize <= 0 {
+			slog.Error("invalid value for NOTES_API_MAX_IMPORT_SIZE; must be a positive number of bytes",
+				"maxImportSizeStr", maxImportSizeStr)
+			return 1
+		}
+	}
+	slog.Info("using max import size", "maxImportSize", config.MaxImportSize)
+
+	maxBodySizeStr := os.Getenv("NOTES_API_MAX_BODY_SIZE")
+	if maxBodySizeStr != "" {
+		config.MaxBodySize, err = strconv.ParseInt(maxBodySizeStr, 10, 64)
+		if err != nil || config.MaxBodySize <= 0 {
+			slog.Error("invalid value for NOTES_API_MAX_BODY_SIZE; must be a positive number of bytes",
+				"maxBodySizeStr", maxBodySizeStr)
+			return 1
+		}
+	}
+	slog.Info("using max request body size", "maxBodySize", config.MaxBodySize)
+
+	backupIntervalStr := os.Getenv("NOTES_API_BACKUP_INTERVAL")
+	if backupIntervalStr != "" {
+		config.BackupInterval, err = time.ParseDuration(backupIntervalStr)
+		if err != nil || config.BackupInterval < 0 {
+			slog.Error("invalid value for NOTES_API_BACKUP_INTERVAL; must be a non-negative duration (e.g. 24h)",
+				"backupIntervalStr", backupIntervalStr)
+			return 1
+		}
+	}
+
+	config.BackupRetention, err = getBackupRetention()
+	if err != nil {
+		return 1
+	}
+
+	config.ReminderWebhookURL = os.Getenv("NOTES_API_REMINDER_WEBHOOK_URL")
+	if config.ReminderWebhookURL != "" {
+		if u, err := url.Parse(config.ReminderWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			slog.Error("invalid value for NOTES_API_REMINDER_WEBHOOK_URL; must be an http or https URL",
+				"reminderWebhookURL", config.ReminderWebhookURL)
+			return 1
+		}
+	}
+
+	adminSubjects := os.Getenv("NOTES_API_ADMIN_SUBJECTS")
+	for _, subject := range strings.Split(adminSubjects, ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			config.AdminSubjects = append(config.AdminSubjects, subject)
+		}
+	}
+	slog.Info("using admin subjects", "adminSubjects", config.AdminSubjects)
+
+	disableAuthOption := strings.TrimSpace(os.Getenv("NOTES_API_DISABLE_AUTH"))
+	if disableAuthOption != "" {
+		slog.Warn("disabling authentication framework - THIS SHOULD ONLY BE RUN FOR TESTING!")
+		config.DisableAuth = true
+	}
+
+	if !config.DisableAuth {
+		authProviderUrl := os.Getenv("NOTES_API_AUTH_PROVIDER_URL")
+		if authProviderUrl == "" {
+			panic("Required value for NOTES_API_AUTH_PROVIDER_URL but none provided")
+		}
+		redirectUrl := os.Getenv("NOTES_API_REDIRECT_URL")
+		if redirectUrl == "" {
+			panic("Required value for NOTES_API_REDIRECT_URL but none provided")
+		}
+		auth.InitializeAuth(context.Background(), authProviderUrl, redirectUrl)
+	} else {
+		slog.Warn("skipping initialization of authentication framework", "disableAuth", config.DisableAuth)
+	}
+
+	allowedOrigins := os.Getenv("NOTES_API_ALLOWED_ORIGINS")
+	if allowedOrigins != "" {
+		config.AllowedOrigins = allowedOrigins
+	}
+	slog.Info("setting CORS allowed origins", "origins", config.AllowedOrigins)
+
+	server := NewServer(store, config)
+	server.Backups = backups
+	sinks := []notesreminders.Sink{notesreminders.LogSink{}, &notesreminders.NotificationSink{Store: store}}
+	if config.ReminderWebhookURL != "" {
+		sinks = append(sinks, &notesreminders.WebhookSink{URL: config.ReminderWebhookURL})
+	}
+	server.Reminders = notesreminders.NewScheduler(store, sinks...)
+	slog.Info("firing reminders", "webhook", config.ReminderWebhookURL != "")
+	go server.Reminders.Run(context.Background())
+	if backups != nil && config.BackupInterval > 0 {
+		slog.Info("backing up DB periodically", "dir", backups.Dir, "interval", config.BackupInterval, "retention", config.BackupRetention)
+		go server.backupPeriodically(config.BackupInterval)
+	} else if backups != nil {
+		slog.Warn("backup interval is 0; the DB will only be backed up on demand")
+	}
+	if config.TrashRetention > 0 {
+		slog.Info("purging trashed notes periodically", "retention", config.TrashRetention, "interval", TrashPurgeInterval)
+		go server.purgeTrashPeriodically(config.TrashRetention, TrashPurgeInterval)
+	} else {
+		slog.Warn("trash retention is 0; trashed notes will never be purged automatically")
+	}
+
+	slog.Info("listening for requests", "port", port)
+	err = server.App().Listen(fmt.Sprintf(":%d", port))
+	if err != nil {
+		// TODO: do we get this error if it fails to initialize or if it just fails?
+		slog.Error("failed to initialize HTTP server",
+			"err", err)
+		return 1
+	}
+	return 0
+}
+
+// ServerConfig holds the settings of a Server that don't come from its Store.
+type ServerConfig struct {
+	DisableAuth       bool
+	AllowedOrigins    string
+	MaxRevisions      int
+	TrashRetention    time.Duration
+	MaxAttachmentSize int64
+	MaxImportSize     int64
+	BackupInterval    time.Duration
+	BackupRetention   int
+	// AdminSubjects are the token subjects allowed to use /admin.
+	AdminSubjects []string
+	// ReminderWebhookURL is where reminders are POSTed when they fire, if set.
+	ReminderWebhookURL string
+	// MaxBodySize bounds the body of every request besides attachment
+	// uploads & imports, which have limits of their own.
+	MaxBodySize int64
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		AllowedOrigins:    "*",
+		MaxRevisions:      DefaultMaxRevisions,
+		TrashRetention:    DefaultTrashRetention,
+		MaxAttachmentSize: DefaultMaxAttachmentSize,
+		MaxImportSize:     DefaultMaxImportSize,
+		MaxBodySize:       DefaultMaxBodySize,
+		BackupInterval:    DefaultBackupInterval,
+		BackupRetention:   DefaultBackupRetention,
+	}
+}
+
+// Server serves the API on top of a Store. Everything it persists goes through
+// the Store, so it can be run against any implementation (e.g. MemoryStore in tests).
+type Server struct {
+	Store notesdb.Store
+	// Backups is nil unless the Store is backed by SQLite.
+	Backups *notesdb.BackupStore
+	// Reminders is woken whenever a reminder may have changed. It may be nil,
+	// in which case reminders never fire.
+	Reminders *notesreminders.Scheduler
+	ServerConfig
+}
+
+func NewServer(store notesdb.Store, config ServerConfig) *Server {
+	return &Server{Store: store, ServerConfig: config}
+}
+
+// App builds the fiber app with all of the server's routes registered.
+func (s *Server) App() *fiber.App {
+	app := fiber.New(fiber.Config{
+		// Lets attachment uploads be streamed to disk instead of being
+		// buffered & pre-parsed; everything else still reads the whole body.
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
+	app.Use(requestid.New(), logger.New(), recover.New())
+	app.Use(s.LimitBodySize)
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  s.AllowedOrigins,
+		ExposeHeaders: fiber.HeaderETag,
+	}))
+	app.Route("/notes", func(notes fiber.Router) {
+		if !s.DisableAuth {
+			notes.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		} else {
+			slog.Warn("skipping registration of token validation middleware", "disableAuth", s.DisableAuth)
+		}
+		notes.Get("/", s.ListNotes)
+		notes.Post("/", s.CreateNote)
+		notes.Get("/search", s.SearchNotes)
+		notes.Route("/:noteID", func(note fiber.Router) {
+			note.Use(middleware.LoadNoteFromRoute(NoteLocalName, "noteID", TokenLocalName, s.Store))
+			note.Use(middleware.CheckNoteIfMatch(NoteLocalName))
+			note.Get("/", s.GetNote)
+			note.Post("/", s.UpdateNote)
+			note.Delete("/", s.DeleteNote)
+			note.Get("/content", s.GetNoteContent)
+			note.Post("/content", s.UpdateNoteContent)
+			note.Post("/notebook", s.MoveNote)
+			note.Post("/tags", s.AddNoteTags)
+			note.Delete("/tags/:tag", s.RemoveNoteTag)
+			note.Patch("/properties", s.SetNoteProperties)
+			for _, state := range notesdb.NoteStates {
+				note.Put("/"+string(state), s.SetNoteState(state, true))
+				note.Delete("/"+string(state), s.SetNoteState(state, false))
+			}
+			note.Put("/reminder", s.SetReminder)
+			note.Delete("/reminder", s.ClearReminder)
+			note.Get("/shares", s.ListNoteShares)
+			note.Post("/shares", s.CreateShare)
+			note.Get("/revisions", s.ListRevisions)
+			note.Get("/revisions/:rev", s.GetRevision)
+			note.Post("/revisions/:rev/restore", s.RestoreRevision)
+			note.Get("/attachments", s.ListAttachments)
+			note.Post("/attachments", s.AddAttachment)
+			note.Get("/attachments/:attachmentID", s.GetAttachment)
+			note.Delete("/attachments/:attachmentID", s.DeleteAttachment)
+			note.Get("/links", s.ListNoteLinks)
+			note.Get("/backlinks", s.ListBacklinks)
+		})
+	})
+	app.Route("/notebooks", func(notebooks fiber.Router) {
+		if !s.DisableAuth {
+			notebooks.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		notebooks.Get("/", s.ListNotebooks)
+		notebooks.Post("/", s.CreateNotebook)
+		notebooks.Route("/:notebookID", func(notebook fiber.Router) {
+			notebook.Use(middleware.LoadNotebookFromRoute(NotebookLocalName, "notebookID", TokenLocalName, s.Store))
+			notebook.Get("/", s.GetNotebook)
+			notebook.Post("/", s.UpdateNotebook)
+			notebook.Delete("/", s.DeleteNotebook)
+			notebook.Get("/notes", s.ListNotebookNotes)
+		})
+	})
+	app.Route("/templates", func(templates fiber.Router) {
+		if !s.DisableAuth {
+			templates.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		templates.Get("/", s.ListTemplates)
+		templates.Post("/", s.CreateTemplate)
+		templates.Route("/:templateID", func(template fiber.Router) {
+			template.Use(middleware.LoadTemplateFromRoute(TemplateLocalName, "templateID", TokenLocalName, s.Store))
+			template.Get("/", s.GetTemplate)
+			template.Post("/", s.UpdateTemplate)
+			template.Delete("/", s.DeleteTemplate)
+		})
+	})
+	app.Route("/shares", func(shares fiber.Router) {
+		if !s.DisableAuth {
+			shares.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		shares.Get("/", s.ListShares)
+		shares.Delete("/:shareID", s.RevokeShare)
+	})
+	// Shared notes are public: the token is all it takes to read one.
+	app.Get("/s/:token", s.GetSharedNote)
+	app.Route("/reminders", func(reminders fiber.Router) {
+		if !s.DisableAuth {
+			reminders.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		reminders.Get("/upcoming", s.ListUpcomingReminders)
+	})
+	app.Route("/notifications", func(notifications fiber.Router) {
+		if !s.DisableAuth {
+			notifications.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		notifications.Get("/", s.ListNotifications)
+		notifications.Post("/:notificationID/read", s.MarkNotificationRead)
+	})
+	app.Route("/tags", func(tags fiber.Router) {
+		if !s.DisableAuth {
+			tags.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		tags.Get("/", s.ListTags)
+	})
+	app.Route("/graph", func(graph fiber.Router) {
+		if !s.DisableAuth {
+			graph.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		graph.Get("/", s.GetNoteGraph)
+	})
+	app.Route("/export", func(export fiber.Router) {
+		if !s.DisableAuth {
+			export.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		export.Get("/", s.ExportNotes)
+	})
+	app.Route("/import", func(imp fiber.Router) {
+		if !s.DisableAuth {
+			imp.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		imp.Post("/", s.ImportNotes)
+	})
+	app.Route("/trash", func(trash fiber.Router) {
+		if !s.DisableAuth {
+			trash.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+		}
+		trash.Get("/", s.ListTrash)
+		trash.Post("/:noteID/restore", s.RestoreNote)
+		trash.Delete("/:noteID", s.PurgeNote)
+	})
+	app.Route("/admin", func(admin fiber.Router) {
+		if !s.DisableAuth {
+			admin.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
+			admin.Use(middleware.RequireSubject(TokenLocalName, s.AdminSubjects))
+		}
+		admin.Post("/backup", s.CreateBackup)
+	})
+	if !s.DisableAuth {
+		app.Route("/auth", func(auth fiber.Router) {
+			auth.Get("/login", Login)
+			auth.Get("/logout", Logout)
+			auth.Get("/callback", AuthCallback)
+		})
+	} else {
+		slog.Warn("skipping registration of authentication-related endpoints", "disableAuth", s.DisableAuth)
+	}
+
+	return app
+}
+
+func RunMigrate(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "missing migrate command: expected one of status, up, down\n")
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Open(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open DB: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := notesdb.GetMigrationStatus(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get migration status: %s\n", err)
+			return 1
+		}
+		for _, s := range statuses {
+			appliedOn := "pending"
+			if s.Applied {
+				appliedOn = s.AppliedOn.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, appliedOn)
+		}
+	case "up":
+		target := 0
+		if len(args) > 1 {
+			if target, err = strconv.Atoi(args[1]); err != nil || target <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid target version: %s\n", args[1])
+				return 1
+			}
+		}
+		applied, err := notesdb.MigrateUp(db, target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to apply migrations: %s\n", err)
+			return 1
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d %s\n", m.Version, m.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid number of steps: %s\n", args[1])
+				return 1
+			}
+		}
+		reverted, err := notesdb.MigrateDown(db, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revert migrations: %s\n", err)
+			return 1
+		}
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d %s\n", m.Version, m.Name)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unrecognized migrate command: %s\n", args[0])
+		return 1
+	}
+	return 0
+}
+
+func RunAssignOwner(args []string) int {
+	if len(args) != 1 || strings.TrimSpace(args[0]) == "" {
+		fmt.Fprintf(os.Stderr, "expected exactly one argument: the subject of the new owner\n")
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	assigned, err := notesdb.AssignUnownedNotes(db, args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to assign notes: %s\n", err)
+		return 1
+	}
+	fmt.Printf("assigned %d note(s) to %s\n", assigned, args[0])
+	return 0
+}
+
+func RunConvertContent(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "missing target content type: expected one of sql, file\n")
+		return 1
+	}
+	contentType, ok := notesdb.ContentTypeNames[strings.ToLower(args[0])]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "invalid content type: %s (expected one of sql, file)\n", args[0])
+		return 1
+	}
+
+	ids := []int64{}
+	for _, idStr := range args[1:] {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid note ID: %s\n", idStr)
+			return 1
+		}
+		ids = append(ids, id)
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+
+	if len(ids) == 0 {
+		for name, t := range notesdb.ContentTypeNames {
+			if t == contentType {
+				continue
+			}
+			typeIDs, err := notesdb.GetNoteIDsByContentType(db, t)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to list notes with content type %s: %s\n", name, err)
+				return 1
+			}
+			ids = append(ids, typeIDs...)
+		}
+	}
+
+	failed := 0
+	for _, id := range ids {
+		if err := notesdb.ConvertNoteContent(db, contentFiles, id, contentType); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to convert note %d: %s\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("converted note %d to %s\n", id, args[0])
+	}
+	fmt.Printf("converted %d of %d note(s)\n", len(ids)-failed, len(ids))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func RunReindexLinks(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "reindex-links takes no arguments\n")
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+
+	contentCipher, err := initializeContentCipher()
+	if err != nil {
+		return 1
+	}
+
+	indexed, err := notesdb.ReindexNoteLinks(db, contentFiles, func(stored []byte) ([]byte, error) {
+		return notesdb.DecodeContent(stored, contentCipher)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reindex links: %s\n", err)
+		return 1
+	}
+	fmt.Printf("reindexed links of %d note(s)\n", indexed)
+	return 0
+}
+
+func RunRekey(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "rekey takes no arguments\n")
+		return 1
+	}
+
+	contentCipher, err := initializeContentCipher()
+	if err != nil {
+		return 1
+	}
+	if contentCipher == nil {
+		fmt.Fprintf(os.Stderr, "no encryption keys configured: set NOTES_API_ENCRYPTION_KEY or NOTES_API_ENCRYPTION_KEY_FILE\n")
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+
+	rewritten, err := notesdb.RekeyContent(db, contentFiles, contentCipher)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to rekey content: %s\n", err)
+		return 1
+	}
+	fmt.Printf("re-encrypted %d piece(s) of content with key %s\n", rewritten, contentCipher.CurrentKeyID())
+	return 0
+}
+
+func RunRecompress(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "recompress takes no arguments\n")
+		return 1
+	}
+
+	compressionThreshold, err := getCompressionThreshold()
+	if err != nil {
+		return 1
+	}
+	contentCipher, err := initializeContentCipher()
+	if err != nil {
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+
+	stats, err := notesdb.RecompressContent(db, contentFiles, contentCipher, compressionThreshold)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to recompress content: %s\n", err)
+		return 1
+	}
+	fmt.Printf("rewrote %d of %d piece(s) of content; %d are compressed\n", stats.Rewritten, stats.Pieces, stats.Compressed)
+	fmt.Printf("content takes up %d bytes, stored in %d (was %d)\n", stats.ContentBytes, stats.StoredBytesAfter, stats.StoredBytesBefore)
+	if stats.ContentBytes > 0 {
+		saved := stats.ContentBytes - stats.StoredBytesAfter
+		fmt.Printf("saving %d bytes (%.1f%%)\n", saved, 100*float64(saved)/float64(stats.ContentBytes))
+	}
+	return 0
+}
+
+func initializeContentFiles(dbPath string) (*notesdb.ContentFileStore, error) {
+	contentDir := os.Getenv("NOTES_API_CONTENT_DIR")
+	if contentDir == "" {
+		contentDir = path.Join(path.Dir(dbPath), DefaultContentDirName)
+	}
+	contentFiles, err := notesdb.NewContentFileStore(contentDir)
+	if err != nil {
+		slog.Error("failed to create note content directory",
+			"path", contentDir,
+			"err", err)
+		return nil, err
+	}
+	slog.Info("using note content directory", "path", contentDir)
+	return contentFiles, nil
+}
+
+func initializeAttachmentFiles(dbPath string) (*notesdb.AttachmentFileStore, error) {
+	attachmentDir := os.Getenv("NOTES_API_ATTACHMENT_DIR")
+	if attachmentDir == "" {
+		attachmentDir = path.Join(path.Dir(dbPath), DefaultAttachmentDirName)
+	}
+	attachmentFiles, err := notesdb.NewAttachmentFileStore(attachmentDir)
+	if err != nil {
+		slog.Error("failed to create attachment directory",
+			"path", attachmentDir,
+			"err", err)
+		return nil, err
+	}
+	slog.Info("using attachment directory", "path", attachmentDir)
+	return attachmentFiles, nil
+}
+
+func RunBackup(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "backup takes no arguments\n")
+		return 1
+	}
+	retention, err := getBackupRetention()
+	if err != nil {
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Open(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open DB: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	backups, err := initializeBackups(db, dbPath)
+	if err != nil {
+		return 1
+	}
+	backup, err := backups.Create()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to back up DB: %s\n", err)
+		return 1
+	}
+	fmt.Printf("backed up DB to %s\n", backups.Path(backup.Name))
+
+	if retention > 0 {
+		pruned, err := backups.Prune(retention)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to prune old backups: %s\n", err)
+			return 1
+		}
+		for _, b := range pruned {
+			fmt.Printf("removed old backup %s\n", b.Name)
+		}
+	}
+	return 0
+}
+
+func RunRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "expected the name or path of the backup to restore\n")
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	// Bare names refer to backups in the backup directory.
+	backupPath := args[0]
+	if _, err := os.Stat(backupPath); err != nil && !strings.ContainsRune(backupPath, os.PathSeparator) {
+		backupPath = path.Join(getBackupDir(dbPath), backupPath)
+	}
+
+	replacedPath, err := notesdb.RestoreBackup(backupPath, dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to restore backup: %s\n", err)
+		return 1
+	}
+	fmt.Printf("restored %s to %s\n", backupPath, dbPath)
+	if replacedPath != "" {
+		fmt.Printf("previous DB kept at %s\n", replacedPath)
+	}
+	return 0
+}
+
+func RunExport(args []string) int {
+	format := ""
+	positional := []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--format" && i+1 < len(args):
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--format="):
+			format = strings.TrimPrefix(args[i], "--format=")
+		case strings.HasPrefix(args[i], "-") && args[i] != "-":
+			fmt.Fprintf(os.Stderr, "unexpected argument: %s\n", args[i])
+			return 1
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fmt.Fprintf(os.Stderr, "expected the token subject whose notes to export & optionally the file to write to\n")
+		return 1
+	}
+	owner, outPath := positional[0], "-"
+	if len(positional) == 2 {
+		outPath = positional[1]
+	}
+	if format == "" {
+		format = notesarchive.FormatFromPath(outPath)
+	}
+	if format == "" {
+		format = notesarchive.FormatZip
+	}
+	if !slices.Contains(notesarchive.Formats, format) {
+		fmt.Fprintf(os.Stderr, "invalid format: %s (expected one of %s)\n", format, strings.Join(notesarchive.Formats, ", "))
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+	attachmentFiles, err := initializeAttachmentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+	contentCipher, err := initializeContentCipher()
+	if err != nil {
+		return 1
+	}
+	store := notesdb.NewSQLiteStore(db, contentFiles, attachmentFiles, contentCipher, 0, notesdb.CONTENT_SQL)
+
+	out := os.Stdout
+	if outPath != "-" {
+		if out, err = os.Create(outPath); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %s\n", outPath, err)
+			return 1
+		}
+	}
+	w := bufio.NewWriter(out)
+	manifest, err := notesarchive.Export(w, store, owner, format)
+	if err == nil {
+		err = w.Flush()
+	}
+	if outPath != "-" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(outPath)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export notes: %s\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d note(s)\n", len(manifest.Notes))
+	return 0
+}
+
+func RunImportLegacy(args []string) int {
+	dryRun := false
+	owner := ""
+	for _, arg := range args {
+		switch {
+		case arg == "--dry-run":
+			dryRun = true
+		case owner == "" && !strings.HasPrefix(arg, "-"):
+			owner = arg
+		default:
+			fmt.Fprintf(os.Stderr, "unexpected argument: %s\n", arg)
+			return 1
+		}
+	}
+	if owner == "" {
+		fmt.Fprintf(os.Stderr, "missing subject: expected the token subject the imported notes will belong to\n")
+		return 1
+	}
+
+	// LoadIndex creates an empty index if there is none, which would hide a
+	// mistyped NOTES_ROOT.
+	root := legacynotes.GetNotesRoot()
+	if _, err := os.Stat(filepath.Join(root, "index.txt")); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find legacy index: %s\n", err)
+		return 1
+	}
+	index, err := legacynotes.LoadIndex()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load legacy index: %s\n", err)
+		return 1
+	}
+
+	dbPath, err := getDBPath()
+	if err != nil {
+		return 1
+	}
+	db, err := notesdb.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	contentFiles, err := initializeContentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+	attachmentFiles, err := initializeAttachmentFiles(dbPath)
+	if err != nil {
+		return 1
+	}
+	contentCipher, err := initializeContentCipher()
+	if err != nil {
+		return 1
+	}
+	compressionThreshold, err := getCompressionThreshold()
+	if err != nil {
+		return 1
+	}
+	defaultContentType, err := getDefaultContentType()
+	if err != nil {
+		return 1
+	}
+	store := notesdb.NewSQLiteStore(db, contentFiles, attachmentFiles, contentCipher, compressionThreshold, defaultContentType)
+
+	// The report maps legacy IDs to new ones, one note per line.
+	report := func(legacyID string, noteID *int64, status string, title string) {
+		noteIDStr := "-"
+		if noteID != nil {
+			noteIDStr = strconv.FormatInt(*noteID, 10)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", legacyID, noteIDStr, status, title)
+	}
+	fmt.Printf("LEGACY_ID\tNOTE_ID\tSTATUS\tTITLE\n")
+
+	imported, skipped, failed := 0, 0, 0
+	for _, entry := range index {
+		if entry.ID == "" {
+			report(entry.ID, nil, "failed: no ID", entry.Title)
+			failed++
+			continue
+		}
+		existing, err := notesdb.GetLegacyImport(db, owner, entry.ID)
+		if err != nil {
+			report(entry.ID, nil, fmt.Sprintf("failed: %s", err), entry.Title)
+			failed++
+			continue
+		}
+		if existing != nil {
+			status := "skipped: already imported"
+			if existing.NoteID == nil {
+				status = "skipped: already imported & since purged"
+			}
+			report(entry.ID, existing.NoteID, status, entry.Title)
+			skipped++
+			continue
+		}
+
+		content, createdOn, updatedOn, err := readLegacyNote(root, entry)
+		if err != nil {
+			report(entry.ID, nil, fmt.Sprintf("failed: %s", err), entry.Title)
+			failed++
+			continue
+		}
+		if dryRun {
+			report(entry.ID, nil, "would import", entry.Title)
+			imported++
+			continue
+		}
+
+		result, created, err := store.ImportLegacyNote(owner, entry.ID, entry.Title, content, createdOn, updatedOn)
+		if err != nil {
+			report(entry.ID, nil, fmt.Sprintf("failed: %s", err), entry.Title)
+			failed++
+		} else if !created {
+			report(entry.ID, result.NoteID, "skipped: already imported", entry.Title)
+			skipped++
+		} else {
+			report(entry.ID, result.NoteID, "imported", entry.Title)
+			imported++
+		}
+	}
+
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d, skipped %d & failed %d of %d legacy note(s)\n", verb, imported, skipped, failed, len(index))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readLegacyNote reads the content of a note of the legacy store along with
+// its creation & last modification times. Notes whose path no longer exists
+// are looked for in root, in case the legacy store has been moved.
+func readLegacyNote(root string, entry *legacynotes.IndexEntry) ([]byte, time.Time, time.Time, error) {
+	info, err := os.Stat(entry.Path)
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		moved := *entry
+		moved.Path = filepath.Join(root, filepath.Base(entry.Path))
+		if info, err = os.Stat(moved.Path); err == nil {
+			entry = &moved
+		}
+	}
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	content, err := legacynotes.GetNoteContents(entry)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	createdOn, updatedOn := entry.CreatedOn, info.ModTime()
+	if createdOn.IsZero() {
+		createdOn = updatedOn
+	}
+	if updatedOn.Before(createdOn) {
+		updatedOn = createdOn
+	}
+	return content, createdOn, updatedOn, nil
+}
+
+func getDefaultContentType() (int, error) {
+	defaultContentType := notesdb.CONTENT_SQL
+	defaultContentTypeStr := os.Getenv("NOTES_API_DEFAULT_CONTENT_TYPE")
+	if defaultContentTypeStr != "" {
+		contentType, ok := notesdb.ContentTypeNames[strings.ToLower(defaultContentTypeStr)]
+		if !ok {
+			slog.Error("invalid value for NOTES_API_DEFAULT_CONTENT_TYPE; must be 'sql' or 'file'",
+				"defaultContentTypeStr", defaultContentTypeStr)
+			return 0, fmt.Errorf("invalid default content type: %s", defaultContentTypeStr)
+		}
+		defaultContentType = contentType
+	}
+	slog.Info("using default content type for new notes", "contentType", defaultContentType)
+	return defaultContentType, nil
+}
+
+func initializeBackups(db *sql.DB, dbPath string) (*notesdb.BackupStore, error) {
+	backupDir := getBackupDir(dbPath)
+	backups, err := notesdb.NewBackupStore(db, backupDir)
+	if err != nil {
+		slog.Error("failed to create backup directory",
+			"path", backupDir,
+			"err", err)
+		return nil, err
+	}
+	slog.Info("using backup directory", "path", backupDir)
+	return backups, nil
+}
+
+func getBackupDir(dbPath string) string {
+	backupDir := os.Getenv("NOTES_API_BACKUP_DIR")
+	if backupDir == "" {
+		backupDir = path.Join(path.Dir(dbPath), DefaultBackupDirName)
+	}
+	return backupDir
+}
+
+func getBackupRetention() (int, error) {
+	backupRetentionStr := os.Getenv("NOTES_API_BACKUP_RETENTION")
+	if backupRetentionStr == "" {
+		return DefaultBackupRetention, nil
+	}
+	backupRetention, err := strconv.Atoi(backupRetentionStr)
+	if err != nil || backupRetention < 0 {
+		slog.Error("invalid value for NOTES_API_BACKUP_RETENTION; must be a non-negative integer",
+			"backupRetentionStr", backupRetentionStr)
+		return 0, fmt.Errorf("invalid backup retention: %s", backupRetentionStr)
+	}
+	return backupRetention, nil
+}
+
+func getCompressionThreshold() (int, error) {
+	compressionThresholdStr := os.Getenv("NOTES_API_COMPRESSION_THRESHOLD")
+	if compressionThresholdStr == "" {
+		return notesdb.DefaultCompressionThreshold, nil
+	}
+	compressionThreshold, err := strconv.Atoi(compressionThresholdStr)
+	if err != nil || compressionThreshold < 0 {
+		slog.Error("invalid value for NOTES_API_COMPRESSION_THRESHOLD; must be a non-negative number of bytes",
+			"compressionThresholdStr", compressionThresholdStr)
+		return 0, fmt.Errorf("invalid compression threshold: %s", compressionThresholdStr)
+	}
+	return compressionThreshold, nil
+}
+
+// initializeContentCipher loads the keys note content is encrypted with, if
+// any. It returns nil if encryption isn't configured.
+func initializeContentCipher() (*notesdb.ContentCipher, error) {
+	keysText := os.Getenv("NOTES_API_ENCRYPTION_KEY")
+	keyFile := os.Getenv("NOTES_API_ENCRYPTION_KEY_FILE")
+	if keysText != "" && keyFile != "" {
+		slog.Error("only one of NOTES_API_ENCRYPTION_KEY & NOTES_API_ENCRYPTION_KEY_FILE may be set")
+		return nil, fmt.Errorf("conflicting encryption key settings")
+	}
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			slog.Error("failed to read encryption key file",
+				"path", keyFile,
+				"err", err)
+			return nil, err
+		}
+		keysText = string(data)
+	}
+	if keysText == "" {
+		slog.Info("no encryption keys provided; note content is stored unencrypted")
+		return nil, nil
+	}
+
+	keys, err := notesdb.ParseContentKeys(keysText)
+	if err != nil {
+		slog.Error("failed to parse encryption keys", "err", err)
+		return nil, err
+	}
+	contentCipher, err := notesdb.NewContentCipher(keys)
+	if err != nil {
+		slog.Error("invalid encryption keys", "err", err)
+		return nil, err
+	}
+	slog.Info("encrypting note content", "keyID", contentCipher.CurrentKeyID(), "keys", len(keys))
+	return contentCipher, nil
+}
+
+func getDBPath() (string, error) {
+	var dbPath string
+	dbPathDir := os.Getenv("NOTES_API_DB_DIR")
+	if dbPathDir == "" {
+		if err := os.MkdirAll(NotesConfigDirectory, 0777); err != nil {
+			slog.Error("failed to create notes directory",
+				"path", NotesConfigDirectory,
+				"err", err)
+			return "", err
+		}
+		dbPath = path.Join(NotesConfigDirectory, DefaultNotesDatabaseName)
+		slog.Info("no path provided for DB; using default",
+			"path", dbPath)
+	} else {
+		slog.Info("given DB directory", "dir", dbPathDir)
+		if err := os.MkdirAll(dbPathDir, 0777); err != nil {
+			slog.Error("failed to create custom notes DB path parent",
+				"path", dbPathDir,
+				"err", err)
+			return "", err
+		}
+		dbPath = path.Join(dbPathDir, DefaultNotesDatabaseName)
+	}
+
+	if _, err := os.Stat(dbPath); err != nil && errors.Is(err, os.ErrNotExist) {
+		slog.Info("DB does not exist; it will be created during initialization",
+			"path", dbPath)
+	}
+	return dbPath, nil
+}
+
+func printHelp() {
+	fmt.Fprintf(os.Stderr, `
+notes-api [-h|--help|-?]
+notes-api migrate status|up [VERSION]|down [STEPS]
+notes-api assign-owner SUBJECT
+notes-api convert-content sql|file [NOTE_ID...]
+notes-api reindex-links
+notes-api rekey
+notes-api recompress
+notes-api backup
+notes-api restore BACKUP
+notes-api import-legacy [--dry-run] SUBJECT
+notes-api export [--format zip|tar.gz] SUBJECT [FILE]
+
+OPTIONS:
+	-h|--help|-?	Display this help message and exit
+
+COMMANDS:
+	(none)              Apply any pending migrations & run the API server
+	migrate status      List all schema migrations & whether they have been applied
+	migrate up          Apply pending migrations, up to VERSION if given
+	migrate down        Revert the last STEPS applied migrations (default: 1)
+	assign-owner        Give all notes without an owner to the user with the given token subject
+	convert-content     Move the content of the given notes (default: all notes) into the DB (sql) or into files (file)
+	reindex-links       Record the [[links]] in the content of every note, e.g. for notes written before links were tracked
+	rekey               Encrypt all note content with the current encryption key; safe to run while the server is up
+	recompress          Compress all note content per NOTES_API_COMPRESSION_THRESHOLD & report the savings; safe to run while the server is up
+	backup              Snapshot the DB into the backup directory & remove backups beyond NOTES_API_BACKUP_RETENTION; safe to run while the server is up
+	restore             Replace the DB with the given backup (a name in the backup directory or a path); stop the server first
+	import-legacy       Import the notes of the legacy index.txt store under NOTES_ROOT for the given token subject, skipping ones imported before
+	export              Write the notes of the given token subject as a Markdown archive to FILE (default: stdout); the format defaults to FILE's extension, or zip
+
+ENVIRONMENT VARIABLES:
+	NOTES_API_AUTH_PROVIDER_URL: (required) Base URL of the authorization server
+	NOTES_API_DB_DIR:            (optional) Path to directory where notes.sqlite is located (default: %s)
+	NOTES_API_PORT:              (optional) Port on which API should be hosted (default: %d)
+	NOTES_API_STORAGE:           (optional) Where notes are stored: sqlite or memory; memory is lost on exit (default: sqlite)
+	NOTES_API_CONTENT_DIR:       (optional) Path to directory where file-backed note content is kept (default: content/ next to notes.sqlite)
+	NOTES_API_DEFAULT_CONTENT_TYPE: (optional) Where the content of new notes is stored: sql or file (default: sql)
+	NOTES_API_ATTACHMENT_DIR:    (optional) Path to directory where note attachments are kept (default: attachments/ next to notes.sqlite)
+	NOTES_API_MAX_ATTACHMENT_SIZE: (optional) Largest attachment accepted, in bytes (default: %d)
+	NOTES_API_MAX_IMPORT_SIZE:   (optional) Largest archive accepted by POST /import, in bytes (default: %d)
+	NOTES_API_MAX_BODY_SIZE:     (optional) Largest body accepted by any other request, in bytes (default: %d)
+	NOTES_API_COMPRESSION_THRESHOLD: (optional) Note content of at least this many bytes is stored compressed; 0 turns compression off (default: %d)
+	NOTES_API_ENCRYPTION_KEY:    (optional) Keys note content is encrypted with, as ID:BASE64 entries separated by commas; the last one encrypts new content
+	NOTES_API_ENCRYPTION_KEY_FILE: (optional) Path to a file with keys in the same format, one per line, instead of NOTES_API_ENCRYPTION_KEY
+	NOTES_API_MAX_REVISIONS:     (optional) Number of revisions kept per note; 0 keeps all of them (default: %d)
+	NOTES_API_TRASH_RETENTION:   (optional) How long trashed notes are kept before being purged; 0 keeps them forever (default: %s)
+	NOTES_API_BACKUP_DIR:        (optional) Path to directory where DB backups are kept (default: backups/ next to notes.sqlite)
+	NOTES_API_BACKUP_INTERVAL:   (optional) How often the server backs up the DB; 0 only backs up on demand (default: %s)
+	NOTES_API_BACKUP_RETENTION:  (optional) Number of backups kept; 0 keeps all of them (default: %d)
+	NOTES_API_ADMIN_SUBJECTS:    (optional) Comma-separated token subjects allowed to use /admin endpoints (default: none)
+	NOTES_API_REMINDER_WEBHOOK_URL: (optional) URL reminders are POSTed to as JSON when they fire (default: none)
+	NOTES_ROOT:                  (optional) Directory of the legacy store read by import-legacy (default: ~/.notes)
+`,
+		NotesConfigDirectory,
+		DefaultPort,
+		DefaultMaxAttachmentSize,
+		DefaultMaxImportSize,
+		DefaultMaxBodySize,
+		notesdb.DefaultCompressionThreshold,
+		DefaultMaxRevisions,
+		DefaultTrashRetention,
+		DefaultBackupInterval,
+		DefaultBackupRetention)
+}
+
+func getNoteFromContext(c *fiber.Ctx) *notesdb.IndexEntry {
+	return c.Locals("note").(*notesdb.IndexEntry)
+}
+
+func getOwnerFromContext(c *fiber.Ctx) string {
+	return middleware.GetTokenSubject(c, TokenLocalName)
+}
+
+// getUserNameFromContext returns the name of the user from the claims of
+// their token, falling back to their subject.
+func getUserNameFromContext(c *fiber.Ctx) string {
+	for _, claim := range []string{"name", "preferred_username"} {
+		if name := middleware.GetTokenClaim(c, TokenLocalName, claim); name != "" {
+			return name
+		}
+	}
+	return getOwnerFromContext(c)
+}
+
+func (s *Server) ListNotes(c *fiber.Ctx) error {
+	owner := getOwnerFromContext(c)
+
+	filter := &notesdb.NoteFilter{}
+	for _, t := range c.Context().QueryArgs().PeekMulti("tag") {
+		filter.Tags = append(filter.Tags, string(t))
+	}
+	filter.Tags, _ = notesdb.NormalizeTags(filter.Tags)
+	switch tagMode := strings.ToLower(c.Query("tagMode", "and")); tagMode {
+	case "and":
+		filter.MatchAllTags = true
+	case "or":
+		filter.MatchAllTags = false
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid tagMode (expected 'and' or 'or'): %s", tagMode))
+	}
+
+	// Property filters look like prop.status=open or prop.priority>2, the
+	// latter arriving as a key without a value.
+	var err error
+	c.Context().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		name, ok := strings.CutPrefix(string(key), "prop.")
+		if !ok || err != nil {
+			return
+		}
+		expr := name
+		if len(value) > 0 || !strings.ContainsAny(name, "!=<>") {
+			expr += "=" + string(value)
+		}
+		var f *notesdb.PropertyFilter
+		if f, err = notesdb.ParsePropertyFilter(expr); err == nil {
+			filter.Properties = append(filter.Properties, f)
+		}
+	})
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	if filter.View, err = parseViewQuery(c); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+	if filter.UpdatedAfter, err = parseTimeQuery(c, "updated_after"); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(c, "created_before"); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	page := &notesdb.NotePage{
+		Sort:        notesdb.NoteSort(strings.ToLower(c.Query("sort", string(notesdb.NoteSortCreatedOn)))),
+		PinnedFirst: strings.ToLower(c.Query("pinnedFirst", "false")) == "true",
+		Cursor:      c.Query("cursor"),
+		Limit:       c.QueryInt("limit", DefaultListLimit),
+	}
+	switch page.Sort {
+	case notesdb.NoteSortCreatedOn, notesdb.NoteSortUpdatedOn, notesdb.NoteSortTitle:
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid sort (expected 'created_on', 'updated_on' or 'title'): %s", page.Sort))
+	}
+	switch order := strings.ToLower(c.Query("order", "asc")); order {
+	case "asc":
+		page.Descending = false
+	case "desc":
+		page.Descending = true
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid order (expected 'asc' or 'desc'): %s", order))
+	}
+	if page.Limit <= 0 || page.Limit > MaxListLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("limit must be between 1 and %d", MaxListLimit))
+	}
+
+	var notes any
+	var nextCursor string
+	includePreview := strings.ToLower(c.Query("includePreview", "false"))
+	if includePreview == "true" {
+		notes, nextCursor, err = s.Store.GetNotesWithPreview(owner, filter, page, 200)
+	} else {
+		notes, nextCursor, err = s.Store.GetNotes(owner, filter, page)
+	}
+	if err != nil && errors.Is(err, notesdb.ErrInvalidCursor) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	} else if err != nil {
+		slog.Error("failed to execute query to retrieve notes",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(&NotesPage{Notes: notes, NextCursor: nextCursor})
+}
+
+// parseTimeQuery parses the given query param as an RFC 3339 timestamp,
+// returning nil if it is absent.
+func parseTimeQuery(c *fiber.Ctx, param string) (*time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s (expected an RFC 3339 timestamp): %s", param, value)
+	}
+	return &t, nil
+}
+
+// parseViewQuery parses the view query param, which defaults to leaving out
+// archived notes.
+func parseViewQuery(c *fiber.Ctx) (notesdb.NoteView, error) {
+	view := notesdb.NoteView(strings.ToLower(c.Query("view", string(notesdb.NoteViewActive))))
+	if !slices.Contains(notesdb.NoteViews, view) {
+		return "", fmt.Errorf("invalid view (expected 'active', 'archived', 'favorites' or 'all'): %s", view)
+	}
+	return view, nil
+}
+
+func (s *Server) SearchNotes(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("query parameter 'q' is required")
+	}
+
+	limit := c.QueryInt("limit", DefaultSearchLimit)
+	if limit <= 0 || limit > MaxSearchLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("limit must be between 1 and %d", MaxSearchLimit))
+	}
+
+	results, err := s.Store.SearchNotes(getOwnerFromContext(c), query, limit)
+	if err != nil {
+		slog.Error("failed to execute search query",
+			"query", query,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(results)
+}
+
+// CreateNote creates a note from the request body, or from the template given
+// in the template query param (see createNoteFromTemplate).
+func (s *Server) CreateNote(c *fiber.Ctx) error {
+	if templateID := c.Query("template"); templateID != "" {
+		return s.createNoteFromTemplate(c, templateID)
+	}
+
+	data := &NoteRequest{}
+	err := json.Unmarshal(c.Body(), data)
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if data.Note == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("title is required")
+	}
+	return s.createNote(c, data.Note.Title, data.Content)
+}
+
+// createNoteFromTemplate creates a note with the title & content of the
+// template rendered with the params & in the time zone in the request body,
+// which may be empty.
+func (s *Server) createNoteFromTemplate(c *fiber.Ctx, templateID string) error {
+	id, err := strconv.ParseInt(templateID, 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid template: %s", templateID))
+	}
+	data := &TemplateNoteRequest{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), data); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+	}
+	location := time.UTC
+	if data.TimeZone != "" {
+		if location, err = time.LoadLocation(data.TimeZone); err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString(fmt.Sprintf("invalid time_zone: %s", data.TimeZone))
+		}
+	}
+
+	owner := getOwnerFromContext(c)
+	template, err := s.Store.GetTemplate(owner, id)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve template",
+			"templateID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if template == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("no template with id: %d", id))
+	}
+
+	templateData := notesdb.NewTemplateData(time.Now().In(location), getUserNameFromContext(c), owner, data.Params)
+	title, content, err := notesdb.RenderTemplate(template, templateData)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("failed to render template: %s", err))
+	}
+	return s.createNote(c, title, &content)
+}
+
+// createNote creates a note, with content if it isn't nil, & responds with it.
+func (s *Server) createNote(c *fiber.Ctx, title string, content *string) error {
+	owner := getOwnerFromContext(c)
+	var entry *notesdb.IndexEntry
+	err := s.Store.Atomically(func(tx notesdb.Store) error {
+		var err error
+		entry, err = tx.NewNote(owner, title)
+		if err != nil {
+			return err
+		}
+		if content != nil {
+			if err := tx.SetNoteContents(owner, entry.ID, []byte(*content), 0); err != nil {
+				return err
+			}
+		}
+		if err := tx.RecordRevision(owner, entry.ID, s.MaxRevisions); err != nil {
+			return err
+		}
+		entry, err = tx.GetNote(owner, entry.ID)
+		return err
+	})
+	if err != nil {
+		slog.Error("failed to create note",
+			"title", title,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Set(fiber.HeaderETag, middleware.NoteETag(entry.Version))
+	c.Status(fiber.StatusCreated)
+	return c.JSON(entry)
+}
+
+func (s *Server) GetNote(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	c.Set(fiber.HeaderETag, middleware.NoteETag(note.Version))
+	return c.JSON(note)
+}
+
+func (s *Server) UpdateNote(c *fiber.Ctx) error {
+	existingNote := getNoteFromContext(c)
+
+	newNote := &NoteRequest{}
+	err := json.Unmarshal(c.Body(), newNote)
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if newNote.Note == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("title is required")
+	}
+
+	var brokenLinks []*notes.NoteLink
+	if existingNote.Title != newNote.Title {
+		owner := getOwnerFromContext(c)
+		err := s.Store.Atomically(func(tx notesdb.Store) error {
+			backlinks, err := tx.GetBacklinks(owner, existingNote.ID)
+			if err != nil {
+				return err
+			}
+			if err := tx.UpdateNote(owner, existingNote.ID, newNote.Title, getExpectedVersion(c, existingNote)); err != nil {
+				return err
+			}
+			if err := tx.RecordRevision(owner, existingNote.ID, s.MaxRevisions); err != nil {
+				return err
+			}
+			brokenLinks, err = getBrokenLinks(tx, owner, existingNote.ID, backlinks)
+			return err
+		})
+		if err != nil && errors.Is(err, notesdb.ErrVersionConflict) {
+			return sendVersionConflict(c, existingNote)
+		} else if err != nil {
+			slog.Error("failed to update note",
+				"oldTitle", existingNote.Title,
+				"newTitle", newNote.Title,
+				"err", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		s.setNoteETag(c, owner, existingNote.ID)
+	}
+
+	if len(brokenLinks) > 0 {
+		return c.JSON(&UpdateNoteResponse{BrokenLinks: brokenLinks})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// getBrokenLinks returns the current state of the given links to the note
+// that no longer resolve to it, e.g. [[Title]] links after it was renamed.
+func getBrokenLinks(store notesdb.Store, owner string, id int64, links []*notes.NoteLink) ([]*notes.NoteLink, error) {
+	broken := []*notes.NoteLink{}
+	if len(links) == 0 {
+		return broken, nil
+	}
+
+	stillLinked := map[string]bool{}
+	backlinks, err := store.GetBacklinks(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range backlinks {
+		stillLinked[fmt.Sprintf("%d:%s", link.SourceID, link.Text)] = true
+	}
+
+	for _, link := range links {
+		if stillLinked[fmt.Sprintf("%d:%s", link.SourceID, link.Text)] {
+			continue
+		}
+		current, err := store.GetNoteLinks(owner, link.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range current {
+			if c.Text == link.Text {
+				broken = append(broken, c)
+				break
+			}
+		}
+	}
+	return broken, nil
+}
+
+func (s *Server) DeleteNote(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	id := note.ID
+	if err := s.Store.DeleteNote(getOwnerFromContext(c), id); err != nil {
+		slog.Error("failed to remove note",
+			"err", err,
+			"noteID", id)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) GetNoteContent(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	content, err := s.Store.GetNoteContents(getOwnerFromContext(c), note.ID)
+	if err != nil && errors.Is(err, notesdb.ErrInvalidContentType) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	} else if err != nil {
+		slog.Error("failed to read note contents",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	c.Set(fiber.HeaderETag, middleware.NoteETag(note.Version))
+	stream := bytes.NewBuffer(content)
+	return c.SendStream(stream, len(content))
+}
+
+func (s *Server) UpdateNoteContent(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	owner := getOwnerFromContext(c)
+
+	content := []byte(c.FormValue("content"))
+	if len(content) == 0 {
+		fileHeader, err := c.FormFile("content")
+		if err != nil && errors.Is(err, http.ErrMissingFile) {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString("either form value or form file required for 'content' form field")
+		} else if err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString(fmt.Sprintf("unexpected error when reading form file: %s", err))
+		}
+		// TODO: Don't read entire file into memory at once
+		file, err := fileHeader.Open()
+		if err != nil {
+			slog.Error("failed to read open file header",
+				"err", err)
+			c.Status(fiber.StatusInternalServerError)
+			return c.SendString("failed to read form file")
+		}
+		content, err = utils.ReadToEnd(file)
+		if err != nil {
+			slog.Error("failed to read request file body",
+				"err", err)
+			c.Status(fiber.StatusInternalServerError)
+			return c.SendString("failed to read form file")
+		}
+	}
+
+	err := s.Store.Atomically(func(tx notesdb.Store) error {
+		if err := tx.SetNoteContents(owner, note.ID, content, getExpectedVersion(c, note)); err != nil {
+			return err
+		}
+		return tx.RecordRevision(owner, note.ID, s.MaxRevisions)
+	})
+	if err != nil && errors.Is(err, notesdb.ErrVersionConflict) {
+		return sendVersionConflict(c, note)
+	} else if err != nil && errors.Is(err, notesdb.ErrNoteNotFound) {
+		// The note was deleted after it was looked up.
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no note with id: %d", note.ID))
+	} else if err != nil {
+		slog.Error("failed to save file contents",
+			"err", err,
+			"noteID", note.ID)
+		c.Status(fiber.StatusInternalServerError)
+		return c.SendString("failed to save file contents")
+	}
+
+	s.setNoteETag(c, owner, note.ID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// getExpectedVersion returns the version of the note that the request's
+// If-Match header was checked against by middleware.CheckNoteIfMatch, or 0 if
+// the request is unconditional.
+func getExpectedVersion(c *fiber.Ctx, note *notesdb.IndexEntry) int64 {
+	ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0
+	}
+	return note.Version
+}
+
+// sendVersionConflict responds to a conditional request for a note that was
+// modified after its If-Match header was checked.
+func sendVersionConflict(c *fiber.Ctx, note *notesdb.IndexEntry) error {
+	c.Status(fiber.StatusPreconditionFailed)
+	return c.SendString(fmt.Sprintf("note %d has been modified since version %d", note.ID, note.Version))
+}
+
+// setNoteETag sets the ETag of the response to the current version of the
+// note, so clients can make their next update conditional without another GET.
+func (s *Server) setNoteETag(c *fiber.Ctx, owner string, id int64) {
+	note, err := s.Store.GetNote(owner, id)
+	if err != nil {
+		slog.Warn("failed to load note for ETag",
+			"noteID", id,
+			"err", err)
+		return
+	}
+	if note != nil {
+		c.Set(fiber.HeaderETag, middleware.NoteETag(note.Version))
+	}
+}
+
+func (s *Server) AddNoteTags(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+
+	data := &TagsRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	tags, err := notesdb.NormalizeTags(data.Tags)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	if err := s.Store.AddTags(getOwnerFromContext(c), note.ID, tags); err != nil {
+		slog.Error("failed to add tags to note",
+			"noteID", note.ID,
+			"tags", tags,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) RemoveNoteTag(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	tag, err := url.PathUnescape(c.Params("tag"))
+	if err != nil || strings.TrimSpace(tag) == "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	if err := s.Store.RemoveTag(getOwnerFromContext(c), note.ID, strings.TrimSpace(tag)); err != nil {
+		slog.Error("failed to remove tag from note",
+			"noteID", note.ID,
+			"tag", tag,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetNoteProperties applies a JSON merge patch to the note's properties &
+// responds with the resulting properties.
+func (s *Server) SetNoteProperties(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	owner := getOwnerFromContext(c)
+
+	patch, err := notesdb.ParsePropertyPatch(c.Body())
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	if err := s.Store.SetProperties(owner, note.ID, patch); err != nil {
+		slog.Error("failed to set note properties",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	entry, err := s.Store.GetNote(owner, note.ID)
+	if err != nil || entry == nil {
+		slog.Error("failed to load note after setting properties",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(entry.Properties)
+}
+
+// SetNoteState returns a handler that sets (PUT) or clears (DELETE) the state
+// on the note.
+func (s *Server) SetNoteState(state notesdb.NoteState, on bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		note := getNoteFromContext(c)
+		if err := s.Store.SetNoteState(getOwnerFromContext(c), note.ID, state, on); err != nil {
+			slog.Error("failed to set note state",
+				"noteID", note.ID,
+				"state", state,
+				"on", on,
+				"err", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func (s *Server) MoveNote(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+
+	data := &MoveNoteRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	err := s.Store.MoveNote(getOwnerFromContext(c), note.ID, data.NotebookID)
+	if err != nil && errors.Is(err, notesdb.ErrNotebookNotFound) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("no notebook with id: %d", *data.NotebookID))
+	} else if err != nil {
+		slog.Error("failed to move note",
+			"noteID", note.ID,
+			"notebookID", data.NotebookID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) ListTags(c *fiber.Ctx) error {
+	tags, err := s.Store.GetTags(getOwnerFromContext(c))
+	if err != nil {
+		slog.Error("failed to execute query to retrieve tags",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(tags)
+}
+
+func (s *Server) ListRevisions(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	revisions, err := s.Store.GetRevisions(getOwnerFromContext(c), note.ID)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve revisions",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(revisions)
+}
+
+func (s *Server) GetRevision(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	rev, err := strconv.ParseInt(c.Params("rev"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	revision, err := s.Store.GetRevision(getOwnerFromContext(c), note.ID, rev)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve revision",
+			"noteID", note.ID,
+			"revision", rev,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if revision == nil {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no revision %d for note with id: %d", rev, note.ID))
+	}
+	return c.JSON(revision)
+}
+
+func (s *Server) RestoreRevision(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	owner := getOwnerFromContext(c)
+	rev, err := strconv.ParseInt(c.Params("rev"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	revision, err := s.Store.GetRevision(owner, note.ID, rev)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve revision",
+			"noteID", note.ID,
+			"revision", rev,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if revision == nil {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no revision %d for note with id: %d", rev, note.ID))
+	}
+
+	err = s.Store.Atomically(func(tx notesdb.Store) error {
+		if err := tx.UpdateNote(owner, note.ID, revision.Title, getExpectedVersion(c, note)); err != nil {
+			return err
+		}
+		if err := tx.SetNoteContents(owner, note.ID, []byte(revision.Content), 0); err != nil {
+			return err
+		}
+		return tx.RecordRevision(owner, note.ID, s.MaxRevisions)
+	})
+	if err != nil && errors.Is(err, notesdb.ErrVersionConflict) {
+		return sendVersionConflict(c, note)
+	} else if err != nil && errors.Is(err, notesdb.ErrNoteNotFound) {
+		// The note was deleted after it was looked up.
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no note with id: %d", note.ID))
+	} else if err != nil {
+		slog.Error("failed to restore note revision",
+			"noteID", note.ID,
+			"revision", rev,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	s.setNoteETag(c, owner, note.ID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Attachment-related controllers
+
+var errTooLarge = errors.New("request body is too large")
+
+func (s *Server) ListAttachments(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	attachments, err := s.Store.GetAttachments(getOwnerFromContext(c), note.ID)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve attachments",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(attachments)
+}
+
+// AddAttachment stores the 'file' field of the multipart form as a new
+// attachment. The file is streamed straight from the request into the store.
+func (s *Server) AddAttachment(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+
+	part, body, errMsg := openFormFile(c)
+	success := false
+	defer func() { finishFormFile(c, body, success) }()
+	if part == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(errMsg)
+	}
+	defer part.Close()
+
+	name := filepath.Base(part.FileName())
+	data := bufio.NewReader(part)
+	mimeType := getAttachmentMimeType(name, part.Header.Get(fiber.HeaderContentType), data)
+
+	attachment, err := s.Store.AddAttachment(getOwnerFromContext(c), note.ID, name, mimeType,
+		&maxSizeReader{r: data, remaining: s.MaxAttachmentSize})
+	if err != nil && errors.Is(err, errTooLarge) {
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return c.SendString(fmt.Sprintf("attachment is larger than the maximum of %d bytes", s.MaxAttachmentSize))
+	} else if err != nil {
+		slog.Error("failed to add attachment",
+			"noteID", note.ID,
+			"name", name,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if attachment == nil {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no note with id: %d", note.ID))
+	}
+	success = true
+	c.Status(fiber.StatusCreated)
+	return c.JSON(attachment)
+}
+
+func (s *Server) GetAttachment(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	owner := getOwnerFromContext(c)
+	id, err := strconv.ParseInt(c.Params("attachmentID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	attachment, err := s.Store.GetAttachment(owner, note.ID, id)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve attachment",
+			"noteID", note.ID,
+			"attachmentID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if attachment == nil {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no attachment %d for note with id: %d", id, note.ID))
+	}
+
+	data, err := s.Store.OpenAttachment(owner, note.ID, id)
+	if err != nil || data == nil {
+		slog.Error("failed to open attachment",
+			"noteID", note.ID,
+			"attachmentID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// The MIME type is whatever the uploader claimed, so browsers mustn't
+	// sniff it into something they would render.
+	c.Set(fiber.HeaderContentType, attachment.MimeType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	// The response closes data once it has been sent.
+	return c.SendStream(data, int(attachment.Size))
+}
+
+func (s *Server) DeleteAttachment(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	id, err := strconv.ParseInt(c.Params("attachmentID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	found, err := s.Store.DeleteAttachment(getOwnerFromContext(c), note.ID, id)
+	if err != nil {
+		slog.Error("failed to delete attachment",
+			"noteID", note.ID,
+			"attachmentID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if !found {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no attachment %d for note with id: %d", id, note.ID))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// getAttachmentMimeType returns the MIME type the client gave for the file,
+// falling back to one based on its extension & then on its first bytes.
+func getAttachmentMimeType(name string, given string, data *bufio.Reader) string {
+	if given != "" && given != "application/octet-stream" {
+		return given
+	}
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	// Peek returns what it can along with an error for files under 512 bytes.
+	head, _ := data.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// openFormFile finds the file in the 'file' field of a multipart/form-data
+// request, reading the body as a stream. If there isn't one the part is nil &
+// the message says why. finishFormFile must be called with the returned body
+// once the request has been handled.
+func openFormFile(c *fiber.Ctx) (*multipart.Part, io.Reader, string) {
+	body := c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, body, "expected a multipart/form-data request"
+	}
+
+	reader := multipart.NewReader(body, boundary)
+	for {
+		p, err := reader.NextPart()
+		if err != nil && errors.Is(err, io.EOF) {
+			return nil, body, "form file required for 'file' form field"
+		} else if err != nil {
+			return nil, body, fmt.Sprintf("unexpected error when reading multipart form: %s", err)
+		}
+		if p.FormName() == "file" && p.FileName() != "" {
+			return p, body, ""
+		}
+	}
+}
+
+// finishFormFile deals with what is left of the body. Any part of a streamed
+// body left unread would be taken as the start of the next request on the
+// connection, so it is drained on success. On failure there may be a lot left,
+// so the connection is closed instead.
+func finishFormFile(c *fiber.Ctx, body io.Reader, success bool) {
+	if success {
+		io.Copy(io.Discard, body)
+	} else {
+		c.Context().SetConnectionClose()
+	}
+}
+
+// LimitBodySize responds with 413 to requests whose body is larger than
+// MaxBodySize. Streaming request bodies turns off fiber's own limit, since
+// bodies past it are streamed rather than refused, so without this c.Body()
+// would read bodies of any size into memory. Uploads are left to their
+// handlers, which stream them with limits of their own.
+func (s *Server) LimitBodySize(c *fiber.Ctx) error {
+	if isUploadRoute(c) {
+		return c.Next()
+	}
+	req := c.Request()
+	tooLarge := func() error {
+		// Whatever is left of the body would be read as the next request.
+		c.Context().SetConnectionClose()
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return c.SendString(fmt.Sprintf("request body is larger than the maximum of %d bytes", s.MaxBodySize))
+	}
+	if int64(req.Header.ContentLength()) > s.MaxBodySize {
+		return tooLarge()
+	}
+	if req.IsBodyStream() {
+		body, err := io.ReadAll(&maxSizeReader{r: req.BodyStream(), remaining: s.MaxBodySize})
+		if err != nil && errors.Is(err, errTooLarge) {
+			return tooLarge()
+		} else if err != nil {
+			c.Context().SetConnectionClose()
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString("failed to read request body")
+		}
+		req.SetBody(body)
+	} else if int64(len(req.Body())) > s.MaxBodySize {
+		return tooLarge()
+	}
+	return c.Next()
+}
+
+// isUploadRoute returns whether the request is an attachment upload or an
+// import, which stream their bodies.
+func isUploadRoute(c *fiber.Ctx) bool {
+	if c.Method() != fiber.MethodPost {
+		return false
+	}
+	segments := strings.Split(strings.Trim(strings.ToLower(c.Path()), "/"), "/")
+	switch {
+	case len(segments) == 1:
+		return segments[0] == "import"
+	case len(segments) == 3:
+		return segments[0] == "notes" && segments[2] == "attachments"
+	}
+	return false
+}
+
+// maxSizeReader fails with errTooLarge once more than remaining
+// bytes have been read from r.
+type maxSizeReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// Link-related controllers
+
+func (s *Server) ListNoteLinks(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	links, err := s.Store.GetNoteLinks(getOwnerFromContext(c), note.ID)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve note links",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(links)
+}
+
+func (s *Server) ListBacklinks(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	links, err := s.Store.GetBacklinks(getOwnerFromContext(c), note.ID)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve backlinks",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(links)
+}
+
+func (s *Server) GetNoteGraph(c *fiber.Ctx) error {
+	graph, err := s.Store.GetNoteGraph(getOwnerFromContext(c))
+	if err != nil {
+		slog.Error("failed to execute query to retrieve note graph",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(graph)
+}
+
+// Notebook-related controllers
+
+func getNotebookFromContext(c *fiber.Ctx) *notes.Notebook {
+	return c.Locals(NotebookLocalName).(*notes.Notebook)
+}
+
+func (s *Server) ListNotebooks(c *fiber.Ctx) error {
+	notebooks, err := s.Store.GetNotebooks(getOwnerFromContext(c))
+	if err != nil {
+		slog.Error("failed to execute query to retrieve notebooks",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(notebooks)
+}
+
+func (s *Server) CreateNotebook(c *fiber.Ctx) error {
+	data := &NotebookRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("notebook name is required")
+	}
+	parentID, _, err := data.parseParentID()
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	notebook, err := s.Store.NewNotebook(getOwnerFromContext(c), name, parentID)
+	if err != nil && errors.Is(err, notesdb.ErrNotebookNotFound) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("no notebook with id: %d", *parentID))
+	} else if err != nil {
+		slog.Error("failed to create notebook",
+			"name", name,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(notebook)
+}
+
+func (s *Server) GetNotebook(c *fiber.Ctx) error {
+	return c.JSON(getNotebookFromContext(c))
+}
+
+func (s *Server) UpdateNotebook(c *fiber.Ctx) error {
+	existing := getNotebookFromContext(c)
+
+	data := &NotebookRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		name = existing.Name
+	}
+	parentID, present, err := data.parseParentID()
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+	if !present {
+		parentID = existing.ParentID
+	}
+
+	err = s.Store.UpdateNotebook(getOwnerFromContext(c), existing.ID, name, parentID)
+	if err != nil && errors.Is(err, notesdb.ErrNotebookNotFound) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("no notebook with id: %d", *parentID))
+	} else if err != nil && errors.Is(err, notesdb.ErrNotebookCycle) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	} else if err != nil {
+		slog.Error("failed to update notebook",
+			"notebookID", existing.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) DeleteNotebook(c *fiber.Ctx) error {
+	notebook := getNotebookFromContext(c)
+
+	policy := notesdb.NotebookDeletePolicy(strings.ToLower(c.Query("policy", string(notesdb.NotebookDeleteReject))))
+	switch policy {
+	case notesdb.NotebookDeleteReject, notesdb.NotebookDeleteTrash, notesdb.NotebookDeleteReparent:
+	default:
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid policy (expected 'reject', 'trash' or 'reparent'): %s", policy))
+	}
+
+	err := s.Store.DeleteNotebook(getOwnerFromContext(c), notebook.ID, policy)
+	if err != nil && errors.Is(err, notesdb.ErrNotebookNotEmpty) {
+		c.Status(fiber.StatusConflict)
+		return c.SendString("notebook is not empty; use policy=trash or policy=reparent to delete it anyway")
+	} else if err != nil {
+		slog.Error("failed to delete notebook",
+			"notebookID", notebook.ID,
+			"policy", policy,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) ListNotebookNotes(c *fiber.Ctx) error {
+	notebook := getNotebookFromContext(c)
+	view, err := parseViewQuery(c)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+	filter := &notesdb.NoteFilter{
+		NotebookID:          &notebook.ID,
+		IncludeSubNotebooks: strings.ToLower(c.Query("recursive", "false")) == "true",
+		View:                view,
+	}
+
+	notes, _, err := s.Store.GetNotes(getOwnerFromContext(c), filter, nil)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve notebook notes",
+			"notebookID", notebook.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(notes)
+}
+
+// Template-related controllers
+
+func getTemplateFromContext(c *fiber.Ctx) *notes.Template {
+	return c.Locals(TemplateLocalName).(*notes.Template)
+}
+
+func (s *Server) ListTemplates(c *fiber.Ctx) error {
+	templates, err := s.Store.GetTemplates(getOwnerFromContext(c))
+	if err != nil {
+		slog.Error("failed to execute query to retrieve templates",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(templates)
+}
+
+func (s *Server) CreateTemplate(c *fiber.Ctx) error {
+	data := &TemplateRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("template name is required")
+	}
+	title, content := name, ""
+	if data.Title != nil {
+		title = *data.Title
+	}
+	if data.Content != nil {
+		content = *data.Content
+	}
+	if err := notesdb.ValidateTemplate(title, content); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid template: %s", err))
+	}
+
+	template, err := s.Store.NewTemplate(getOwnerFromContext(c), name, title, content)
+	if err != nil {
+		slog.Error("failed to create template",
+			"name", name,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(template)
+}
+
+func (s *Server) GetTemplate(c *fiber.Ctx) error {
+	return c.JSON(getTemplateFromContext(c))
+}
+
+// UpdateTemplate changes the fields given in the request, leaving the others
+// alone.
+func (s *Server) UpdateTemplate(c *fiber.Ctx) error {
+	existing := getTemplateFromContext(c)
+
+	data := &TemplateRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		name = existing.Name
+	}
+	title, content := existing.Title, existing.Content
+	if data.Title != nil {
+		title = *data.Title
+	}
+	if data.Content != nil {
+		content = *data.Content
+	}
+	if err := notesdb.ValidateTemplate(title, content); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid template: %s", err))
+	}
+
+	if err := s.Store.UpdateTemplate(getOwnerFromContext(c), existing.ID, name, title, content); err != nil {
+		slog.Error("failed to update template",
+			"templateID", existing.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *Server) DeleteTemplate(c *fiber.Ctx) error {
+	template := getTemplateFromContext(c)
+	if err := s.Store.DeleteTemplate(getOwnerFromContext(c), template.ID); err != nil {
+		slog.Error("failed to delete template",
+			"templateID", template.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Reminder-related controllers
+
+// SetReminder sets when the note is due & when to be reminded of it, replacing
+// any reminder it had, & responds with the updated note.
+func (s *Server) SetReminder(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	owner := getOwnerFromContext(c)
+
+	data := &ReminderRequest{}
+	if err := json.Unmarshal(c.Body(), data); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	recurrence := notesdb.Recurrence(data.Recurrence)
+	if err := notesdb.ValidateReminder(data.RemindAt, recurrence); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+
+	if err := s.Store.SetReminder(owner, note.ID, data.DueOn, data.RemindAt, recurrence); err != nil {
+		slog.Error("failed to set reminder",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	s.Reminders.Wake()
+
+	entry, err := s.Store.GetNote(owner, note.ID)
+	if err != nil || entry == nil {
+		slog.Error("failed to load note after setting reminder",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(entry)
+}
+
+// ClearReminder removes the note's due date & reminder.
+func (s *Server) ClearReminder(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	if err := s.Store.SetReminder(getOwnerFromContext(c), note.ID, nil, nil, notesdb.RecurrenceNone); err != nil {
+		slog.Error("failed to clear reminder",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListUpcomingReminders lists the reminders that fire within the given
+// duration (default: a week), soonest first, including overdue ones.
+func (s *Server) ListUpcomingReminders(c *fiber.Ctx) error {
+	within := DefaultUpcomingWindow
+	if withinStr := c.Query("within"); withinStr != "" {
+		var err error
+		within, err = time.ParseDuration(withinStr)
+		if err != nil || within <= 0 {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString(fmt.Sprintf("invalid within (expected a positive duration, e.g. 24h): %s", withinStr))
+		}
+	}
+	limit := c.QueryInt("limit", DefaultListLimit)
+	if limit <= 0 || limit > MaxListLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("limit must be between 1 and %d", MaxListLimit))
+	}
+
+	reminders, err := s.Store.GetUpcomingReminders(getOwnerFromContext(c), time.Now().Add(within), limit)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve upcoming reminders",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(reminders)
+}
+
+func (s *Server) ListNotifications(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", DefaultListLimit)
+	if limit <= 0 || limit > MaxListLimit {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("limit must be between 1 and %d", MaxListLimit))
+	}
+
+	notifications, err := s.Store.GetNotifications(getOwnerFromContext(c), c.QueryBool("unread"), limit)
+	if err != nil {
+		slog.Error("failed to execute query to retrieve notifications",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(notifications)
+}
+
+func (s *Server) MarkNotificationRead(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("notificationID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
+
+	found, err := s.Store.MarkNotificationRead(getOwnerFromContext(c), id)
+	if err != nil {
+		slog.Error("failed to mark notification as read",
+			"notificationID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	if !found {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no notification with id: %d", id))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
 
-	db, err := notesdb.Initialize(dbPath)
+// Share-related controllers
+
+// sharedNotePage is the page shared notes are served as in ShareModeHTML.
+var sharedNotePage = htmltemplate.Must(htmltemplate.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 50em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; }
+pre { white-space: pre-wrap; word-wrap: break-word; font-family: inherit; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<pre>{{.Content}}</pre>
+</body>
+</html>
+`))
+
+func (s *Server) ListShares(c *fiber.Ctx) error {
+	shares, err := s.Store.GetShares(getOwnerFromContext(c), nil)
 	if err != nil {
-		fmt.Printf("failed to initialize: %s\n", err)
-		return 1
+		slog.Error("failed to execute query to retrieve shares",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-	DB = db
-	defer DB.Close()
+	return c.JSON(shares)
+}
 
-	portStr := os.Getenv("NOTES_API_PORT")
-	port, err := strconv.Atoi(portStr)
+func (s *Server) ListNoteShares(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
+	shares, err := s.Store.GetShares(getOwnerFromContext(c), &note.ID)
 	if err != nil {
-		port = DefaultPort
-		slog.Info("no valid port provided via NOTES_API_PORT, using default",
-			"portStr", portStr,
-			"defaultPort", port)
-	} else {
-		slog.Info("using custom port",
-			"port", port)
+		slog.Error("failed to execute query to retrieve shares",
+			"noteID", note.ID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	return c.JSON(shares)
+}
 
-	disableAuth := false
-	disableAuthOption := strings.TrimSpace(os.Getenv("NOTES_API_DISABLE_AUTH"))
-	if disableAuthOption != "" {
-		slog.Warn("disabling authentication framework - THIS SHOULD ONLY BE RUN FOR TESTING!")
-		disableAuth = true
-	}
+// CreateShare creates a public link to the note. The response is the only
+// place its token is ever returned.
+func (s *Server) CreateShare(c *fiber.Ctx) error {
+	note := getNoteFromContext(c)
 
-	if !disableAuth {
-		authProviderUrl := os.Getenv("NOTES_API_AUTH_PROVIDER_URL")
-		if authProviderUrl == "" {
-			panic("Required value for NOTES_API_AUTH_PROVIDER_URL but none provided")
-		}
-		redirectUrl := os.Getenv("NOTES_API_REDIRECT_URL")
-		if redirectUrl == "" {
-			panic("Required value for NOTES_API_REDIRECT_URL but none provided")
+	data := &ShareRequest{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), data); err != nil {
+			return c.SendStatus(fiber.StatusBadRequest)
 		}
-		auth.InitializeAuth(context.Background(), authProviderUrl, redirectUrl)
-	} else {
-		slog.Warn("skipping initialization of authentication framework", "disableAuth", disableAuth)
 	}
-
-	allowedOrigins := os.Getenv("NOTES_API_ALLOWED_ORIGINS")
-	if allowedOrigins == "" {
-		allowedOrigins = "*"
+	if data.Mode == "" {
+		data.Mode = notesdb.ShareModeText
 	}
-	slog.Info("setting CORS allowed origins", "origins", allowedOrigins)
-
-	app := fiber.New()
-	app.Use(requestid.New(), logger.New(), recover.New())
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: allowedOrigins,
-	}))
-	app.Route("/notes", func(notes fiber.Router) {
-		if !disableAuth {
-			notes.Use(middleware.ValidateAccessToken(TokenLocalName, TokenCookieName))
-		} else {
-			slog.Warn("skipping registration of token validation middleware", "disableAuth", disableAuth)
-		}
-		notes.Get("/", ListNotes)
-		notes.Post("/", CreateNote)
-		notes.Route("/:noteID", func(note fiber.Router) {
-			note.Use(middleware.LoadNoteFromRoute(NoteLocalName, "noteID", DB))
-			note.Get("/", GetNote)
-			note.Post("/", UpdateNote)
-			note.Delete("/", DeleteNote)
-			note.Get("/content", GetNoteContent)
-			note.Post("/content", UpdateNoteContent)
-		})
-	})
-	if !disableAuth {
-		app.Route("/auth", func(auth fiber.Router) {
-			auth.Get("/login", Login)
-			auth.Get("/logout", Logout)
-			auth.Get("/callback", AuthCallback)
-		})
-	} else {
-		slog.Warn("skipping registration of authentication-related endpoints", "disableAuth", disableAuth)
+	if err := notesdb.ValidateShare(data.Mode, data.Password, data.ExpiresOn, time.Now()); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
 	}
 
-	slog.Info("listening for requests", "port", port)
-	err = app.Listen(fmt.Sprintf(":%d", port))
+	share, err := s.Store.NewShare(getOwnerFromContext(c), note.ID, data.Mode, data.Password, data.ExpiresOn)
 	if err != nil {
-		// TODO: do we get this error if it fails to initialize or if it just fails?
-		slog.Error("failed to initialize HTTP server",
+		slog.Error("failed to create share",
+			"noteID", note.ID,
 			"err", err)
-		return 1
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-	return 0
+	if share == nil {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no note with id: %d", note.ID))
+	}
+	slog.Info("created share", "shareID", share.ID, "noteID", note.ID, "mode", share.Mode, "expiresOn", share.ExpiresOn)
+	c.Status(fiber.StatusCreated)
+	return c.JSON(share)
 }
 
-func printHelp() {
-	fmt.Fprintf(os.Stderr, `
-notes-api [-h|--help|-?]
-
-OPTIONS:
-	-h|--help|-?	Display this help message and exit
+// RevokeShare stops the share from working. It is kept, along with its access
+// count, until its note is purged.
+func (s *Server) RevokeShare(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("shareID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
+	}
 
-ENVIRONMENT VARIABLES:
-	NOTES_API_AUTH_PROVIDER_URL: (required) Base URL of the authorization server
-	NOTES_API_DB_DIR:            (optional) Path to directory where notes.sqlite is located (default: %s)
-	NOTES_API_PORT:              (optional) Port on which API should be hosted (default: %d)
-`,
-		NotesConfigDirectory,
-		DefaultPort)
+	found, err := s.Store.RevokeShare(getOwnerFromContext(c), id)
+	if err != nil {
+		slog.Error("failed to revoke share",
+			"shareID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if !found {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no share with id: %d", id))
+	}
+	slog.Info("revoked share", "shareID", id)
+	return c.SendStatus(fiber.StatusNoContent)
 }
 
-func getNoteFromContext(c *fiber.Ctx) *notesdb.IndexEntry {
-	return c.Locals("note").(*notesdb.IndexEntry)
-}
+// GetSharedNote serves the note behind a share token to anyone holding it.
+// Password-protected shares take the password through HTTP basic auth (with
+// any user name), so browsers prompt for it. Unknown, revoked & expired
+// shares, and shares of trashed notes, all look the same: not found.
+func (s *Server) GetSharedNote(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderXRobotsTag, "noindex")
 
-func ListNotes(c *fiber.Ctx) error {
-	includePreview := strings.ToLower(c.Query("includePreview", "false"))
-	if includePreview == "true" {
-		notes, err := notesdb.GetNotesWithPreview(DB, 200)
-		if err != nil {
-			slog.Error("failed to execute query to retrieve notes",
-				"err", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
-		}
-		return c.JSON(notes)
-	} else {
-		notes, err := notesdb.GetNotes(DB)
-		if err != nil {
-			slog.Error("failed to execute query to retrieve notes",
-				"err", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+	share, err := s.Store.GetShareByToken(c.Params("token"))
+	if err != nil {
+		slog.Error("failed to look up share",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	now := time.Now()
+	if share == nil || !share.IsActive(now) {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	if share.HasPassword {
+		_, password, given := parseBasicAuth(c.Get(fiber.HeaderAuthorization))
+		if !given || !share.CheckPassword(password) {
+			// Browsers only send a password once prompted for one.
+			if given {
+				slog.Warn("denied access to shared note", "shareID", share.ID, "noteID", share.NoteID, "ip", c.IP())
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Shared note", charset="UTF-8"`)
+			return c.SendStatus(fiber.StatusUnauthorized)
 		}
-		return c.JSON(notes)
 	}
-}
 
-func CreateNote(c *fiber.Ctx) error {
-	data := &NoteRequest{}
-	err := json.Unmarshal(c.Body(), data)
+	note, err := s.Store.GetNote(share.Owner, share.NoteID)
 	if err != nil {
-		return c.SendStatus(fiber.StatusBadRequest)
+		slog.Error("failed to load shared note",
+			"shareID", share.ID,
+			"noteID", share.NoteID,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-
-	entry, err := notesdb.NewNote(DB, data.Note.Title)
+	if note == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	content, err := s.Store.GetNoteContents(share.Owner, share.NoteID)
 	if err != nil {
-		slog.Error("failed to create note",
-			"title", data.Note.Title,
+		slog.Error("failed to load shared note content",
+			"shareID", share.ID,
+			"noteID", share.NoteID,
 			"err", err)
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-	c.Status(fiber.StatusCreated)
-	return c.JSON(entry)
+
+	if err := s.Store.RecordShareAccess(share.ID, now); err != nil {
+		// The note is readable either way, so this isn't worth failing over.
+		slog.Warn("failed to record share access",
+			"shareID", share.ID,
+			"err", err)
+	}
+	slog.Info("accessed shared note", "shareID", share.ID, "noteID", share.NoteID, "ip", c.IP())
+
+	if share.Mode == notesdb.ShareModeHTML {
+		c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; style-src 'unsafe-inline'")
+		c.Type("html", "utf-8")
+		return sharedNotePage.Execute(c, map[string]string{"Title": note.Title, "Content": string(content)})
+	}
+	c.Set(fiber.HeaderContentType, "text/plain; charset=utf-8")
+	return c.Send(content)
 }
 
-func GetNote(c *fiber.Ctx) error {
-	note := getNoteFromContext(c)
-	return c.JSON(note)
+// parseBasicAuth parses the credentials of an HTTP basic Authorization
+// header.
+func parseBasicAuth(header string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(header, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
 }
 
-func UpdateNote(c *fiber.Ctx) error {
-	existingNote := getNoteFromContext(c)
+// Trash-related controllers
 
-	newNote := &NoteRequest{}
-	err := json.Unmarshal(c.Body(), newNote)
+func (s *Server) ListTrash(c *fiber.Ctx) error {
+	entries, err := s.Store.GetTrashedNotes(getOwnerFromContext(c))
 	if err != nil {
-		return c.SendStatus(fiber.StatusBadRequest)
+		slog.Error("failed to execute query to retrieve trashed notes",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	return c.JSON(entries)
+}
 
-	if existingNote.Title != newNote.Title {
-		err := notesdb.UpdateNote(DB, existingNote.ID, newNote.Title)
-		if err != nil {
-			slog.Error("failed to update note",
-				"oldTitle", existingNote.Title,
-				"newTitle", newNote.Title,
-				"err", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
-		}
+func (s *Server) RestoreNote(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("noteID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
 	}
 
+	found, err := s.Store.RestoreNote(getOwnerFromContext(c), id)
+	if err != nil {
+		slog.Error("failed to restore note from trash",
+			"noteID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if !found {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no trashed note with id: %d", id))
+	}
+	// Reminders of trashed notes don't fire, so one may be due now.
+	s.Reminders.Wake()
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-func DeleteNote(c *fiber.Ctx) error {
-	note := getNoteFromContext(c)
-	id := note.ID
-	if err := notesdb.DeleteNote(DB, id); err != nil {
-		slog.Error("failed to remove note",
-			"err", err,
-			"noteID", id)
-		return c.SendStatus(fiber.StatusInternalServerError)
+func (s *Server) PurgeNote(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("noteID"), 10, 64)
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString("invalid request")
 	}
 
+	found, err := s.Store.PurgeNote(getOwnerFromContext(c), id)
+	if err != nil {
+		slog.Error("failed to purge note from trash",
+			"noteID", id,
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if !found {
+		c.Status(fiber.StatusNotFound)
+		return c.SendString(fmt.Sprintf("no trashed note with id: %d", id))
+	}
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-func GetNoteContent(c *fiber.Ctx) error {
-	note := getNoteFromContext(c)
-	var content []byte
-	var err error
-	if note.ContentType == notesdb.CONTENT_SQL {
-		content, err = notesdb.GetNoteContents(DB, note.ID)
+func (s *Server) purgeTrashPeriodically(retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := s.Store.PurgeTrash(time.Now().Add(-retention))
 		if err != nil {
-			return c.SendStatus(fiber.StatusInternalServerError)
+			slog.Error("failed to purge trash",
+				"err", err)
+		} else if len(purged) > 0 {
+			slog.Info("purged trashed notes", "count", len(purged), "retention", retention)
 		}
-	} else {
-		c.Status(fiber.StatusBadRequest)
-		return c.SendString(fmt.Sprintf("note has invalid ContentType: %d", note.ContentType))
+		<-ticker.C
 	}
-
-	stream := bytes.NewBuffer(content)
-	return c.SendStream(stream, len(content))
 }
 
-func UpdateNoteContent(c *fiber.Ctx) error {
-	note := getNoteFromContext(c)
+// ExportNotes streams all of the owner's notes as a Markdown archive. Once
+// streaming has started the status can't change, so failures part of the way
+// through only show up as a truncated archive (& in the logs).
+func (s *Server) ExportNotes(c *fiber.Ctx) error {
+	owner := getOwnerFromContext(c)
+	format := c.Query("format", notesarchive.FormatZip)
+	if !slices.Contains(notesarchive.Formats, format) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid format: %s (expected one of %s)", format, strings.Join(notesarchive.Formats, ", ")))
+	}
 
-	content := []byte(c.FormValue("content"))
-	if len(content) == 0 {
-		fileHeader, err := c.FormFile("content")
-		if err != nil && errors.Is(err, http.ErrMissingFile) {
-			c.Status(fiber.StatusBadRequest)
-			return c.SendString("either form value or form file required for 'content' form field")
-		} else if err != nil {
-			c.Status(fiber.StatusBadRequest)
-			return c.SendString(fmt.Sprintf("unexpected error when reading form file: %w", err))
-		}
-		// TODO: Don't read entire file into memory at once
-		file, err := fileHeader.Open()
+	filename := fmt.Sprintf("notes-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Set(fiber.HeaderContentType, notesarchive.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		manifest, err := notesarchive.Export(w, s.Store, owner, format)
 		if err != nil {
-			slog.Error("failed to read open file header",
-				"err", err)
-			c.Status(fiber.StatusInternalServerError)
-			return c.SendString("failed to read form file")
-		}
-		content, err = utils.ReadToEnd(file)
-		if err != nil {
-			slog.Error("failed to read request file body",
+			slog.Error("failed to export notes",
+				"format", format,
 				"err", err)
-			c.Status(fiber.StatusInternalServerError)
-			return c.SendString("failed to read form file")
+			return
 		}
+		slog.Info("exported notes", "format", format, "count", len(manifest.Notes))
+	})
+	return nil
+}
+
+// ImportNotes creates notes from the .md & .txt files in an uploaded zip or
+// tar.gz archive & responds with what happened to each file. The archive is
+// spooled to a temporary file first, since zip files can't be read as a
+// stream.
+func (s *Server) ImportNotes(c *fiber.Ctx) error {
+	opts := &notesarchive.ImportOptions{
+		DryRun:       c.QueryBool("dryRun"),
+		OnDuplicate:  c.Query("onDuplicate", notesarchive.DuplicateSkip),
+		MaxRevisions: s.MaxRevisions,
+	}
+	if !slices.Contains(notesarchive.DuplicatePolicies, opts.OnDuplicate) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(fmt.Sprintf("invalid onDuplicate: %s (expected one of %s)", opts.OnDuplicate, strings.Join(notesarchive.DuplicatePolicies, ", ")))
 	}
 
-	if note.ContentType == notesdb.CONTENT_SQL {
-		if err := notesdb.SetNoteContents(DB, note.ID, content); err != nil {
-			slog.Error("failed to save file contents",
-				"err", err,
-				"noteID", note.ID)
-			c.Status(fiber.StatusInternalServerError)
-			return c.SendString("failed to save file contents")
-		}
+	part, body, errMsg := openFormFile(c)
+	success := false
+	defer func() { finishFormFile(c, body, success) }()
+	if part == nil {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(errMsg)
+	}
+	defer part.Close()
+
+	f, err := os.CreateTemp("", "notes-import-*")
+	if err != nil {
+		slog.Error("failed to create temporary file for import", "err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, &maxSizeReader{r: part, remaining: s.MaxImportSize})
+	if err != nil && errors.Is(err, errTooLarge) {
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return c.SendString(fmt.Sprintf("archive is larger than the maximum of %d bytes", s.MaxImportSize))
+	} else if err != nil {
+		slog.Error("failed to spool archive for import", "err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	success = true
 
-	if err := notesdb.TouchNote(DB, note.ID); err != nil {
-		slog.Error("failed to update note last modified",
+	owner := getOwnerFromContext(c)
+	report, err := notesarchive.Import(f, size, s.Store, owner, opts)
+	if err != nil && errors.Is(err, notesarchive.ErrInvalidArchive) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	} else if err != nil {
+		slog.Error("failed to import notes",
+			"dryRun", opts.DryRun,
 			"err", err)
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	slog.Info("imported notes", "dryRun", opts.DryRun, "files", len(report.Results))
+	return c.JSON(report)
+}
 
-	return c.SendStatus(fiber.StatusNoContent)
+// CreateBackup snapshots the DB into a new backup, removing the oldest ones
+// beyond the retention count.
+func (s *Server) CreateBackup(c *fiber.Ctx) error {
+	if s.Backups == nil {
+		c.Status(fiber.StatusNotImplemented)
+		return c.SendString("backups are only supported with sqlite storage")
+	}
+	backup, err := s.createBackup()
+	if err != nil {
+		slog.Error("failed to back up DB",
+			"err", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(backup)
+}
+
+func (s *Server) backupPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := s.createBackup(); err != nil {
+			slog.Error("failed to back up DB",
+				"err", err)
+		}
+	}
+}
+
+func (s *Server) createBackup() (*notes.Backup, error) {
+	backup, err := s.Backups.Create()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("backed up DB", "name", backup.Name, "size", backup.Size)
+
+	if s.BackupRetention > 0 {
+		pruned, err := s.Backups.Prune(s.BackupRetention)
+		if err != nil {
+			// The backup itself succeeded, so this isn't worth failing over.
+			slog.Warn("failed to prune old backups",
+				"err", err)
+		} else if len(pruned) > 0 {
+			slog.Info("pruned old backups", "count", len(pruned), "retention", s.BackupRetention)
+		}
+	}
+	return backup, nil
 }
 
 // Auth-related controllers
@@ -431,4 +3161,82 @@ type NoteRequest struct {
 	ProtectedID        int64     `json:"id"`
 	ProtectedCreatedOn time.Time `json:"created_on"`
 	ProtectedUpdatedOn time.Time `json:"updated_on"`
+	ProtectedTags      []string  `json:"tags"`
+	ProtectedNotebook  *int64    `json:"notebook_id"`
+	ProtectedVersion   int64     `json:"version"`
+
+	// Only used when creating a note, to set its content in the same step.
+	Content *string `json:"content"`
+}
+
+// UpdateNoteResponse is returned instead of an empty response when renaming
+// a note broke links to it.
+type UpdateNoteResponse struct {
+	BrokenLinks []*notes.NoteLink `json:"broken_links"`
+}
+
+type NotebookRequest struct {
+	Name string `json:"name"`
+
+	// Kept raw to tell an explicit null (move to the top level) apart from
+	// the field being absent (leave the parent alone).
+	ParentID json.RawMessage `json:"parent_id"`
+}
+
+func (r *NotebookRequest) parseParentID() (*int64, bool, error) {
+	if len(r.ParentID) == 0 {
+		return nil, false, nil
+	}
+	var parentID *int64
+	if err := json.Unmarshal(r.ParentID, &parentID); err != nil {
+		return nil, true, fmt.Errorf("invalid parent_id: %s", r.ParentID)
+	}
+	return parentID, true, nil
+}
+
+// TemplateRequest creates or updates a template. Title & Content are pointers
+// to tell an empty template apart from the field being absent.
+type TemplateRequest struct {
+	Name    string  `json:"name"`
+	Title   *string `json:"title"`
+	Content *string `json:"content"`
+}
+
+// TemplateNoteRequest is the body of a request to create a note from a
+// template. TimeZone is an IANA time zone name, defaulting to UTC.
+type TemplateNoteRequest struct {
+	Params   map[string]string `json:"params"`
+	TimeZone string            `json:"time_zone"`
+}
+
+// ReminderRequest sets a note's reminder. Times are RFC 3339; a null or absent
+// remind_at means no reminder, and recurrence is "", "daily", "weekly" or
+// "monthly".
+type ReminderRequest struct {
+	DueOn      *time.Time `json:"due_on"`
+	RemindAt   *time.Time `json:"remind_at"`
+	Recurrence string     `json:"recurrence"`
+}
+
+// ShareRequest creates a share. Mode defaults to "text", an empty password
+// means none is needed & a null expires_on means the share never expires.
+type ShareRequest struct {
+	Mode      string     `json:"mode"`
+	Password  string     `json:"password"`
+	ExpiresOn *time.Time `json:"expires_on"`
+}
+
+type MoveNoteRequest struct {
+	NotebookID *int64 `json:"notebook_id"`
+}
+
+// NotesPage is one page of notes. NextCursor is passed as the cursor to get
+// the next page, and is omitted on the last one.
+type NotesPage struct {
+	Notes      any    `json:"notes"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type TagsRequest struct {
+	Tags []string `json:"tags"`
 }
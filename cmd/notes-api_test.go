@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/mrshanahan/notes-api/pkg/middleware"
+	"github.com/mrshanahan/notes-api/pkg/notes"
+	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
+)
+
+// newTestServer returns a server on a fresh MemoryStore with auth disabled,
+// so every request is made as the empty subject.
+func newTestServer(t *testing.T) (*Server, *fiber.App) {
+	t.Helper()
+	config := DefaultServerConfig()
+	config.DisableAuth = true
+	s := NewServer(notesdb.NewMemoryStore(), config)
+	return s, s.App()
+}
+
+// doRequest makes a request against the app & returns the response along
+// with its body.
+func doRequest(t *testing.T, app *fiber.App, method string, path string, body string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: failed to read response: %s", method, path, err)
+	}
+	return resp, string(respBody)
+}
+
+func expectStatus(t *testing.T, resp *http.Response, body string, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", resp.Request.Method, resp.Request.URL.Path, status, resp.StatusCode, body)
+	}
+}
+
+func createTestNote(t *testing.T, app *fiber.App, title string) *notesdb.IndexEntry {
+	t.Helper()
+	resp, body := doRequest(t, app, "POST", "/notes", fmt.Sprintf(`{"title": %q}`, title), nil)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	note := &notesdb.IndexEntry{}
+	if err := json.Unmarshal([]byte(body), note); err != nil {
+		t.Fatalf("failed to decode created note: %s: %s", err, body)
+	}
+	return note
+}
+
+func TestNotesOfOtherOwnersAreNotFound(t *testing.T) {
+	s, app := newTestServer(t)
+	theirs, err := s.Store.NewNote("someone-else", "Theirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/notes/%d", theirs.ID)
+
+	for _, req := range []struct{ method, path, body string }{
+		{"GET", path, ""},
+		{"GET", path + "/content", ""},
+		{"POST", path, `{"title": "Mine now"}`},
+		{"DELETE", path, ""},
+		{"POST", fmt.Sprintf("/trash/%d/restore", theirs.ID), ""},
+	} {
+		resp, body := doRequest(t, app, req.method, req.path, req.body, nil)
+		expectStatus(t, resp, body, fiber.StatusNotFound)
+	}
+
+	resp, body := doRequest(t, app, "GET", "/notes", "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if strings.Contains(body, "Theirs") {
+		t.Errorf("listed a note of another owner: %s", body)
+	}
+
+	note, err := s.Store.GetNote("someone-else", theirs.ID)
+	if err != nil || note == nil || note.Title != "Theirs" {
+		t.Errorf("note of another owner was changed: %+v (err: %v)", note, err)
+	}
+}
+
+func TestCreateNoteWithContent(t *testing.T) {
+	_, app := newTestServer(t)
+	for _, body := range []string{`{}`, `{"content": "x"}`} {
+		resp, respBody := doRequest(t, app, "POST", "/notes", body, nil)
+		expectStatus(t, resp, respBody, fiber.StatusBadRequest)
+	}
+
+	resp, body := doRequest(t, app, "POST", "/notes", `{"title": "With content", "content": "x"}`, nil)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	note := &notesdb.IndexEntry{}
+	if err := json.Unmarshal([]byte(body), note); err != nil {
+		t.Fatalf("failed to decode created note: %s: %s", err, body)
+	}
+	resp, body = doRequest(t, app, "GET", fmt.Sprintf("/notes/%d/content", note.ID), "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != "x" {
+		t.Errorf("expected content x, got %q", body)
+	}
+
+	resp, body = doRequest(t, app, "POST", fmt.Sprintf("/notes/%d", note.ID), `{"content": "y"}`, nil)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+}
+
+func TestUpdateNoteIfMatch(t *testing.T) {
+	_, app := newTestServer(t)
+	note := createTestNote(t, app, "First")
+	path := fmt.Sprintf("/notes/%d", note.ID)
+
+	resp, body := doRequest(t, app, "GET", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	etag := resp.Header.Get(fiber.HeaderETag)
+	if etag != middleware.NoteETag(note.Version) {
+		t.Fatalf("expected ETag %s, got %s", middleware.NoteETag(note.Version), etag)
+	}
+
+	resp, body = doRequest(t, app, "POST", path, `{"title": "Second"}`, map[string]string{fiber.HeaderIfMatch: etag})
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	if newETag := resp.Header.Get(fiber.HeaderETag); newETag == "" || newETag == etag {
+		t.Errorf("expected a new ETag after the update, got %q", newETag)
+	}
+
+	// The ETag is stale now.
+	resp, body = doRequest(t, app, "POST", path, `{"title": "Third"}`, map[string]string{fiber.HeaderIfMatch: etag})
+	expectStatus(t, resp, body, fiber.StatusPreconditionFailed)
+
+	resp, body = doRequest(t, app, "GET", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if !strings.Contains(body, `"title":"Second"`) {
+		t.Errorf("expected the title to stay Second after a failed update: %s", body)
+	}
+
+	resp, body = doRequest(t, app, "POST", path, `{"title": "Third"}`, map[string]string{fiber.HeaderIfMatch: "*"})
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	_, app := newTestServer(t)
+	note := createTestNote(t, app, "Trashed")
+	path := fmt.Sprintf("/notes/%d", note.ID)
+	restorePath := fmt.Sprintf("/trash/%d/restore", note.ID)
+
+	// Only trashed notes can be restored.
+	resp, body := doRequest(t, app, "POST", restorePath, "", nil)
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	resp, body = doRequest(t, app, "DELETE", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	resp, body = doRequest(t, app, "GET", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	resp, body = doRequest(t, app, "GET", "/trash", "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if !strings.Contains(body, `"title":"Trashed"`) {
+		t.Errorf("expected the note in the trash: %s", body)
+	}
+
+	resp, body = doRequest(t, app, "POST", restorePath, "", nil)
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	resp, body = doRequest(t, app, "GET", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	resp, body = doRequest(t, app, "GET", "/trash", "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if strings.Contains(body, `"title":"Trashed"`) {
+		t.Errorf("expected the restored note to have left the trash: %s", body)
+	}
+
+	// Purged notes are gone for good.
+	doRequest(t, app, "DELETE", path, "", nil)
+	resp, body = doRequest(t, app, "DELETE", fmt.Sprintf("/trash/%d", note.ID), "", nil)
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	resp, body = doRequest(t, app, "POST", restorePath, "", nil)
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func TestSharedNotes(t *testing.T) {
+	_, app := newTestServer(t)
+	note := createTestNote(t, app, "Shared")
+	resp, body := doRequest(t, app, "POST", fmt.Sprintf("/notes/%d/content", note.ID), "content="+url.QueryEscape("<b>hi</b>"), map[string]string{fiber.HeaderContentType: fiber.MIMEApplicationForm})
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+
+	resp, body = doRequest(t, app, "POST", fmt.Sprintf("/notes/%d/shares", note.ID), `{"password": "hunter2"}`, nil)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	share := &notes.Share{}
+	if err := json.Unmarshal([]byte(body), share); err != nil {
+		t.Fatalf("failed to decode share: %s: %s", err, body)
+	}
+	path := "/s/" + share.Token
+
+	resp, body = doRequest(t, app, "GET", path, "", nil)
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+	resp, body = doRequest(t, app, "GET", path, "", map[string]string{fiber.HeaderAuthorization: basicAuth("any", "wrong")})
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+
+	resp, body = doRequest(t, app, "GET", path, "", map[string]string{fiber.HeaderAuthorization: basicAuth("any", "hunter2")})
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != "<b>hi</b>" {
+		t.Errorf("unexpected shared content: %s", body)
+	}
+	if resp.Header.Get(fiber.HeaderXContentTypeOptions) != "nosniff" {
+		t.Errorf("expected the shared note to be served with nosniff")
+	}
+
+	// Tokens are only ever returned when shares are created.
+	resp, body = doRequest(t, app, "GET", "/shares", "", nil)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if strings.Contains(body, share.Token) || !strings.Contains(body, `"access_count":1`) {
+		t.Errorf("unexpected shares: %s", body)
+	}
+
+	resp, body = doRequest(t, app, "DELETE", fmt.Sprintf("/shares/%d", share.ID), "", nil)
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	resp, body = doRequest(t, app, "GET", path, "", map[string]string{fiber.HeaderAuthorization: basicAuth("any", "hunter2")})
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	resp, body = doRequest(t, app, "GET", "/s/not-a-token", "", nil)
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func basicAuth(user string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	config := DefaultServerConfig()
+	config.DisableAuth = true
+	config.MaxBodySize = 64
+	app := NewServer(notesdb.NewMemoryStore(), config).App()
+
+	resp, body := doRequest(t, app, "POST", "/notes", fmt.Sprintf(`{"title": %q}`, strings.Repeat("x", 64)), nil)
+	expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+	resp, body = doRequest(t, app, "POST", "/notes", `{"title": "Small"}`, nil)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+
+	// Uploads have limits of their own.
+	note := createTestNote(t, app, "Attached")
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	file, err := form.CreateFormFile("file", "large.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(bytes.Repeat([]byte("x"), 1024))
+	form.Close()
+	resp, body = doRequest(t, app, "POST", fmt.Sprintf("/notes/%d/attachments", note.ID), upload.String(), map[string]string{fiber.HeaderContentType: form.FormDataContentType()})
+	expectStatus(t, resp, body, fiber.StatusCreated)
+}
@@ -114,9 +114,14 @@ func LoadIndex() ([]*IndexEntry, error) {
     }
 
     entry, more, linesProcessed, err := parseNextIndexEntry(scanner)
-    for entry != nil && more && err == nil {
+    for entry != nil && err == nil {
         entries = append(entries, entry)
         idx += linesProcessed
+        // The last entry comes back with more == false if the file doesn't
+        // end with a blank line.
+        if !more {
+            break
+        }
         entry, more, linesProcessed, err = parseNextIndexEntry(scanner)
     }
     if err != nil {
@@ -8,7 +8,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mrshanahan/notes-api/internal/utils"
 	"github.com/mrshanahan/notes-api/pkg/notes"
@@ -24,8 +26,773 @@ func NewClient(url string, token *oauth2.Token) *Client {
 	return &Client{url, token}
 }
 
+// ListNotes lists every note, fetching as many pages as it takes.
 func (c *Client) ListNotes() ([]*notes.Note, error) {
-	resp, err := c.invoke("GET", "/notes/")
+	return c.IterateNotes(nil).All()
+}
+
+// ListNotesOptions filters & sorts the notes returned by ListNotesPage &
+// IterateNotes. Zero values use the server's defaults.
+type ListNotesOptions struct {
+	Tags          []string
+	MatchAllTags  bool
+	Sort          string // created_on, updated_on or title
+	Descending    bool
+	PinnedFirst   bool
+	View          string // active (the default), archived, favorites or all
+	UpdatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Properties    []string // filters like status=open or priority>2
+	PageSize      int
+}
+
+// ListNotesPage fetches a single page of notes. Pass "" as the cursor for the
+// first page & the returned NextCursor for the ones after it.
+func (c *Client) ListNotesPage(opts *ListNotesOptions, cursor string) (*notes.NotesPage, error) {
+	params := url.Values{}
+	if opts != nil {
+		for _, t := range opts.Tags {
+			params.Add("tag", t)
+		}
+		if len(opts.Tags) > 0 && !opts.MatchAllTags {
+			params.Set("tagMode", "or")
+		}
+		if opts.Sort != "" {
+			params.Set("sort", opts.Sort)
+		}
+		if opts.Descending {
+			params.Set("order", "desc")
+		}
+		if opts.PinnedFirst {
+			params.Set("pinnedFirst", "true")
+		}
+		if opts.View != "" {
+			params.Set("view", opts.View)
+		}
+		if opts.UpdatedAfter != nil {
+			params.Set("updated_after", opts.UpdatedAfter.Format(time.RFC3339))
+		}
+		if opts.CreatedBefore != nil {
+			params.Set("created_before", opts.CreatedBefore.Format(time.RFC3339))
+		}
+		for _, p := range opts.Properties {
+			// The server expects the filter split at the first "=", like any
+			// other query parameter.
+			key, value, _ := strings.Cut(p, "=")
+			params.Add("prop."+key, value)
+		}
+		if opts.PageSize > 0 {
+			params.Set("limit", strconv.Itoa(opts.PageSize))
+		}
+	}
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+
+	path := "/notes/"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	resp, err := c.invoke("GET", path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &notes.NotesPage{}
+	if err := json.Unmarshal(respBytes, page); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return page, nil
+}
+
+// IterateNotes returns an iterator over every note matching the options,
+// fetching pages lazily as it goes:
+//
+//	it := client.IterateNotes(opts)
+//	for it.Next() {
+//		note := it.Note()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) IterateNotes(opts *ListNotesOptions) *NoteIterator {
+	return &NoteIterator{client: c, opts: opts}
+}
+
+type NoteIterator struct {
+	client  *Client
+	opts    *ListNotesOptions
+	page    []*notes.Note
+	current *notes.Note
+	cursor  string
+	started bool
+	err     error
+}
+
+// Next advances to the next note, fetching the next page if needed. It
+// returns false once there are no more notes or a request fails.
+func (it *NoteIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == "") {
+			it.current = nil
+			return false
+		}
+		page, err := it.client.ListNotesPage(it.opts, it.cursor)
+		if err != nil {
+			it.err = err
+			it.current = nil
+			return false
+		}
+		it.started = true
+		it.page, it.cursor = page.Notes, page.NextCursor
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Note returns the note Next advanced to.
+func (it *NoteIterator) Note() *notes.Note {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *NoteIterator) Err() error {
+	return it.err
+}
+
+// All collects the remaining notes.
+func (it *NoteIterator) All() ([]*notes.Note, error) {
+	all := []*notes.Note{}
+	for it.Next() {
+		all = append(all, it.Note())
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	return all, nil
+}
+
+// Search runs a full-text search over note titles & contents. Results are
+// ordered by relevance, most relevant first.
+func (c *Client) Search(query string, limit int) ([]*notes.SearchResult, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.invoke("GET", "/notes/search?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*notes.SearchResult
+	if err := json.Unmarshal(respBytes, &results); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return results, nil
+}
+
+// ListNotesByTags lists the notes with the given tags. If matchAll is set a
+// note must have every tag, otherwise any one of them is enough.
+func (c *Client) ListNotesByTags(tags []string, matchAll bool) ([]*notes.Note, error) {
+	return c.IterateNotes(&ListNotesOptions{Tags: tags, MatchAllTags: matchAll}).All()
+}
+
+func (c *Client) CreateNote(title string) (*notes.Note, error) {
+	encTitle, err := json.Marshal(title)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding title: %w", err)
+	}
+	payload := fmt.Sprintf("{\"title\":%s}", encTitle)
+
+	return c.createNote("/notes/", strings.NewReader(payload))
+}
+
+// CreateNoteWithContent creates a note & sets its content in one step, so the
+// note never exists without it.
+func (c *Client) CreateNoteWithContent(title string, content []byte) (*notes.Note, error) {
+	payload, err := json.Marshal(map[string]string{"title": title, "content": string(content)})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding note: %w", err)
+	}
+
+	return c.createNote("/notes/", bytes.NewReader(payload))
+}
+
+// CreateNoteFromTemplate creates a note with the title & content of the
+// template rendered with the given params. The template's dates & times are
+// in the given IANA time zone, or UTC if it is "".
+func (c *Client) CreateNoteFromTemplate(templateID int64, params map[string]string, timeZone string) (*notes.Note, error) {
+	payload, err := json.Marshal(map[string]any{"params": params, "time_zone": timeZone})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding template params: %w", err)
+	}
+
+	return c.createNote(fmt.Sprintf("/notes/?template=%d", templateID), bytes.NewReader(payload))
+}
+
+func (c *Client) createNote(urlPath string, payload io.Reader) (*notes.Note, error) {
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var note *notes.Note
+	if err := json.Unmarshal(respBytes, &note); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return note, nil
+}
+
+func (c *Client) GetNote(id int64) (*notes.Note, error) {
+	urlPath := fmt.Sprintf("/notes/%d", id)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var note *notes.Note
+	if err := json.Unmarshal(respBytes, &note); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return note, nil
+}
+
+func (c *Client) UpdateNote(id int64, title string) error {
+	return c.UpdateNoteIfVersion(id, title, 0)
+}
+
+// UpdateNoteIfVersion updates the title of the note only if it is still at the
+// given version (see notes.Note.Version), returning a *ConflictError
+// otherwise. A version of 0 updates it unconditionally.
+func (c *Client) UpdateNoteIfVersion(id int64, title string, version int64) error {
+	_, err := c.RenameNote(id, title, version)
+	return err
+}
+
+// RenameNote is UpdateNoteIfVersion, also returning the links to the note
+// that the new title broke (see notes.NoteLink).
+func (c *Client) RenameNote(id int64, title string, version int64) ([]*notes.NoteLink, error) {
+	urlPath := fmt.Sprintf("/notes/%d", id)
+	encTitle, err := json.Marshal(title)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding title: %w", err)
+	}
+
+	payload := fmt.Sprintf("{\"title\":%s}", encTitle)
+	resp, err := c.invokeWithHeaders("POST", urlPath, "application/json", strings.NewReader(payload), ifMatchHeader(version))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	brokenLinks := []*notes.NoteLink{}
+	if len(respBytes) > 0 {
+		var body struct {
+			BrokenLinks []*notes.NoteLink `json:"broken_links"`
+		}
+		if err := json.Unmarshal(respBytes, &body); err != nil {
+			return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+		}
+		brokenLinks = body.BrokenLinks
+	}
+
+	return brokenLinks, nil
+}
+
+func (c *Client) DeleteNote(id int64) error {
+	urlPath := fmt.Sprintf("/notes/%d", id)
+
+	resp, err := c.invoke("DELETE", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) GetNoteContent(id int64) ([]byte, error) {
+	urlPath := fmt.Sprintf("/notes/%d/content", id)
+
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return respBytes, nil
+}
+
+func (c *Client) UpdateNoteContent(id int64, content []byte) error {
+	return c.UpdateNoteContentIfVersion(id, content, 0)
+}
+
+// UpdateNoteContentIfVersion replaces the content of the note only if it is
+// still at the given version, returning a *ConflictError otherwise. A version
+// of 0 replaces it unconditionally.
+func (c *Client) UpdateNoteContentIfVersion(id int64, content []byte, version int64) error {
+	body, contentType, err := newMultipartContent(content)
+	if err != nil {
+		return err
+	}
+
+	urlPath := fmt.Sprintf("/notes/%d/content", id)
+	resp, err := c.invokeWithHeaders("POST", urlPath, contentType, body, ifMatchHeader(version))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// MoveNote moves the note into the given notebook, or to the top level if
+// notebookID is nil.
+func (c *Client) MoveNote(id int64, notebookID *int64) error {
+	urlPath := fmt.Sprintf("/notes/%d/notebook", id)
+	payload, err := json.Marshal(map[string]*int64{"notebook_id": notebookID})
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding notebook ID: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) ListNotebooks() ([]*notes.Notebook, error) {
+	resp, err := c.invoke("GET", "/notebooks/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var notebooks []*notes.Notebook
+	if err := json.Unmarshal(respBytes, &notebooks); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return notebooks, nil
+}
+
+// CreateNotebook creates a notebook inside the given parent, or at the top
+// level if parentID is nil.
+func (c *Client) CreateNotebook(name string, parentID *int64) (*notes.Notebook, error) {
+	payload, err := json.Marshal(map[string]any{"name": name, "parent_id": parentID})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding notebook: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", "/notebooks/", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var notebook *notes.Notebook
+	if err := json.Unmarshal(respBytes, &notebook); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return notebook, nil
+}
+
+func (c *Client) GetNotebook(id int64) (*notes.Notebook, error) {
+	urlPath := fmt.Sprintf("/notebooks/%d", id)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var notebook *notes.Notebook
+	if err := json.Unmarshal(respBytes, &notebook); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return notebook, nil
+}
+
+// UpdateNotebook renames the notebook & moves it under the given parent, or
+// to the top level if parentID is nil.
+func (c *Client) UpdateNotebook(id int64, name string, parentID *int64) error {
+	urlPath := fmt.Sprintf("/notebooks/%d", id)
+	payload, err := json.Marshal(map[string]any{"name": name, "parent_id": parentID})
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding notebook: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// DeleteNotebook deletes the notebook. The policy decides what happens to its
+// contents: "reject" (the default) fails if there are any, "trash" moves its
+// notes to the trash, and "reparent" moves them up into its parent.
+func (c *Client) DeleteNotebook(id int64, policy string) error {
+	urlPath := fmt.Sprintf("/notebooks/%d", id)
+	if policy != "" {
+		urlPath += "?policy=" + url.QueryEscape(policy)
+	}
+	resp, err := c.invoke("DELETE", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) ListTemplates() ([]*notes.Template, error) {
+	resp, err := c.invoke("GET", "/templates/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []*notes.Template
+	if err := json.Unmarshal(respBytes, &templates); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return templates, nil
+}
+
+// CreateTemplate creates a template whose title & content are text/template
+// source (see CreateNoteFromTemplate).
+func (c *Client) CreateTemplate(name string, title string, content string) (*notes.Template, error) {
+	payload, err := json.Marshal(map[string]string{"name": name, "title": title, "content": content})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding template: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", "/templates/", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var template *notes.Template
+	if err := json.Unmarshal(respBytes, &template); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return template, nil
+}
+
+func (c *Client) GetTemplate(id int64) (*notes.Template, error) {
+	urlPath := fmt.Sprintf("/templates/%d", id)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var template *notes.Template
+	if err := json.Unmarshal(respBytes, &template); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return template, nil
+}
+
+func (c *Client) UpdateTemplate(id int64, name string, title string, content string) error {
+	urlPath := fmt.Sprintf("/templates/%d", id)
+	payload, err := json.Marshal(map[string]string{"name": name, "title": title, "content": content})
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding template: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) DeleteTemplate(id int64) error {
+	resp, err := c.invoke("DELETE", fmt.Sprintf("/templates/%d", id))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// ListNotebookNotes lists the notes in the notebook, including those in its
+// descendants if recursive is set.
+func (c *Client) ListNotebookNotes(id int64, recursive bool) ([]*notes.Note, error) {
+	urlPath := fmt.Sprintf("/notebooks/%d/notes?recursive=%t", id, recursive)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var notes []*notes.Note
+	if err := json.Unmarshal(respBytes, &notes); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return notes, nil
+}
+
+func (c *Client) AddTags(id int64, tags ...string) error {
+	urlPath := fmt.Sprintf("/notes/%d/tags", id)
+	payload, err := json.Marshal(map[string][]string{"tags": tags})
+	if err != nil {
+		return fmt.Errorf("error JSON-encoding tags: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) RemoveTag(id int64, tag string) error {
+	urlPath := fmt.Sprintf("/notes/%d/tags/%s", id, url.PathEscape(tag))
+	resp, err := c.invoke("DELETE", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+func (c *Client) SetPinned(id int64, pinned bool) error {
+	return c.setNoteState(id, "pinned", pinned)
+}
+
+func (c *Client) SetFavorite(id int64, favorite bool) error {
+	return c.setNoteState(id, "favorite", favorite)
+}
+
+func (c *Client) SetArchived(id int64, archived bool) error {
+	return c.setNoteState(id, "archived", archived)
+}
+
+func (c *Client) setNoteState(id int64, state string, on bool) error {
+	method := "PUT"
+	if !on {
+		method = "DELETE"
+	}
+	resp, err := c.invoke(method, fmt.Sprintf("/notes/%d/%s", id, state))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// SetProperties applies the patch to the note's properties & returns the
+// resulting properties. Properties mapped to nil are unset.
+func (c *Client) SetProperties(id int64, patch map[string]any) (map[string]any, error) {
+	urlPath := fmt.Sprintf("/notes/%d/properties", id)
+	payload, err := json.Marshal(patch)
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding properties: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("PATCH", urlPath, "application/merge-patch+json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var properties map[string]any
+	if err := json.Unmarshal(respBytes, &properties); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return properties, nil
+}
+
+// SetReminder sets when the note is due & when to be reminded of it, either
+// of which may be nil, replacing any reminder it had. Recurrence is "",
+// "daily", "weekly" or "monthly".
+func (c *Client) SetReminder(id int64, dueOn *time.Time, remindAt *time.Time, recurrence string) (*notes.Note, error) {
+	urlPath := fmt.Sprintf("/notes/%d/reminder", id)
+	payload, err := json.Marshal(map[string]any{"due_on": dueOn, "remind_at": remindAt, "recurrence": recurrence})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding reminder: %w", err)
+	}
+
+	resp, err := c.invokeWithPayload("PUT", urlPath, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var note notes.Note
+	if err := json.Unmarshal(respBytes, &note); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return &note, nil
+}
+
+func (c *Client) ClearReminder(id int64) error {
+	urlPath := fmt.Sprintf("/notes/%d/reminder", id)
+	resp, err := c.invoke("DELETE", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// UpcomingReminders lists the reminders that fire within the given duration,
+// soonest first, including overdue ones. A zero duration or limit uses the
+// server's default.
+func (c *Client) UpcomingReminders(within time.Duration, limit int) ([]*notes.Reminder, error) {
+	params := url.Values{}
+	if within > 0 {
+		params.Set("within", within.String())
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	resp, err := c.invoke("GET", "/reminders/upcoming?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var reminders []*notes.Reminder
+	if err := json.Unmarshal(respBytes, &reminders); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return reminders, nil
+}
+
+// ListNotifications lists notifications newest first, only the unread ones if
+// unreadOnly is set.
+func (c *Client) ListNotifications(unreadOnly bool) ([]*notes.Notification, error) {
+	urlPath := "/notifications/"
+	if unreadOnly {
+		urlPath += "?unread=true"
+	}
+	resp, err := c.invoke("GET", urlPath)
 	if err != nil {
 		return nil, err
 	}
@@ -36,22 +803,38 @@ func (c *Client) ListNotes() ([]*notes.Note, error) {
 		return nil, err
 	}
 
-	var notes []*notes.Note
-	if err := json.Unmarshal(respBytes, &notes); err != nil {
+	var notifications []*notes.Notification
+	if err := json.Unmarshal(respBytes, &notifications); err != nil {
 		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
 	}
 
-	return notes, nil
+	return notifications, nil
 }
 
-func (c *Client) CreateNote(title string) (*notes.Note, error) {
-	encTitle, err := json.Marshal(title)
+func (c *Client) MarkNotificationRead(id int64) error {
+	urlPath := fmt.Sprintf("/notifications/%d/read", id)
+	resp, err := c.invoke("POST", urlPath)
 	if err != nil {
-		return nil, fmt.Errorf("error JSON-encoding title: %w", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// CreateShare creates a public link to the note, served at /s/<token>. Mode
+// is "text" or "html", an empty password means none is needed & a nil
+// expiresOn means the share never expires. The returned share is the only
+// place its token is ever returned.
+func (c *Client) CreateShare(id int64, mode string, password string, expiresOn *time.Time) (*notes.Share, error) {
+	urlPath := fmt.Sprintf("/notes/%d/shares", id)
+	payload, err := json.Marshal(map[string]any{"mode": mode, "password": password, "expires_on": expiresOn})
+	if err != nil {
+		return nil, fmt.Errorf("error JSON-encoding share: %w", err)
 	}
-	payload := fmt.Sprintf("{\"title\":%s}", encTitle)
 
-	resp, err := c.invokeWithPayload("POST", "/notes/", "application/json", strings.NewReader(payload))
+	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
@@ -62,16 +845,25 @@ func (c *Client) CreateNote(title string) (*notes.Note, error) {
 		return nil, err
 	}
 
-	var note *notes.Note
-	if err := json.Unmarshal(respBytes, &note); err != nil {
+	var share notes.Share
+	if err := json.Unmarshal(respBytes, &share); err != nil {
 		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
 	}
 
-	return note, nil
+	return &share, nil
 }
 
-func (c *Client) GetNote(id int64) (*notes.Note, error) {
-	urlPath := fmt.Sprintf("/notes/%d", id)
+// ListShares lists the shares of every note, including revoked & expired
+// ones.
+func (c *Client) ListShares() ([]*notes.Share, error) {
+	return c.getShares("/shares/")
+}
+
+func (c *Client) ListNoteShares(id int64) ([]*notes.Share, error) {
+	return c.getShares(fmt.Sprintf("/notes/%d/shares", id))
+}
+
+func (c *Client) getShares(urlPath string) ([]*notes.Share, error) {
 	resp, err := c.invoke("GET", urlPath)
 	if err != nil {
 		return nil, err
@@ -83,23 +875,94 @@ func (c *Client) GetNote(id int64) (*notes.Note, error) {
 		return nil, err
 	}
 
-	var note *notes.Note
-	if err := json.Unmarshal(respBytes, &note); err != nil {
+	var shares []*notes.Share
+	if err := json.Unmarshal(respBytes, &shares); err != nil {
 		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
 	}
 
-	return note, nil
+	return shares, nil
 }
 
-func (c *Client) UpdateNote(id int64, title string) error {
-	urlPath := fmt.Sprintf("/notes/%d", id)
-	encTitle, err := json.Marshal(title)
+func (c *Client) RevokeShare(id int64) error {
+	urlPath := fmt.Sprintf("/shares/%d", id)
+	resp, err := c.invoke("DELETE", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
+
+// ListTags returns all tags along with the number of notes that have each one.
+func (c *Client) ListTags() ([]*notes.Tag, error) {
+	resp, err := c.invoke("GET", "/tags/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
 	if err != nil {
-		return fmt.Errorf("error JSON-encoding title: %w", err)
+		return nil, err
 	}
 
-	payload := fmt.Sprintf("{\"title\":%s}", encTitle)
-	resp, err := c.invokeWithPayload("POST", urlPath, "application/json", strings.NewReader(payload))
+	var tags []*notes.Tag
+	if err := json.Unmarshal(respBytes, &tags); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (c *Client) ListRevisions(id int64) ([]*notes.Revision, error) {
+	urlPath := fmt.Sprintf("/notes/%d/revisions", id)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*notes.Revision
+	if err := json.Unmarshal(respBytes, &revisions); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return revisions, nil
+}
+
+func (c *Client) GetRevision(id int64, rev int64) (*notes.RevisionWithContent, error) {
+	urlPath := fmt.Sprintf("/notes/%d/revisions/%d", id, rev)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var revision *notes.RevisionWithContent
+	if err := json.Unmarshal(respBytes, &revision); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return revision, nil
+}
+
+// RestoreRevision sets the note's title & content back to those of the given
+// revision. The restore is itself recorded as a new revision.
+func (c *Client) RestoreRevision(id int64, rev int64) error {
+	urlPath := fmt.Sprintf("/notes/%d/revisions/%d/restore", id, rev)
+	resp, err := c.invoke("POST", urlPath)
 	if err != nil {
 		return err
 	}
@@ -109,9 +972,41 @@ func (c *Client) UpdateNote(id int64, title string) error {
 	return err
 }
 
-func (c *Client) DeleteNote(id int64) error {
-	urlPath := fmt.Sprintf("/notes/%d", id)
+func (c *Client) ListTrash() ([]*notes.TrashedNote, error) {
+	resp, err := c.invoke("GET", "/trash/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var trashed []*notes.TrashedNote
+	if err := json.Unmarshal(respBytes, &trashed); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return trashed, nil
+}
+
+func (c *Client) RestoreNote(id int64) error {
+	urlPath := fmt.Sprintf("/trash/%d/restore", id)
+	resp, err := c.invoke("POST", urlPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = validateResponse(resp)
+	return err
+}
 
+// PurgeNote permanently deletes a note that is already in the trash.
+func (c *Client) PurgeNote(id int64) error {
+	urlPath := fmt.Sprintf("/trash/%d", id)
 	resp, err := c.invoke("DELETE", urlPath)
 	if err != nil {
 		return err
@@ -122,9 +1017,17 @@ func (c *Client) DeleteNote(id int64) error {
 	return err
 }
 
-func (c *Client) GetNoteContent(id int64) ([]byte, error) {
-	urlPath := fmt.Sprintf("/notes/%d/content", id)
+// ListNoteLinks lists the [[links]] in the content of the note.
+func (c *Client) ListNoteLinks(id int64) ([]*notes.NoteLink, error) {
+	return c.getNoteLinks(fmt.Sprintf("/notes/%d/links", id))
+}
 
+// ListBacklinks lists the [[links]] in other notes that point to the note.
+func (c *Client) ListBacklinks(id int64) ([]*notes.NoteLink, error) {
+	return c.getNoteLinks(fmt.Sprintf("/notes/%d/backlinks", id))
+}
+
+func (c *Client) getNoteLinks(urlPath string) ([]*notes.NoteLink, error) {
 	resp, err := c.invoke("GET", urlPath)
 	if err != nil {
 		return nil, err
@@ -135,17 +1038,101 @@ func (c *Client) GetNoteContent(id int64) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return respBytes, nil
+
+	var links []*notes.NoteLink
+	if err := json.Unmarshal(respBytes, &links); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return links, nil
 }
 
-func (c *Client) UpdateNoteContent(id int64, content []byte) error {
-	body, contentType, err := newMultipartContent(content)
+// GetNoteGraph returns every note along with the links between them.
+func (c *Client) GetNoteGraph() (*notes.NoteGraph, error) {
+	resp, err := c.invoke("GET", "/graph/")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	urlPath := fmt.Sprintf("/notes/%d/content", id)
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var graph *notes.NoteGraph
+	if err := json.Unmarshal(respBytes, &graph); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return graph, nil
+}
+
+func (c *Client) ListAttachments(noteID int64) ([]*notes.Attachment, error) {
+	urlPath := fmt.Sprintf("/notes/%d/attachments", noteID)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*notes.Attachment
+	if err := json.Unmarshal(respBytes, &attachments); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// AddAttachment uploads data as a new attachment of the note with the given
+// file name. The data is streamed, so it is never held in memory all at once.
+func (c *Client) AddAttachment(noteID int64, name string, data io.Reader) (*notes.Attachment, error) {
+	contentType, body := streamFormFile(name, data)
+	urlPath := fmt.Sprintf("/notes/%d/attachments", noteID)
 	resp, err := c.invokeWithPayload("POST", urlPath, contentType, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachment *notes.Attachment
+	if err := json.Unmarshal(respBytes, &attachment); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return attachment, nil
+}
+
+// GetAttachment returns the data of the attachment. The caller must close it.
+func (c *Client) GetAttachment(noteID int64, id int64) (io.ReadCloser, error) {
+	urlPath := fmt.Sprintf("/notes/%d/attachments/%d", noteID, id)
+	resp, err := c.invoke("GET", urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		_, err := validateResponse(resp)
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) DeleteAttachment(noteID int64, id int64) error {
+	urlPath := fmt.Sprintf("/notes/%d/attachments/%d", noteID, id)
+	resp, err := c.invoke("DELETE", urlPath)
 	if err != nil {
 		return err
 	}
@@ -155,12 +1142,74 @@ func (c *Client) UpdateNoteContent(id int64, content []byte) error {
 	return err
 }
 
+// Export downloads all notes as a Markdown archive of the given format (zip
+// or tar.gz). The caller must close it.
+// Import uploads a zip or tar.gz archive of notes. With dryRun set the report
+// says what would have been imported without importing anything.
+func (c *Client) Import(name string, data io.Reader, dryRun bool, onDuplicate string) (*notes.ImportReport, error) {
+	query := url.Values{"dryRun": {strconv.FormatBool(dryRun)}, "onDuplicate": {onDuplicate}}
+	contentType, body := streamFormFile(name, data)
+	resp, err := c.invokeWithPayload("POST", "/import?"+query.Encode(), contentType, body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *notes.ImportReport
+	if err := json.Unmarshal(respBytes, &report); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return report, nil
+}
+
+func (c *Client) Export(format string) (io.ReadCloser, error) {
+	resp, err := c.invoke("GET", "/export?"+url.Values{"format": {format}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		_, err := validateResponse(resp)
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Backup asks the server to snapshot its DB. Only admins may do so.
+func (c *Client) Backup() (*notes.Backup, error) {
+	resp, err := c.invoke("POST", "/admin/backup")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := validateResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var backup *notes.Backup
+	if err := json.Unmarshal(respBytes, &backup); err != nil {
+		return nil, fmt.Errorf("error JSON-decoding response body: %w", err)
+	}
+
+	return backup, nil
+}
+
 // Private functions
 
 func (c *Client) invoke(method string, path string) (*http.Response, error) {
-	requestUrl, err := url.JoinPath(c.URL, path)
+	requestUrl, err := c.buildRequestUrl(path)
 	if err != nil {
-		return nil, fmt.Errorf("error building URL path: %w", err)
+		return nil, err
 	}
 
 	req, err := http.NewRequest(method, requestUrl, nil)
@@ -177,9 +1226,13 @@ func (c *Client) invoke(method string, path string) (*http.Response, error) {
 }
 
 func (c *Client) invokeWithPayload(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
-	requestUrl, err := url.JoinPath(c.URL, path)
+	return c.invokeWithHeaders(method, path, contentType, body, nil)
+}
+
+func (c *Client) invokeWithHeaders(method string, path string, contentType string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	requestUrl, err := c.buildRequestUrl(path)
 	if err != nil {
-		return nil, fmt.Errorf("error building URL path: %w", err)
+		return nil, err
 	}
 
 	req, err := http.NewRequest(method, requestUrl, body)
@@ -188,6 +1241,9 @@ func (c *Client) invokeWithPayload(method string, path string, contentType strin
 	}
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token.AccessToken))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -196,12 +1252,47 @@ func (c *Client) invokeWithPayload(method string, path string, contentType strin
 	return resp, nil
 }
 
+// buildRequestUrl joins the path onto the base URL. Any query string in the
+// path is carried over as-is rather than being escaped as part of the path.
+func (c *Client) buildRequestUrl(path string) (string, error) {
+	path, rawQuery, _ := strings.Cut(path, "?")
+	requestUrl, err := url.JoinPath(c.URL, path)
+	if err != nil {
+		return "", fmt.Errorf("error building URL path: %w", err)
+	}
+	if rawQuery != "" {
+		requestUrl += "?" + rawQuery
+	}
+	return requestUrl, nil
+}
+
+// ConflictError is returned by conditional updates when the note has been
+// modified since the version they were made against.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("note was modified concurrently: %s", e.Message)
+}
+
+func ifMatchHeader(version int64) map[string]string {
+	if version == 0 {
+		return nil
+	}
+	return map[string]string{"If-Match": fmt.Sprintf(`"%d"`, version)}
+}
+
 func validateResponse(resp *http.Response) ([]byte, error) {
 	respBytes, err := utils.ReadToEnd(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response body: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, &ConflictError{Message: strings.TrimSpace(string(respBytes))}
+	}
+
 	// TODO: Wider range here?
 	if resp.StatusCode >= 400 {
 		respStr := strings.TrimSpace(string(respBytes))
@@ -227,3 +1318,21 @@ func newMultipartContent(content []byte) (io.Reader, string, error) {
 
 	return &buffer, formWriter.FormDataContentType(), nil
 }
+
+// streamFormFile returns a multipart/form-data body with data as the 'file'
+// field, written as it is read, along with its content type.
+func streamFormFile(name string, data io.Reader) (string, *io.PipeReader) {
+	body, writer := io.Pipe()
+	formWriter := multipart.NewWriter(writer)
+	go func() {
+		fileWriter, err := formWriter.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(fileWriter, data)
+		}
+		if err == nil {
+			err = formWriter.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return formWriter.FormDataContentType(), body
+}
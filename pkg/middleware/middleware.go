@@ -1,18 +1,22 @@
 package middleware
 
 import (
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/lestrrat-go/jwx/jwt"
 	"github.com/mrshanahan/notes-api/pkg/auth"
 	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
 )
 
-func LoadNoteFromRoute(localName string, param string, db *sql.DB) func(*fiber.Ctx) error {
+// LoadNoteFromRoute loads the note identified by the given route param into
+// the given local. Notes not owned by the subject of the token stored in
+// tokenLocalName are treated as if they don't exist.
+func LoadNoteFromRoute(localName string, param string, tokenLocalName string, store notesdb.Store) func(*fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		idStr := c.Params(param)
 		id, err := strconv.ParseInt(idStr, 10, 64)
@@ -20,7 +24,8 @@ func LoadNoteFromRoute(localName string, param string, db *sql.DB) func(*fiber.C
 			c.Status(fiber.StatusBadRequest)
 			return c.SendString("invalid request")
 		}
-		found, err := notesdb.GetNote(db, id)
+		owner := GetTokenSubject(c, tokenLocalName)
+		found, err := store.GetNote(owner, id)
 		if err != nil {
 			slog.Error("failed to execute query to retrieve note",
 				"id", id,
@@ -38,6 +43,97 @@ func LoadNoteFromRoute(localName string, param string, db *sql.DB) func(*fiber.C
 	}
 }
 
+// LoadNotebookFromRoute loads the notebook identified by the given route param
+// into the given local, the same way LoadNoteFromRoute does for notes.
+func LoadNotebookFromRoute(localName string, param string, tokenLocalName string, store notesdb.Store) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		idStr := c.Params(param)
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString("invalid request")
+		}
+		owner := GetTokenSubject(c, tokenLocalName)
+		found, err := store.GetNotebook(owner, id)
+		if err != nil {
+			slog.Error("failed to execute query to retrieve notebook",
+				"id", id,
+				"err", err)
+			c.Status(fiber.StatusInternalServerError)
+			return c.SendString("failed to load notebook")
+		}
+		if found == nil {
+			c.Status(fiber.StatusNotFound)
+			return c.SendString(fmt.Sprintf("no notebook with id: %d", id))
+		}
+		c.Locals(localName, found)
+		return c.Next()
+	}
+}
+
+// LoadTemplateFromRoute loads the template identified by the given route param
+// into the given local, the same way LoadNoteFromRoute does for notes.
+func LoadTemplateFromRoute(localName string, param string, tokenLocalName string, store notesdb.Store) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		idStr := c.Params(param)
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			c.Status(fiber.StatusBadRequest)
+			return c.SendString("invalid request")
+		}
+		owner := GetTokenSubject(c, tokenLocalName)
+		found, err := store.GetTemplate(owner, id)
+		if err != nil {
+			slog.Error("failed to execute query to retrieve template",
+				"id", id,
+				"err", err)
+			c.Status(fiber.StatusInternalServerError)
+			return c.SendString("failed to load template")
+		}
+		if found == nil {
+			c.Status(fiber.StatusNotFound)
+			return c.SendString(fmt.Sprintf("no template with id: %d", id))
+		}
+		c.Locals(localName, found)
+		return c.Next()
+	}
+}
+
+// NoteETag returns the entity tag of the given version of a note.
+func NoteETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// CheckNoteIfMatch responds with 412 Precondition Failed if the request has
+// an If-Match header that doesn't match the current version of the note
+// loaded into the given local by LoadNoteFromRoute.
+func CheckNoteIfMatch(localName string) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ifMatch := c.Get(fiber.HeaderIfMatch)
+		if ifMatch == "" {
+			return c.Next()
+		}
+		note := c.Locals(localName).(*notesdb.IndexEntry)
+		if !etagMatches(ifMatch, NoteETag(note.Version)) {
+			c.Status(fiber.StatusPreconditionFailed)
+			return c.SendString(fmt.Sprintf("note %d has been modified; current version is %d", note.ID, note.Version))
+		}
+		return c.Next()
+	}
+}
+
+// etagMatches returns whether the etag is one of those listed in an If-Match
+// header value, or the header value is "*". Weak tags are compared by value.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 var bearerTokenPattern *regexp.Regexp = regexp.MustCompile(`^Bearer\s+(.*)$`)
 
 func ValidateAccessToken(localName string, cookieName string) func(*fiber.Ctx) error {
@@ -60,7 +156,50 @@ func ValidateAccessToken(localName string, cookieName string) func(*fiber.Ctx) e
 		if err != nil {
 			return c.SendStatus(fiber.StatusUnauthorized)
 		}
+		// Notes are owned by the token subject, so a token without one can't be used.
+		if (*token).Subject() == "" {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
 		c.Locals(localName, token)
 		return c.Next()
 	}
 }
+
+// GetTokenSubject returns the subject of the token stored in the given local,
+// or "" if there is none (e.g. when authentication is disabled).
+func GetTokenSubject(c *fiber.Ctx, localName string) string {
+	token, ok := c.Locals(localName).(*jwt.Token)
+	if !ok || token == nil {
+		return ""
+	}
+	return (*token).Subject()
+}
+
+// GetTokenClaim returns the string claim of the token stored in the given
+// local, or "" if there is no such token or claim.
+func GetTokenClaim(c *fiber.Ctx, localName string, claim string) string {
+	token, ok := c.Locals(localName).(*jwt.Token)
+	if !ok || token == nil {
+		return ""
+	}
+	value, ok := (*token).Get(claim)
+	if !ok {
+		return ""
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// RequireSubject only lets requests through if the subject of the token
+// stored in localName is one of the given subjects.
+func RequireSubject(localName string, subjects []string) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		subject := GetTokenSubject(c, localName)
+		for _, s := range subjects {
+			if subject != "" && subject == s {
+				return c.Next()
+			}
+		}
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+}
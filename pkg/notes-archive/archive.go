@@ -0,0 +1,128 @@
+// Package notesarchive exports notes as archives of Markdown files, one per
+// note with its metadata in YAML front matter, along with a JSON manifest, and
+// imports archives of Markdown & text files as notes.
+package notesarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+
+	ManifestName    = "manifest.json"
+	ManifestVersion = 1
+)
+
+// Formats are all of the supported archive formats.
+var Formats = []string{FormatZip, FormatTarGz}
+
+// Manifest lists every note in an archive. It is the last file in the
+// archive, since it is only complete once every note has been written.
+type Manifest struct {
+	Version    int              `json:"version"`
+	ExportedOn time.Time        `json:"exported_on"`
+	Notes      []*ManifestEntry `json:"notes"`
+}
+
+type ManifestEntry struct {
+	Path      string    `json:"path"`
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	CreatedOn time.Time `json:"created_on"`
+	UpdatedOn time.Time `json:"updated_on"`
+	Tags      []string  `json:"tags,omitempty"`
+}
+
+// ContentType returns the MIME type of archives of the given format.
+func ContentType(format string) string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// FormatFromPath guesses the format of an archive from the extension of its
+// path, returning "" if it doesn't match any format.
+func FormatFromPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return FormatTarGz
+	default:
+		return ""
+	}
+}
+
+// Private
+
+// archiveWriter writes files to an archive one at a time, without holding on
+// to them.
+type archiveWriter interface {
+	WriteFile(name string, modTime time.Time, data []byte) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format string) (archiveWriter, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tar: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("invalid archive format %q: must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+type zipWriter struct {
+	zip *zip.Writer
+}
+
+func (w *zipWriter) WriteFile(name string, modTime time.Time, data []byte) error {
+	f, err := w.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zip.Close()
+}
+
+type tarGzWriter struct {
+	gz  *gzip.Writer
+	tar *tar.Writer
+}
+
+func (w *tarGzWriter) WriteFile(name string, modTime time.Time, data []byte) error {
+	err := w.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tar.Write(data)
+	return err
+}
+
+func (w *tarGzWriter) Close() error {
+	if err := w.tar.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
@@ -0,0 +1,147 @@
+package notesarchive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
+)
+
+const (
+	exportPageSize = 100
+	maxSlugLength  = 80
+)
+
+// Export writes every one of the owner's live notes to w as an archive of the
+// given format, returning its manifest. Notes are read a page at a time & each
+// is written out before the next one is read, so the archive is never held in
+// memory. If it fails part of the way through, w is left with a truncated
+// archive.
+func Export(w io.Writer, store notesdb.Store, owner string, format string) (*Manifest, error) {
+	archive, err := newArchiveWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Version:    ManifestVersion,
+		ExportedOn: time.Now().UTC().Truncate(time.Second),
+		Notes:      []*ManifestEntry{},
+	}
+	usedPaths := map[string]bool{ManifestName: true}
+	page := &notesdb.NotePage{Limit: exportPageSize}
+	for {
+		entries, nextCursor, err := store.GetNotes(owner, nil, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			content, err := store.GetNoteContents(owner, entry.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read content of note %d: %w", entry.ID, err)
+			}
+			path := notePath(entry.Note, usedPaths)
+			if err := archive.WriteFile(path, entry.UpdatedOn, renderNote(entry.Note, content)); err != nil {
+				return nil, err
+			}
+			manifest.Notes = append(manifest.Notes, &ManifestEntry{
+				Path:      path,
+				ID:        entry.ID,
+				Title:     entry.Title,
+				CreatedOn: entry.CreatedOn,
+				UpdatedOn: entry.UpdatedOn,
+				Tags:      entry.Tags,
+			})
+		}
+		if nextCursor == "" {
+			break
+		}
+		page.Cursor = nextCursor
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.WriteFile(ManifestName, manifest.ExportedOn, manifestJSON); err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Private
+
+// renderNote returns the note as Markdown with its metadata in YAML front
+// matter. Strings are written as JSON strings, which are valid YAML.
+func renderNote(note *notes.Note, content []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %d\n", note.ID)
+	fmt.Fprintf(&b, "title: %s\n", mustMarshalJSON(note.Title))
+	fmt.Fprintf(&b, "created_on: %s\n", note.CreatedOn.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated_on: %s\n", note.UpdatedOn.UTC().Format(time.RFC3339))
+	if len(note.Tags) > 0 {
+		fmt.Fprintf(&b, "tags: %s\n", mustMarshalJSON(note.Tags))
+	}
+	b.WriteString("---\n")
+	b.Write(content)
+	return b.Bytes()
+}
+
+func mustMarshalJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// notePath returns the path of the note in the archive: its slugified title,
+// disambiguated by its ID if another note already has that path.
+func notePath(note *notes.Note, usedPaths map[string]bool) string {
+	slug := slugify(note.Title)
+	path := slug + ".md"
+	for i := 1; usedPaths[path]; i++ {
+		if i == 1 {
+			path = fmt.Sprintf("%s-%d.md", slug, note.ID)
+		} else {
+			path = fmt.Sprintf("%s-%d-%d.md", slug, note.ID, i)
+		}
+	}
+	usedPaths[path] = true
+	return path
+}
+
+// slugify lowercases the title & replaces every run of characters other than
+// letters & digits with a single dash.
+func slugify(title string) string {
+	slug := []rune{}
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+		if dash && len(slug) > 0 {
+			slug = append(slug, '-')
+		}
+		slug = append(slug, r)
+		dash = false
+		if len(slug) >= maxSlugLength {
+			break
+		}
+	}
+	if len(slug) == 0 {
+		return "note"
+	}
+	return strings.TrimSuffix(string(slug[:min(len(slug), maxSlugLength)]), "-")
+}
@@ -0,0 +1,241 @@
+package notesarchive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FrontMatter is the metadata of a note read from the YAML front matter at
+// the top of its file. Fields missing from the front matter are left zero.
+type FrontMatter struct {
+	Title     string
+	CreatedOn time.Time
+	UpdatedOn time.Time
+	Tags      []string
+}
+
+// frontMatterTimeLayouts are the timestamp formats accepted in front matter,
+// most specific first.
+var frontMatterTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseFrontMatter splits the file into its front matter & its content. Front
+// matter is only recognized if the file starts with a "---" line & has a
+// closing "---" (or "...") line; otherwise the front matter is nil & the
+// content is the whole file.
+//
+// Only the subset of YAML that front matter is written in is supported: a
+// mapping of keys to plain, single-quoted or double-quoted strings, and of
+// tags to either a flow ([a, b]) or block (- a) sequence. Keys other than
+// title, created_on, updated_on & tags are ignored.
+func ParseFrontMatter(data []byte) (*FrontMatter, []byte, error) {
+	header, content, ok := splitFrontMatter(data)
+	if !ok {
+		return nil, data, nil
+	}
+
+	fm := &FrontMatter{}
+	lines := strings.Split(strings.ReplaceAll(string(header), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isBlankOrComment(line) {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(line, "- ") {
+			return nil, nil, fmt.Errorf("front matter line %d: unexpected indented value", i+1)
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("front matter line %d: expected 'key: value'", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		// A key with no value may be followed by a block sequence.
+		var items []string
+		if value == "" {
+			for i+1 < len(lines) {
+				item := strings.TrimLeft(lines[i+1], " \t")
+				if isBlankOrComment(item) {
+					i++
+					continue
+				}
+				if item != "-" && !strings.HasPrefix(item, "- ") {
+					break
+				}
+				items = append(items, strings.TrimSpace(strings.TrimPrefix(item, "-")))
+				i++
+			}
+		}
+
+		var err error
+		switch key {
+		case "title":
+			fm.Title, err = parseScalar(value)
+		case "created_on":
+			fm.CreatedOn, err = parseTimeScalar(value)
+		case "updated_on":
+			fm.UpdatedOn, err = parseTimeScalar(value)
+		case "tags":
+			fm.Tags, err = parseSequence(value, items)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("front matter key %q: %w", key, err)
+		}
+	}
+	return fm, content, nil
+}
+
+// Private
+
+// splitFrontMatter returns the lines between the opening & closing
+// delimiters, and everything after the closing one.
+func splitFrontMatter(data []byte) ([]byte, []byte, bool) {
+	rest, ok := cutLine(data, "---")
+	if !ok {
+		return nil, nil, false
+	}
+	header := rest
+	for offset := 0; offset <= len(rest); {
+		line := rest[offset:]
+		if content, ok := cutLine(line, "---"); ok {
+			return header[:offset], content, true
+		}
+		if content, ok := cutLine(line, "..."); ok {
+			return header[:offset], content, true
+		}
+		next := bytes.IndexByte(line, '\n')
+		if next < 0 {
+			break
+		}
+		offset += next + 1
+	}
+	return nil, nil, false
+}
+
+// cutLine returns what follows the first line of data if that line is
+// exactly delim (ignoring trailing whitespace).
+func cutLine(data []byte, delim string) ([]byte, bool) {
+	line, rest, found := bytes.Cut(data, []byte("\n"))
+	if string(bytes.TrimRight(line, " \t\r")) != delim {
+		return nil, false
+	}
+	if !found {
+		return []byte{}, true
+	}
+	return rest, true
+}
+
+func isBlankOrComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+func parseScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		var s string
+		if err := json.Unmarshal([]byte(value), &s); err != nil {
+			return "", fmt.Errorf("invalid double-quoted string: %s", value)
+		}
+		return s, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("invalid single-quoted string: %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	default:
+		// A plain scalar ends at a comment.
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+func parseTimeScalar(value string) (time.Time, error) {
+	s, err := parseScalar(value)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	for _, layout := range frontMatterTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", s)
+}
+
+// parseSequence parses either a flow sequence or the items of a block one. A
+// lone scalar is taken as a sequence of one.
+func parseSequence(value string, items []string) ([]string, error) {
+	if value != "" {
+		if !strings.HasPrefix(value, "[") {
+			s, err := parseScalar(value)
+			if err != nil || s == "" {
+				return nil, err
+			}
+			return []string{s}, nil
+		}
+		var err error
+		if items, err = splitFlowSequence(value); err != nil {
+			return nil, err
+		}
+	}
+
+	values := []string{}
+	for _, item := range items {
+		s, err := parseScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		if s != "" {
+			values = append(values, s)
+		}
+	}
+	return values, nil
+}
+
+// splitFlowSequence splits "[a, 'b, c', "d"]" into its items, leaving quoted
+// items quoted.
+func splitFlowSequence(value string) ([]string, error) {
+	inner, ok := strings.CutSuffix(strings.TrimPrefix(value, "["), "]")
+	if !ok {
+		return nil, fmt.Errorf("unterminated sequence: %s", value)
+	}
+
+	items := []string{}
+	var quote rune
+	start := 0
+	escaped := false
+	for i, r := range inner {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			items = append(items, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in sequence: %s", value)
+	}
+	if last := strings.TrimSpace(inner[start:]); last != "" || len(items) > 0 {
+		items = append(items, last)
+	}
+	return items, nil
+}
@@ -0,0 +1,386 @@
+package notesarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
+)
+
+const (
+	// Policies for files whose title matches one of the owner's notes.
+	DuplicateSkip      = "skip"
+	DuplicateOverwrite = "overwrite"
+	DuplicateRename    = "rename"
+
+	// Statuses of imported files. A dry run reports what would have happened.
+	ImportCreated     = "created"
+	ImportRenamed     = "renamed"
+	ImportOverwritten = "overwritten"
+	ImportSkipped     = "skipped"
+	ImportIgnored     = "ignored"
+	ImportFailed      = "failed"
+
+	// MaxImportFileSize is the largest file in an archive that is imported.
+	MaxImportFileSize = 16 << 20
+)
+
+// DuplicatePolicies are all of the supported duplicate policies.
+var DuplicatePolicies = []string{DuplicateSkip, DuplicateOverwrite, DuplicateRename}
+
+// ErrInvalidArchive is returned by Import if the archive isn't a zip or
+// tar.gz file or can't be read in full.
+var ErrInvalidArchive = errors.New("invalid archive")
+
+type ImportOptions struct {
+	// DryRun reports what would be imported without changing anything.
+	DryRun bool
+	// OnDuplicate is one of DuplicatePolicies. A file is a duplicate if its
+	// title matches that of one of the owner's notes, ignoring case.
+	OnDuplicate  string
+	MaxRevisions int
+}
+
+// Import creates a note for each .md & .txt file in the archive, which may be
+// a zip or tar.gz file, and reports what happened to every file in it. The
+// title, timestamps & tags of a note are taken from its front matter (see
+// ParseFrontMatter) if it has any; otherwise the title is the file name.
+// Overwriting a duplicate replaces its content & adds the tags, keeping its
+// title & timestamps.
+//
+// The whole archive is read through before anything is imported, so a corrupt
+// one fails with ErrInvalidArchive without having changed anything. Each file
+// is imported atomically, but the import as a whole isn't: if it fails part of
+// the way through, the files before it stay imported.
+func Import(r io.ReaderAt, size int64, store notesdb.Store, owner string, opts *ImportOptions) (*notes.ImportReport, error) {
+	if !slices.Contains(DuplicatePolicies, opts.OnDuplicate) {
+		return nil, fmt.Errorf("invalid duplicate policy %q: must be one of %s", opts.OnDuplicate, strings.Join(DuplicatePolicies, ", "))
+	}
+	walk, err := newArchiveWalker(r, size)
+	if err != nil {
+		return nil, err
+	}
+	err = walk(func(name string, data []byte, err error) error {
+		if err != nil && !errors.Is(err, errFileTooLarge) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	titles, err := getNoteTitles(store, owner)
+	if err != nil {
+		return nil, err
+	}
+	imp := &importer{store: store, owner: owner, opts: opts, titles: titles}
+	report := &notes.ImportReport{DryRun: opts.DryRun, Results: []*notes.ImportResult{}}
+	err = walk(func(name string, data []byte, err error) error {
+		result := &notes.ImportResult{Path: name}
+		report.Results = append(report.Results, result)
+		switch {
+		case !isImportable(name):
+			result.Status = ImportIgnored
+			return nil
+		case err != nil && errors.Is(err, errFileTooLarge):
+			result.Status = ImportFailed
+			result.Error = fmt.Sprintf("file is larger than the maximum of %d bytes", MaxImportFileSize)
+			return nil
+		case err != nil:
+			return err
+		}
+		return imp.importFile(result, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Private
+
+var errFileTooLarge = errors.New("file is too large")
+
+// archiveWalker calls fn with the name & data of each regular file in an
+// archive in turn, or with errFileTooLarge in place of the data if the file
+// is too large to import. It stops at the first error returned by fn.
+type archiveWalker func(fn func(name string, data []byte, err error) error) error
+
+// newArchiveWalker sniffs the format of the archive from its first bytes.
+func newArchiveWalker(r io.ReaderAt, size int64) (archiveWalker, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		return func(fn func(string, []byte, error) error) error {
+			return walkZip(zr, fn)
+		}, nil
+	case bytes.HasPrefix(magic, []byte("\x1f\x8b")):
+		return func(fn func(string, []byte, error) error) error {
+			return walkTarGz(io.NewSectionReader(r, 0, size), fn)
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: expected a zip or tar.gz file", ErrInvalidArchive)
+	}
+}
+
+func walkZip(zr *zip.Reader, fn func(string, []byte, error) error) error {
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil && !errors.Is(err, errFileTooLarge) {
+			return fmt.Errorf("%w: failed to read %s: %s", ErrInvalidArchive, f.Name, err)
+		}
+		if err := fn(f.Name, data, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > MaxImportFileSize {
+		return nil, errFileTooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readLimited(rc)
+}
+
+func walkTarGz(r io.Reader, fn func(string, []byte, error) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil && errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		var data []byte
+		if header.Size > MaxImportFileSize {
+			err = errFileTooLarge
+		} else if data, err = readLimited(tr); err != nil && !errors.Is(err, errFileTooLarge) {
+			return fmt.Errorf("%w: failed to read %s: %s", ErrInvalidArchive, header.Name, err)
+		}
+		if err := fn(header.Name, data, err); err != nil {
+			return err
+		}
+	}
+}
+
+// readLimited reads r in full unless it turns out to be larger than
+// MaxImportFileSize, whatever its header claimed.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxImportFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxImportFileSize {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
+
+// isImportable returns whether the file is a note, rather than e.g. the
+// manifest of an export or metadata left by an archiver.
+func isImportable(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(base))
+	return ext == ".md" || ext == ".txt"
+}
+
+// titledNote is a note that an imported file's title may collide with.
+type titledNote struct {
+	id    int64
+	title string
+}
+
+// getNoteTitles returns the owner's notes keyed by their lower-cased titles.
+// If several notes share a title the oldest one wins.
+func getNoteTitles(store notesdb.Store, owner string) (map[string]*titledNote, error) {
+	titles := map[string]*titledNote{}
+	page := &notesdb.NotePage{Limit: exportPageSize}
+	for {
+		entries, nextCursor, err := store.GetNotes(owner, nil, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			key := strings.ToLower(entry.Title)
+			if existing, ok := titles[key]; !ok || entry.ID < existing.id {
+				titles[key] = &titledNote{id: entry.ID, title: entry.Title}
+			}
+		}
+		if nextCursor == "" {
+			return titles, nil
+		}
+		page.Cursor = nextCursor
+	}
+}
+
+type importer struct {
+	store notesdb.Store
+	owner string
+	opts  *ImportOptions
+	// titles includes the notes imported so far, so that duplicates within
+	// the archive are caught too. Notes that a dry run would have created
+	// have an ID of 0.
+	titles map[string]*titledNote
+}
+
+// importFile imports a single file, filling in its result. Problems with the
+// file itself are reported in the result; only store errors are returned.
+func (imp *importer) importFile(result *notes.ImportResult, data []byte) error {
+	fm, content, err := ParseFrontMatter(data)
+	if err != nil {
+		result.Status = ImportFailed
+		result.Error = err.Error()
+		return nil
+	}
+	if fm == nil {
+		fm = &FrontMatter{}
+	}
+	if strings.TrimSpace(fm.Title) == "" {
+		fm.Title = path.Base(result.Path)
+	}
+	if fm.Tags, err = notesdb.NormalizeTags(fm.Tags); err != nil {
+		result.Status = ImportFailed
+		result.Error = err.Error()
+		return nil
+	}
+	if fm.CreatedOn.IsZero() {
+		fm.CreatedOn = fm.UpdatedOn
+	}
+	if fm.UpdatedOn.IsZero() {
+		fm.UpdatedOn = fm.CreatedOn
+	}
+
+	result.Title = fm.Title
+	result.Status = ImportCreated
+	if existing, ok := imp.titles[strings.ToLower(fm.Title)]; ok {
+		switch imp.opts.OnDuplicate {
+		case DuplicateSkip:
+			result.Status = ImportSkipped
+			result.NoteID = noteIDOrNil(existing.id)
+			result.Title = existing.title
+			return nil
+		case DuplicateOverwrite:
+			result.Status = ImportOverwritten
+			result.NoteID = noteIDOrNil(existing.id)
+			result.Title = existing.title
+			if imp.opts.DryRun {
+				return nil
+			}
+			return imp.overwrite(existing.id, content, fm.Tags)
+		case DuplicateRename:
+			result.Status = ImportRenamed
+			result.Title = imp.uniqueTitle(fm.Title)
+		}
+	}
+
+	if imp.opts.DryRun {
+		imp.titles[strings.ToLower(result.Title)] = &titledNote{title: result.Title}
+		return nil
+	}
+	id, err := imp.create(result.Title, content, fm)
+	if err != nil {
+		return err
+	}
+	imp.titles[strings.ToLower(result.Title)] = &titledNote{id: id, title: result.Title}
+	result.NoteID = &id
+	return nil
+}
+
+func (imp *importer) create(title string, content []byte, fm *FrontMatter) (int64, error) {
+	var id int64
+	err := imp.store.Atomically(func(tx notesdb.Store) error {
+		entry, err := tx.NewNote(imp.owner, title)
+		if err != nil {
+			return err
+		}
+		id = entry.ID
+		if err := tx.SetNoteContents(imp.owner, id, content, 0); err != nil {
+			return err
+		}
+		if err := tx.RecordRevision(imp.owner, id, imp.opts.MaxRevisions); err != nil {
+			return err
+		}
+		if len(fm.Tags) > 0 {
+			if err := tx.AddTags(imp.owner, id, fm.Tags); err != nil {
+				return err
+			}
+		}
+		if fm.CreatedOn.IsZero() {
+			return nil
+		}
+		return tx.SetNoteTimes(imp.owner, id, fm.CreatedOn, fm.UpdatedOn)
+	})
+	return id, err
+}
+
+func (imp *importer) overwrite(id int64, content []byte, tags []string) error {
+	return imp.store.Atomically(func(tx notesdb.Store) error {
+		if err := tx.SetNoteContents(imp.owner, id, content, 0); err != nil {
+			return err
+		}
+		if err := tx.RecordRevision(imp.owner, id, imp.opts.MaxRevisions); err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		return tx.AddTags(imp.owner, id, tags)
+	})
+}
+
+// uniqueTitle returns the title with the lowest " (N)" suffix that no note
+// has yet.
+func (imp *importer) uniqueTitle(title string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", title, n)
+		if _, ok := imp.titles[strings.ToLower(candidate)]; !ok {
+			return candidate
+		}
+	}
+}
+
+func noteIDOrNil(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,225 @@
+package notesdb
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+// AttachmentFileStore keeps the data of attachments as one file per
+// attachment, with a directory per note so that all of a note's attachments
+// can be removed at once.
+type AttachmentFileStore struct {
+	Dir string
+}
+
+func NewAttachmentFileStore(dir string) (*AttachmentFileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &AttachmentFileStore{Dir: dir}, nil
+}
+
+// writeTemp streams data into a temporary file, returning its path along
+// with the size & hex-encoded SHA-256 of the data. The file is moved into
+// place with place once the attachment has an ID; until then the caller is
+// responsible for removing it.
+func (s *AttachmentFileStore) writeTemp(data io.Reader) (string, int64, string, error) {
+	tmp, err := os.CreateTemp(s.Dir, ".attachment-*.tmp")
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), data)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", 0, "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, "", err
+	}
+	return tmp.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *AttachmentFileStore) place(tmpPath string, noteID int64, id int64) error {
+	if err := os.MkdirAll(s.noteDir(noteID), 0700); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path(noteID, id))
+}
+
+// Open returns the data of the attachment. The caller must close it.
+func (s *AttachmentFileStore) Open(noteID int64, id int64) (*os.File, error) {
+	return os.Open(s.path(noteID, id))
+}
+
+// Remove deletes the data of the attachment. It is not an error if there is none.
+func (s *AttachmentFileStore) Remove(noteID int64, id int64) error {
+	err := os.Remove(s.path(noteID, id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveNote deletes the data of every attachment of the note.
+func (s *AttachmentFileStore) RemoveNote(noteID int64) error {
+	return os.RemoveAll(s.noteDir(noteID))
+}
+
+func (s *AttachmentFileStore) noteDir(noteID int64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("note%015d", noteID))
+}
+
+func (s *AttachmentFileStore) path(noteID int64, id int64) string {
+	return filepath.Join(s.noteDir(noteID), fmt.Sprintf("attachment%015d.bin", id))
+}
+
+// NewAttachment records the metadata of a new attachment of the note,
+// returning it with its ID & creation time filled in. It returns nil if the
+// note doesn't exist or is in the trash.
+func NewAttachment(db DBTX, owner string, attachment *notes.Attachment) (*notes.Attachment, error) {
+	stmt, err := db.Prepare(`
+        INSERT INTO attachments (note_id, name, mime_type, size, sha256, created_on)
+            SELECT id, ?, ?, ?, ?, ?
+            FROM notes
+            WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	result, err := stmt.Exec(attachment.Name, attachment.MimeType, attachment.Size, attachment.SHA256, formatTime(now), attachment.NoteID, owner)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, nil
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	created := *attachment
+	created.ID = id
+	created.CreatedOn = now.Truncate(time.Second)
+	return &created, nil
+}
+
+func GetAttachments(db DBTX, owner string, noteID int64) ([]*notes.Attachment, error) {
+	stmt, err := db.Prepare(`
+        SELECT ` + attachmentColumns + `
+        FROM attachments
+            JOIN notes ON notes.id = attachments.note_id
+        WHERE note_id = ? AND owner_sub = ? AND deleted_on IS NULL
+        ORDER BY attachments.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(noteID, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := []*notes.Attachment{}
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+// GetAttachment returns the attachment of the note, or nil if it doesn't exist.
+func GetAttachment(db DBTX, owner string, noteID int64, id int64) (*notes.Attachment, error) {
+	stmt, err := db.Prepare(`
+        SELECT ` + attachmentColumns + `
+        FROM attachments
+            JOIN notes ON notes.id = attachments.note_id
+        WHERE attachments.id = ? AND note_id = ? AND owner_sub = ? AND deleted_on IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	attachment, err := scanAttachment(stmt.QueryRow(id, noteID, owner))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// DeleteAttachment deletes the metadata of the attachment. It returns false
+// if the note has no such attachment.
+func DeleteAttachment(db DBTX, owner string, noteID int64, id int64) (bool, error) {
+	stmt, err := db.Prepare(`
+        DELETE FROM attachments
+        WHERE id = ? AND note_id = (SELECT id FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL)`)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id, noteID, owner)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// Private
+
+const attachmentColumns = `attachments.id, attachments.note_id, attachments.name, attachments.mime_type,
+            attachments.size, attachments.sha256, attachments.created_on`
+
+func scanAttachment(row rowScanner) (*notes.Attachment, error) {
+	attachment := &notes.Attachment{}
+	var createdOn string
+	err := row.Scan(&attachment.ID, &attachment.NoteID, &attachment.Name, &attachment.MimeType,
+		&attachment.Size, &attachment.SHA256, &createdOn)
+	if err != nil {
+		return nil, err
+	}
+	if attachment.CreatedOn, err = parseTime(createdOn); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
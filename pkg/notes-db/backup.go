@@ -0,0 +1,232 @@
+package notesdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+const (
+	backupFilePrefix = "notes-"
+	backupFileSuffix = ".sqlite"
+	backupTimeFormat = "20060102T150405.000Z"
+)
+
+// BackupStore keeps snapshots of the DB as timestamped files under a single
+// directory. Only the DB is backed up: file-backed content & attachments live
+// outside of it.
+type BackupStore struct {
+	DB  *sql.DB
+	Dir string
+}
+
+func NewBackupStore(db *sql.DB, dir string) (*BackupStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &BackupStore{DB: db, Dir: dir}, nil
+}
+
+// Create snapshots the DB into a new backup file with VACUUM INTO, which
+// doesn't block writers for longer than a regular read does. The snapshot is
+// only moved into place once it passes an integrity check.
+func (s *BackupStore) Create() (*notes.Backup, error) {
+	now := time.Now().UTC()
+	name := backupFilePrefix + now.Format(backupTimeFormat) + backupFileSuffix
+	tmpPath := filepath.Join(s.Dir, "."+name+".tmp")
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	// VACUUM INTO refuses to overwrite, e.g. a leftover from a crash.
+	os.Remove(tmpPath)
+	if _, err := s.DB.Exec("VACUUM INTO ?", tmpPath); err != nil {
+		return nil, err
+	}
+	if err := CheckIntegrity(tmpPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.Dir, name)); err != nil {
+		return nil, err
+	}
+	return s.stat(name)
+}
+
+// List returns the backups in the directory, newest first.
+func (s *BackupStore) List() ([]*notes.Backup, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := []*notes.Backup{}
+	for _, e := range entries {
+		if _, ok := parseBackupName(e.Name()); !ok || e.IsDir() {
+			continue
+		}
+		backup, err := s.stat(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedOn.After(backups[j].CreatedOn) })
+	return backups, nil
+}
+
+// Prune deletes all but the newest keep backups, returning the deleted ones.
+func (s *BackupStore) Prune(keep int) ([]*notes.Backup, error) {
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) <= keep {
+		return []*notes.Backup{}, nil
+	}
+	for _, backup := range backups[keep:] {
+		if err := os.Remove(s.Path(backup.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return backups[keep:], nil
+}
+
+// Path returns the path of the backup with the given name.
+func (s *BackupStore) Path(name string) string {
+	return filepath.Join(s.Dir, name)
+}
+
+// CheckIntegrity runs PRAGMA integrity_check against the DB at the given
+// path, opened read-only, and returns an error listing any problems found.
+func CheckIntegrity(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("failed to check integrity of %s: %w", path, err)
+	}
+	defer rows.Close()
+
+	problems := []string{}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check integrity of %s: %w", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RestoreBackup replaces the DB at dbPath with a copy of the backup. Both the
+// backup & the copy have to pass an integrity check before the copy is swapped
+// in. The replaced DB is kept next to it with a .pre-restore-TIMESTAMP suffix
+// & its path returned. Nothing may have the DB open while it is restored.
+func RestoreBackup(backupPath string, dbPath string) (string, error) {
+	if err := CheckIntegrity(backupPath); err != nil {
+		return "", err
+	}
+	// A journal means the DB is in use or wasn't closed cleanly; swapping the
+	// file out from under it would corrupt whichever DB it ends up applied to.
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if _, err := os.Stat(dbPath + suffix); err == nil {
+			return "", fmt.Errorf("found %s%s: make sure nothing is using the DB", dbPath, suffix)
+		}
+	}
+
+	tmpPath := dbPath + ".restore.tmp"
+	defer os.Remove(tmpPath) // No-op once renamed
+	if err := copyFile(backupPath, tmpPath); err != nil {
+		return "", err
+	}
+	if err := CheckIntegrity(tmpPath); err != nil {
+		return "", err
+	}
+
+	replacedPath := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		replacedPath = dbPath + ".pre-restore-" + time.Now().UTC().Format(backupTimeFormat)
+		if _, err := os.Stat(replacedPath); err == nil {
+			return "", fmt.Errorf("%s already exists", replacedPath)
+		}
+		if err := os.Rename(dbPath, replacedPath); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		if replacedPath != "" {
+			os.Rename(replacedPath, dbPath)
+		}
+		return "", err
+	}
+	return replacedPath, nil
+}
+
+// Private
+
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileSuffix) {
+		return time.Time{}, false
+	}
+	createdOn, err := time.Parse(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, backupFilePrefix), backupFileSuffix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return createdOn, true
+}
+
+func (s *BackupStore) stat(name string) (*notes.Backup, error) {
+	createdOn, ok := parseBackupName(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid backup name: %s", name)
+	}
+	info, err := os.Stat(s.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &notes.Backup{Name: name, Size: info.Size(), CreatedOn: createdOn}, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
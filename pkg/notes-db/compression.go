@@ -0,0 +1,183 @@
+package notesdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionThreshold is the size in bytes from which note content is
+// compressed by default.
+const DefaultCompressionThreshold = 1024
+
+// Stored content that starts with one of these bytes has a header naming its
+// codec. Neither can start valid UTF-8, so text content stored before
+// compression was introduced never has a header by accident.
+const (
+	// codecNone marks uncompressed content that happens to start with a
+	// header byte, so it isn't mistaken for compressed content.
+	codecNone byte = 0xf7
+	codecZstd byte = 0xf8
+)
+
+var ErrInvalidCompressedContent = errors.New("invalid compressed content")
+
+// Neither can fail without options that do.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// CompressContent returns the content compressed with zstd behind a header
+// byte if it is at least threshold bytes long & compression makes it smaller.
+// Otherwise the content is returned as is. A threshold of 0 turns compression
+// off.
+func CompressContent(content []byte, threshold int) []byte {
+	if content == nil {
+		return nil
+	}
+	if threshold > 0 && len(content) >= threshold {
+		compressed := zstdEncoder.EncodeAll(content, []byte{codecZstd})
+		if len(compressed) < len(content) {
+			return compressed
+		}
+	}
+	if len(content) > 0 && (content[0] == codecNone || content[0] == codecZstd) {
+		return append([]byte{codecNone}, content...)
+	}
+	return content
+}
+
+// DecompressContent reverses CompressContent. Content without a header is
+// returned as is.
+func DecompressContent(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+	switch stored[0] {
+	case codecNone:
+		return stored[1:], nil
+	case codecZstd:
+		content, err := zstdDecoder.DecodeAll(stored[1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCompressedContent, err)
+		}
+		return content, nil
+	default:
+		return stored, nil
+	}
+}
+
+// IsCompressedContent returns whether the content, as returned by
+// CompressContent, is compressed.
+func IsCompressedContent(stored []byte) bool {
+	return len(stored) > 0 && stored[0] == codecZstd
+}
+
+// EncodeContent returns the content the way a SQLiteStore stores it:
+// compressed (see CompressContent), then encrypted with c, if it isn't nil.
+func EncodeContent(content []byte, threshold int, c *ContentCipher) ([]byte, error) {
+	return c.Encrypt(CompressContent(content, threshold))
+}
+
+// DecodeContent reverses EncodeContent.
+func DecodeContent(stored []byte, c *ContentCipher) ([]byte, error) {
+	compressed, err := c.Decrypt(stored)
+	if err != nil {
+		return nil, err
+	}
+	return DecompressContent(compressed)
+}
+
+// CompressionStats sums up how note content is stored.
+type CompressionStats struct {
+	// Pieces is the number of pieces of content: the current content of
+	// each note that has any, plus that of each revision.
+	Pieces     int
+	Compressed int
+	Rewritten  int
+	// ContentBytes is the size of all of the content once decoded.
+	ContentBytes int64
+	// StoredBytesBefore & StoredBytesAfter are the size of all of the content
+	// as stored before & after recompressing it.
+	StoredBytesBefore int64
+	StoredBytesAfter  int64
+}
+
+// RecompressContent makes sure all note content, regardless of owner, is
+// compressed according to the threshold: in notes_content, in revisions & in
+// content files. Content stays encrypted if it was, using the current key of
+// c. Like RekeyContent, each piece of content is rewritten on its own & only
+// if it hasn't changed since it was read, so the server can keep running
+// meanwhile, and the DB is vacuumed afterwards so that it actually shrinks.
+func RecompressContent(db *sql.DB, files *ContentFileStore, c *ContentCipher, threshold int) (*CompressionStats, error) {
+	stats := &CompressionStats{}
+	recompress := func(stored []byte) ([]byte, bool, error) {
+		if stored == nil {
+			return stored, false, nil
+		}
+		payload, err := c.Decrypt(stored)
+		if err != nil {
+			return nil, false, err
+		}
+		content, err := DecompressContent(payload)
+		if err != nil {
+			return nil, false, err
+		}
+		recompressed := CompressContent(content, threshold)
+
+		stats.Pieces++
+		stats.ContentBytes += int64(len(content))
+		stats.StoredBytesBefore += int64(len(stored))
+		if IsCompressedContent(recompressed) {
+			stats.Compressed++
+		}
+		if bytes.Equal(recompressed, payload) {
+			stats.StoredBytesAfter += int64(len(stored))
+			return stored, false, nil
+		}
+
+		rewritten := recompressed
+		if isEncryptedContent(stored) {
+			if rewritten, err = c.Encrypt(recompressed); err != nil {
+				return nil, false, err
+			}
+		}
+		stats.StoredBytesAfter += int64(len(rewritten))
+		return rewritten, true, nil
+	}
+
+	n, err := rewriteContentRows(db, "notes_content", "note_id", recompress)
+	stats.Rewritten += n
+	if err != nil {
+		return stats, fmt.Errorf("failed to recompress note content: %w", err)
+	}
+
+	n, err = rewriteContentRows(db, "notes_revisions", "note_id, revision", recompress)
+	stats.Rewritten += n
+	if err != nil {
+		return stats, fmt.Errorf("failed to recompress revisions: %w", err)
+	}
+
+	ids, err := GetNoteIDsByContentType(db, CONTENT_FILE)
+	if err != nil {
+		return stats, err
+	}
+	for _, id := range ids {
+		changed, err := rewriteContentFile(db, files, id, recompress)
+		if err != nil {
+			return stats, fmt.Errorf("failed to recompress content file of note %d: %w", id, err)
+		}
+		if changed {
+			stats.Rewritten++
+		}
+	}
+
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return stats, fmt.Errorf("failed to vacuum DB: %w", err)
+	}
+	return stats, nil
+}
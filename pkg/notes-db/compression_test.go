@@ -0,0 +1,66 @@
+package notesdb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodeContentRoundTrips(t *testing.T) {
+	long := []byte(strings.Repeat("a note that compresses well. ", 100))
+	contents := map[string][]byte{
+		"nil":            nil,
+		"empty":          {},
+		"short":          []byte("hello"),
+		"long":           long,
+		"codec none":     {codecNone, 'x'},
+		"codec zstd":     {codecZstd, 'x'},
+		"long codec":     append([]byte{codecZstd}, long...),
+		"incompressible": {codecNone, 0x00, 0x13, 0x37},
+	}
+
+	for cipherName, c := range map[string]*ContentCipher{"plain": nil, "encrypted": testCipher(t)} {
+		for _, threshold := range []int{0, 1, DefaultCompressionThreshold} {
+			for name, content := range contents {
+				stored, err := EncodeContent(content, threshold, c)
+				if err != nil {
+					t.Fatalf("%s, %s, threshold %d: %s", cipherName, name, threshold, err)
+				}
+				if c != nil && content != nil && !isEncryptedContent(stored) {
+					t.Errorf("%s, %s, threshold %d: expected the content to be encrypted", cipherName, name, threshold)
+				}
+				if c == nil && threshold > 0 && len(content) >= threshold && name == "long" && !IsCompressedContent(stored) {
+					t.Errorf("%s, %s, threshold %d: expected the content to be compressed", cipherName, name, threshold)
+				}
+
+				decoded, err := DecodeContent(stored, c)
+				if err != nil {
+					t.Fatalf("%s, %s, threshold %d: %s", cipherName, name, threshold, err)
+				}
+				if !bytes.Equal(decoded, content) || (content == nil) != (decoded == nil) {
+					t.Errorf("%s, %s, threshold %d: expected %q, got %q", cipherName, name, threshold, content, decoded)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeContentReadsPlaintext(t *testing.T) {
+	// Content stored before compression & encryption were turned on reads
+	// back as is, with or without a cipher.
+	for _, c := range []*ContentCipher{nil, testCipher(t)} {
+		decoded, err := DecodeContent([]byte("old content"), c)
+		if err != nil || string(decoded) != "old content" {
+			t.Errorf("expected old content, got %q (err: %v)", decoded, err)
+		}
+	}
+
+	stored, err := EncodeContent([]byte("secret"), DefaultCompressionThreshold, testCipher(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeContent(stored, nil); !errors.Is(err, ErrUnknownContentKey) {
+		t.Errorf("expected ErrUnknownContentKey without a cipher, got %v", err)
+	}
+}
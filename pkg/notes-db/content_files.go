@@ -0,0 +1,278 @@
+package notesdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ContentFileStore keeps the content of CONTENT_FILE notes as one file per
+// note under a single directory.
+type ContentFileStore struct {
+	Dir string
+}
+
+func NewContentFileStore(dir string) (*ContentFileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &ContentFileStore{Dir: dir}, nil
+}
+
+// Read returns the content of the note, or nil if it has none yet.
+func (s *ContentFileStore) Read(id int64) ([]byte, error) {
+	content, err := os.ReadFile(s.path(id))
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Write replaces the content of the note. The content is written to a
+// temporary file first & renamed over the old one, so readers never see a
+// partially-written file.
+func (s *ContentFileStore) Write(id int64, content []byte) error {
+	tmp, err := os.CreateTemp(s.Dir, fmt.Sprintf(".note%015d-*.tmp", id))
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path(id))
+}
+
+// Remove deletes the content of the note. It is not an error if there is none.
+func (s *ContentFileStore) Remove(id int64) error {
+	err := os.Remove(s.path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *ContentFileStore) path(id int64) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("note%015d.txt", id))
+}
+
+// IndexNoteContent updates the search index with the content of a note.
+func IndexNoteContent(db DBTX, id int64, content []byte) error {
+	stmt, err := db.Prepare("UPDATE notes_search SET content = ? WHERE rowid = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(string(content), id)
+	return err
+}
+
+// ConvertNoteContent moves the content of the note, regardless of owner, to
+// the given content type. It is a no-op if the note already has that type.
+// Content is moved as stored, so encrypted content stays encrypted.
+func ConvertNoteContent(db *sql.DB, files *ContentFileStore, id int64, contentType int) error {
+	var current int
+	err := db.QueryRow("SELECT content_type_id FROM notes WHERE id = ?", id).Scan(&current)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no note with id: %d", id)
+	} else if err != nil {
+		return err
+	}
+	if current == contentType {
+		return nil
+	}
+
+	switch {
+	case current == CONTENT_SQL && contentType == CONTENT_FILE:
+		var content []byte
+		err := db.QueryRow("SELECT content FROM notes_content WHERE note_id = ?", id).Scan(&content)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		// Written before the DB is touched; if the transaction fails the file
+		// is simply overwritten by the next attempt.
+		if err := files.Write(id, content); err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE notes SET content_type_id = ? WHERE id = ?", CONTENT_FILE, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM notes_content WHERE note_id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+
+	case current == CONTENT_FILE && contentType == CONTENT_SQL:
+		content, err := files.Read(id)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE notes SET content_type_id = ? WHERE id = ?", CONTENT_SQL, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+            INSERT INTO notes_content (note_id, content) VALUES (?, ?)
+                ON CONFLICT(note_id) DO UPDATE SET content = excluded.content`,
+			id, content)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return files.Remove(id)
+
+	default:
+		return fmt.Errorf("cannot convert note %d from content type %d to %d", id, current, contentType)
+	}
+}
+
+// GetNoteIDsByContentType returns the IDs of all notes, regardless of owner,
+// with the given content type.
+func GetNoteIDsByContentType(db DBTX, contentType int) ([]int64, error) {
+	rows, err := db.Query("SELECT id FROM notes WHERE content_type_id = ? ORDER BY id", contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Private
+
+// rewriteContentRows rewrites the content column of every row of the table,
+// which is keyed by the given columns, with whatever rewrite returns for it. A
+// row is only replaced if rewrite says the content changed & nobody has written
+// the row since it was read. It returns the number of rows rewritten.
+func rewriteContentRows(db *sql.DB, table string, keyColumns string, rewrite func([]byte) ([]byte, bool, error)) (int, error) {
+	keys := strings.Split(keyColumns, ", ")
+	where := strings.Join(keys, " = ? AND ") + " = ?"
+
+	rows, err := db.Query("SELECT " + keyColumns + " FROM " + table + " ORDER BY " + keyColumns)
+	if err != nil {
+		return 0, err
+	}
+	rowKeys := [][]any{}
+	for rows.Next() {
+		rowKey := make([]any, len(keys))
+		dest := make([]any, len(keys))
+		for i := range rowKey {
+			dest[i] = &rowKey[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rowKeys = append(rowKeys, rowKey)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, rowKey := range rowKeys {
+		var stored []byte
+		err := db.QueryRow("SELECT content FROM "+table+" WHERE "+where, rowKey...).Scan(&stored)
+		if err != nil && errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		rewritten, changed, err := rewrite(stored)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+		args := append([]any{rewritten}, rowKey...)
+		result, err := db.Exec("UPDATE "+table+" SET content = ? WHERE "+where+" AND content = ?", append(args, stored)...)
+		if err != nil {
+			return count, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return count, err
+		} else if affected > 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// rewriteContentFile rewrites the content file of the note like
+// rewriteContentRows. The note is locked for writing while the file is
+// rewritten, and the file is checked again just before it is replaced, so
+// concurrent writes of the note aren't lost.
+func rewriteContentFile(db *sql.DB, files *ContentFileStore, id int64, rewrite func([]byte) ([]byte, bool, error)) (bool, error) {
+	replaced := false
+	err := RunInTx(db, func(tx *sql.Tx) error {
+		// A no-op write takes SQLite's write lock, which writers of the note's
+		// content need to bump its version.
+		if _, err := tx.Exec("UPDATE notes SET version = version WHERE id = ?", id); err != nil {
+			return err
+		}
+		stored, err := files.Read(id)
+		if err != nil {
+			return err
+		}
+		rewritten, changed, err := rewrite(stored)
+		if err != nil || !changed {
+			return err
+		}
+		current, err := files.Read(id)
+		if err != nil || !bytes.Equal(current, stored) {
+			return err
+		}
+		if err := files.Write(id, rewritten); err != nil {
+			return err
+		}
+		replaced = true
+		return nil
+	})
+	return replaced, err
+}
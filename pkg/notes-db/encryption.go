@@ -0,0 +1,323 @@
+package notesdb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnknownContentKey = errors.New("content is encrypted with an unknown key")
+	ErrInvalidCiphertext = errors.New("invalid encrypted content")
+)
+
+// ContentKey is a 256-bit master key along with the ID stored next to
+// everything encrypted with it.
+type ContentKey struct {
+	ID  string
+	Key []byte
+}
+
+// ContentCipher encrypts note content at rest with AES-256-GCM. Each piece of
+// content is encrypted with its own random data key, which is in turn
+// encrypted ("wrapped") with the current master key. Rotating master keys then
+// only means re-wrapping data keys (see Rewrap), not re-encrypting content.
+//
+// Content that doesn't start with the encrypted content header is treated as
+// plaintext written before encryption was turned on, so it stays readable. A
+// nil *ContentCipher doesn't encrypt anything & only reads plaintext.
+type ContentCipher struct {
+	keys    map[string][]byte
+	current string
+}
+
+// Encrypted content is laid out as:
+//
+//	magic (4) | key ID length (1) | key ID | wrap nonce (12) | wrapped data key (48) | nonce (12) | ciphertext
+//
+// The header up to & including the key ID is authenticated when wrapping the
+// data key; the magic is authenticated when encrypting the content.
+var encryptedContentMagic = []byte("NAE\x01")
+
+const (
+	contentKeySize   = 32
+	contentNonceSize = 12
+	wrappedKeySize   = contentKeySize + 16
+)
+
+var contentKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// NewContentCipher returns a cipher that can decrypt content encrypted with any
+// of the keys & encrypts with the last one.
+func NewContentCipher(keys []ContentKey) (*ContentCipher, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no content keys given")
+	}
+	c := &ContentCipher{keys: map[string][]byte{}}
+	for _, key := range keys {
+		if !contentKeyIDPattern.MatchString(key.ID) {
+			return nil, fmt.Errorf("invalid content key ID %q: must be 1-64 letters, digits, '.', '_' or '-'", key.ID)
+		}
+		if len(key.Key) != contentKeySize {
+			return nil, fmt.Errorf("content key %s must be %d bytes, not %d", key.ID, contentKeySize, len(key.Key))
+		}
+		if _, ok := c.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate content key ID: %s", key.ID)
+		}
+		c.keys[key.ID] = key.Key
+		c.current = key.ID
+	}
+	return c, nil
+}
+
+// ParseContentKeys parses keys written as ID:BASE64 entries separated by
+// whitespace or commas. Lines starting with # are ignored.
+func ParseContentKeys(text string) ([]ContentKey, error) {
+	keys := []ContentKey{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, entry := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			id, encoded, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid content key entry: expected ID:BASE64")
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content key %s: %w", id, err)
+			}
+			keys = append(keys, ContentKey{ID: id, Key: key})
+		}
+	}
+	return keys, nil
+}
+
+// CurrentKeyID returns the ID of the key new content is encrypted with, or ""
+// if c is nil.
+func (c *ContentCipher) CurrentKeyID() string {
+	if c == nil {
+		return ""
+	}
+	return c.current
+}
+
+// Encrypt encrypts the content with a new data key wrapped with the current
+// key. Nil content stays nil so that "no content yet" survives the round trip.
+func (c *ContentCipher) Encrypt(content []byte) ([]byte, error) {
+	if c == nil || content == nil {
+		return content, nil
+	}
+
+	dataKey := make([]byte, contentKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	header, err := c.wrapDataKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, contentNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, content, encryptedContentMagic), nil
+}
+
+// Decrypt returns the plaintext of content written by Encrypt. Content without
+// the encrypted content header is returned as is.
+func (c *ContentCipher) Decrypt(stored []byte) ([]byte, error) {
+	if !isEncryptedContent(stored) {
+		return stored, nil
+	}
+	dataKey, rest, err := c.unwrapDataKey(stored)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < contentNonceSize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	// Open into an empty slice so that empty content doesn't come back as nil,
+	// i.e. "no content yet".
+	content, err := gcm.Open([]byte{}, rest[:contentNonceSize], rest[contentNonceSize:], encryptedContentMagic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCiphertext, err)
+	}
+	return content, nil
+}
+
+// Rewrap returns the content with its data key wrapped with the current key,
+// encrypting it first if it is plaintext. It returns false, along with the
+// content unchanged, if the content already uses the current key.
+func (c *ContentCipher) Rewrap(stored []byte) ([]byte, bool, error) {
+	if c == nil || stored == nil {
+		return stored, false, nil
+	}
+	if !isEncryptedContent(stored) {
+		encrypted, err := c.Encrypt(stored)
+		return encrypted, err == nil, err
+	}
+	if keyID, _, _ := parseContentKeyID(stored); keyID == c.current {
+		return stored, false, nil
+	}
+
+	dataKey, rest, err := c.unwrapDataKey(stored)
+	if err != nil {
+		return nil, false, err
+	}
+	header, err := c.wrapDataKey(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(header, rest...), true, nil
+}
+
+// RekeyContent makes sure all note content, regardless of owner, is encrypted
+// with the current key of c: in notes_content, in revisions & in content
+// files. Each piece of content is rewritten on its own, and only if it hasn't
+// changed since it was read, so the server can keep running meanwhile. Since
+// the search index would otherwise keep a plaintext copy of the content, it is
+// cleared of content as well, and the DB is vacuumed so that no old plaintext
+// is left in free pages. It returns the number of pieces of content rewritten.
+func RekeyContent(db *sql.DB, files *ContentFileStore, c *ContentCipher) (int, error) {
+	if c == nil {
+		return 0, fmt.Errorf("no content keys given")
+	}
+
+	rewritten := 0
+	n, err := rewriteContentRows(db, "notes_content", "note_id", c.Rewrap)
+	if err != nil {
+		return rewritten, fmt.Errorf("failed to rekey note content: %w", err)
+	}
+	rewritten += n
+
+	n, err = rewriteContentRows(db, "notes_revisions", "note_id, revision", c.Rewrap)
+	if err != nil {
+		return rewritten, fmt.Errorf("failed to rekey revisions: %w", err)
+	}
+	rewritten += n
+
+	ids, err := GetNoteIDsByContentType(db, CONTENT_FILE)
+	if err != nil {
+		return rewritten, err
+	}
+	for _, id := range ids {
+		changed, err := rewriteContentFile(db, files, id, c.Rewrap)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to rekey content file of note %d: %w", id, err)
+		}
+		if changed {
+			rewritten++
+		}
+	}
+
+	if _, err := db.Exec("UPDATE notes_search SET content = '' WHERE content != ''"); err != nil {
+		return rewritten, fmt.Errorf("failed to clear content from search index: %w", err)
+	}
+	// Old plaintext lingers in FTS segments until they are merged, and in
+	// free pages until the DB is vacuumed.
+	if _, err := db.Exec("INSERT INTO notes_search (notes_search) VALUES ('optimize')"); err != nil {
+		return rewritten, fmt.Errorf("failed to optimize search index: %w", err)
+	}
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return rewritten, fmt.Errorf("failed to vacuum DB: %w", err)
+	}
+	return rewritten, nil
+}
+
+// Private
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func isEncryptedContent(stored []byte) bool {
+	return bytes.HasPrefix(stored, encryptedContentMagic)
+}
+
+// parseContentKeyID returns the key ID of encrypted content along with the
+// length of the header up to & including it.
+func parseContentKeyID(stored []byte) (string, int, error) {
+	if len(stored) < len(encryptedContentMagic)+1 {
+		return "", 0, ErrInvalidCiphertext
+	}
+	idLen := int(stored[len(encryptedContentMagic)])
+	end := len(encryptedContentMagic) + 1 + idLen
+	if len(stored) < end {
+		return "", 0, ErrInvalidCiphertext
+	}
+	return string(stored[len(encryptedContentMagic)+1 : end]), end, nil
+}
+
+// wrapDataKey returns the header of content encrypted with the data key, up
+// to but excluding the content nonce.
+func (c *ContentCipher) wrapDataKey(dataKey []byte) ([]byte, error) {
+	header := append([]byte{}, encryptedContentMagic...)
+	header = append(header, byte(len(c.current)))
+	header = append(header, c.current...)
+
+	gcm, err := newGCM(c.keys[c.current])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, contentNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aad := header
+	header = append(header[:len(header):len(header)], nonce...)
+	return gcm.Seal(header, nonce, dataKey, aad), nil
+}
+
+// unwrapDataKey returns the data key of encrypted content along with the rest
+// of it, starting at the content nonce.
+func (c *ContentCipher) unwrapDataKey(stored []byte) ([]byte, []byte, error) {
+	keyID, end, err := parseContentKeyID(stored)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key []byte
+	if c != nil {
+		key = c.keys[keyID]
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownContentKey, keyID)
+	}
+	if len(stored) < end+contentNonceSize+wrappedKeySize {
+		return nil, nil, ErrInvalidCiphertext
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := stored[end : end+contentNonceSize]
+	wrapped := stored[end+contentNonceSize : end+contentNonceSize+wrappedKeySize]
+	dataKey, err := gcm.Open(nil, nonce, wrapped, stored[:end])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidCiphertext, err)
+	}
+	return dataKey, stored[end+contentNonceSize+wrappedKeySize:], nil
+}
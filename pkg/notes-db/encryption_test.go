@@ -0,0 +1,92 @@
+package notesdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testCipher returns a cipher with a key of the given ID for each fill byte,
+// encrypting with the last one.
+func testCipher(t *testing.T, keys ...byte) *ContentCipher {
+	t.Helper()
+	if len(keys) == 0 {
+		keys = []byte{1}
+	}
+	entries := []string{}
+	for _, b := range keys {
+		entries = append(entries, fmt.Sprintf("k%d:%s", b, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, contentKeySize))))
+	}
+	parsed, err := ParseContentKeys(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewContentCipher(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptRoundTrips(t *testing.T) {
+	c := testCipher(t)
+	for _, content := range [][]byte{nil, {}, []byte("secret"), []byte("NAE\x01 looks encrypted")} {
+		stored, err := c.Encrypt(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != nil && (!isEncryptedContent(stored) || bytes.Contains(stored, content) && len(content) > 0) {
+			t.Errorf("%q: expected the content to be encrypted, got %q", content, stored)
+		}
+		decrypted, err := c.Decrypt(stored)
+		if err != nil {
+			t.Fatalf("%q: %s", content, err)
+		}
+		// Nil means no content yet, so it must not be confused with empty.
+		if !bytes.Equal(decrypted, content) || (content == nil) != (decrypted == nil) {
+			t.Errorf("expected %q, got %q", content, decrypted)
+		}
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	stored, err := testCipher(t, 1).Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testCipher(t, 2).Decrypt(stored); !errors.Is(err, ErrUnknownContentKey) {
+		t.Errorf("expected ErrUnknownContentKey, got %v", err)
+	}
+
+	tampered := append([]byte{}, stored...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := testCipher(t, 1).Decrypt(tampered); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("expected ErrInvalidCiphertext, got %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := testCipher(t, 1)
+	rotated := testCipher(t, 1, 2)
+	stored, err := old.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, changed, err := rotated.Rewrap(stored)
+	if err != nil || !changed {
+		t.Fatalf("expected the content to be rewrapped, got %v (err: %v)", changed, err)
+	}
+	if _, err := old.Decrypt(rewrapped); !errors.Is(err, ErrUnknownContentKey) {
+		t.Errorf("expected the old key to be unable to decrypt, got %v", err)
+	}
+	if decrypted, err := testCipher(t, 2).Decrypt(rewrapped); err != nil || string(decrypted) != "secret" {
+		t.Errorf("expected secret, got %q (err: %v)", decrypted, err)
+	}
+	if _, changed, err := rotated.Rewrap(rewrapped); err != nil || changed {
+		t.Errorf("expected content with the current key to be left alone, got %v (err: %v)", changed, err)
+	}
+}
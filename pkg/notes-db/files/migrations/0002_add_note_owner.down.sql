@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_notes_owner_sub;
+
+ALTER TABLE notes DROP COLUMN owner_sub;
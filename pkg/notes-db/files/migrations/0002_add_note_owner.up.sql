@@ -0,0 +1,5 @@
+-- Notes created before ownership was introduced are left with an empty owner;
+-- use `notes-api assign-owner` to hand them to a user.
+ALTER TABLE notes ADD COLUMN owner_sub TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_notes_owner_sub ON notes (owner_sub);
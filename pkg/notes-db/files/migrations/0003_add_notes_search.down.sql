@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS notes_search_content_delete;
+DROP TRIGGER IF EXISTS notes_search_content_update;
+DROP TRIGGER IF EXISTS notes_search_content_insert;
+DROP TRIGGER IF EXISTS notes_search_note_delete;
+DROP TRIGGER IF EXISTS notes_search_note_update;
+DROP TRIGGER IF EXISTS notes_search_note_insert;
+
+DROP TABLE IF EXISTS notes_search;
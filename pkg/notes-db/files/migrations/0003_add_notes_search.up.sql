@@ -0,0 +1,45 @@
+-- Requires SQLite to be built with FTS5; for go-sqlite3 that means building
+-- with `-tags sqlite_fts5`.
+CREATE VIRTUAL TABLE IF NOT EXISTS
+    notes_search
+    USING fts5
+    ( title
+    , content
+    , tokenize = 'porter unicode61'
+    );
+
+-- The rowid of each row in notes_search is the ID of the note it indexes.
+INSERT INTO notes_search (rowid, title, content)
+    SELECT id, title, COALESCE(CAST(content AS TEXT), '')
+    FROM notes
+        LEFT JOIN notes_content ON notes.id = notes_content.note_id;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_note_insert AFTER INSERT ON notes
+BEGIN
+    INSERT INTO notes_search (rowid, title, content) VALUES (new.id, new.title, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_note_update AFTER UPDATE OF title ON notes
+BEGIN
+    UPDATE notes_search SET title = new.title WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_note_delete AFTER DELETE ON notes
+BEGIN
+    DELETE FROM notes_search WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_content_insert AFTER INSERT ON notes_content
+BEGIN
+    UPDATE notes_search SET content = COALESCE(CAST(new.content AS TEXT), '') WHERE rowid = new.note_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_content_update AFTER UPDATE OF content ON notes_content
+BEGIN
+    UPDATE notes_search SET content = COALESCE(CAST(new.content AS TEXT), '') WHERE rowid = new.note_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_content_delete AFTER DELETE ON notes_content
+BEGIN
+    UPDATE notes_search SET content = '' WHERE rowid = old.note_id;
+END;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS
+    notes_revisions
+    ( note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , revision INTEGER NOT NULL
+    , title TEXT NOT NULL
+    , content BLOB
+    , created_on TEXT NOT NULL
+    , PRIMARY KEY (note_id, revision)
+    );
+
+-- Seed every existing note with its current state so there's something to restore to.
+INSERT INTO notes_revisions (note_id, revision, title, content, created_on)
+    SELECT id, 1, title, content, updated_on
+    FROM notes
+        LEFT JOIN notes_content ON notes.id = notes_content.note_id;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_notes_deleted_on;
+
+-- Anything still in the trash would otherwise reappear.
+DELETE FROM notes WHERE deleted_on IS NOT NULL;
+
+ALTER TABLE notes DROP COLUMN deleted_on;
@@ -0,0 +1,4 @@
+-- Notes with a non-NULL deleted_on are in the trash.
+ALTER TABLE notes ADD COLUMN deleted_on TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_notes_deleted_on ON notes (deleted_on);
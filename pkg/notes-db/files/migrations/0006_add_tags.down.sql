@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_note_tags_tag_id;
+DROP TABLE IF EXISTS note_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS
+    tags
+    ( id INTEGER PRIMARY KEY
+    , owner_sub TEXT NOT NULL
+    , name TEXT NOT NULL COLLATE NOCASE
+    , UNIQUE (owner_sub, name)
+    );
+
+CREATE TABLE IF NOT EXISTS
+    note_tags
+    ( note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE
+    , PRIMARY KEY (note_id, tag_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags (tag_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_notes_notebook_id;
+
+ALTER TABLE notes DROP COLUMN notebook_id;
+
+DROP INDEX IF EXISTS idx_notebooks_parent_id;
+DROP INDEX IF EXISTS idx_notebooks_owner_sub;
+DROP TABLE IF EXISTS notebooks;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS
+    notebooks
+    ( id INTEGER PRIMARY KEY
+    , owner_sub TEXT NOT NULL
+    , parent_id INTEGER REFERENCES notebooks(id) ON DELETE CASCADE
+    , name TEXT NOT NULL
+    , created_on TEXT NOT NULL
+    , updated_on TEXT NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_notebooks_owner_sub ON notebooks (owner_sub);
+CREATE INDEX IF NOT EXISTS idx_notebooks_parent_id ON notebooks (parent_id);
+
+-- Notes with a NULL notebook_id live at the top level.
+ALTER TABLE notes ADD COLUMN notebook_id INTEGER REFERENCES notebooks(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_notes_notebook_id ON notes (notebook_id);
@@ -0,0 +1,3 @@
+-- Notes must be converted back to the 'sql' content type first; their content
+-- lives outside the DB.
+DELETE FROM content_type WHERE id = 2;
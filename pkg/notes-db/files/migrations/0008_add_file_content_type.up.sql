@@ -0,0 +1 @@
+INSERT OR REPLACE INTO content_type (id, name) VALUES (2, 'file');
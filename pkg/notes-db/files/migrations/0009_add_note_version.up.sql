@@ -0,0 +1,2 @@
+-- Incremented on every change to a note, for optimistic concurrency (ETag / If-Match).
+ALTER TABLE notes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
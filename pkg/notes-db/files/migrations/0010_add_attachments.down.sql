@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_attachments_note_id;
+DROP TABLE IF EXISTS attachments;
@@ -0,0 +1,13 @@
+-- Only metadata lives here; the data of each attachment is kept in a file (see AttachmentFileStore).
+CREATE TABLE IF NOT EXISTS
+    attachments
+    ( id INTEGER PRIMARY KEY
+    , note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , name TEXT NOT NULL
+    , mime_type TEXT NOT NULL
+    , size INTEGER NOT NULL
+    , sha256 TEXT NOT NULL
+    , created_on TEXT NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_attachments_note_id ON attachments (note_id);
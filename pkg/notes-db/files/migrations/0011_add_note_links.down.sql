@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_note_links_target_title;
+DROP INDEX IF EXISTS idx_note_links_target_id;
+DROP INDEX IF EXISTS idx_note_links_source_id;
+DROP TABLE IF EXISTS note_links;
@@ -0,0 +1,14 @@
+-- Links are stored as written and resolved when read, so that a [[Title]] link
+-- follows whichever note currently has that title. Existing notes are parsed by
+-- `notes-api reindex-links`.
+CREATE TABLE IF NOT EXISTS
+    note_links
+    ( source_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , text TEXT NOT NULL
+    , target_id INTEGER
+    , target_title TEXT
+    );
+
+CREATE INDEX IF NOT EXISTS idx_note_links_source_id ON note_links (source_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_target_id ON note_links (target_id);
+CREATE INDEX IF NOT EXISTS idx_note_links_target_title ON note_links (target_title COLLATE NOCASE);
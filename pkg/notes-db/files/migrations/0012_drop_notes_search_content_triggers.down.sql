@@ -0,0 +1,14 @@
+CREATE TRIGGER IF NOT EXISTS notes_search_content_insert AFTER INSERT ON notes_content
+BEGIN
+    UPDATE notes_search SET content = COALESCE(CAST(new.content AS TEXT), '') WHERE rowid = new.note_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_content_update AFTER UPDATE OF content ON notes_content
+BEGIN
+    UPDATE notes_search SET content = COALESCE(CAST(new.content AS TEXT), '') WHERE rowid = new.note_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS notes_search_content_delete AFTER DELETE ON notes_content
+BEGIN
+    UPDATE notes_search SET content = '' WHERE rowid = old.note_id;
+END;
@@ -0,0 +1,6 @@
+-- Note content may be encrypted (see ContentCipher), so the search index can
+-- no longer be fed straight from notes_content; the store indexes content
+-- itself (see IndexNoteContent).
+DROP TRIGGER IF EXISTS notes_search_content_insert;
+DROP TRIGGER IF EXISTS notes_search_content_update;
+DROP TRIGGER IF EXISTS notes_search_content_delete;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_legacy_imports_note_id;
+DROP TABLE IF EXISTS legacy_imports;
@@ -0,0 +1,13 @@
+-- Tracks which notes of the legacy file-based store (internal/notes) have been
+-- imported, so that `notes-api import-legacy` can be re-run safely. The row
+-- outlives the note it created so that purged notes aren't imported again.
+CREATE TABLE IF NOT EXISTS
+    legacy_imports
+    ( owner_sub TEXT NOT NULL
+    , legacy_id TEXT NOT NULL
+    , note_id INTEGER REFERENCES notes(id) ON DELETE SET NULL
+    , imported_on TEXT NOT NULL
+    , PRIMARY KEY (owner_sub, legacy_id)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_legacy_imports_note_id ON legacy_imports (note_id);
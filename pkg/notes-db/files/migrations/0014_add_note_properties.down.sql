@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_note_properties_name;
+DROP TABLE IF EXISTS note_properties;
@@ -0,0 +1,14 @@
+-- Typed key/value properties of notes. The value column has no declared type,
+-- so each value keeps the storage class of its type: TEXT for strings & dates
+-- (as YYYY-MM-DD), REAL for numbers & INTEGER 0/1 for bools. That way values
+-- of the same type compare correctly in filters.
+CREATE TABLE IF NOT EXISTS
+    note_properties
+    ( note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , name TEXT NOT NULL
+    , type TEXT NOT NULL CHECK (type IN ('string', 'number', 'bool', 'date'))
+    , value NOT NULL
+    , PRIMARY KEY (note_id, name)
+    );
+
+CREATE INDEX IF NOT EXISTS idx_note_properties_name ON note_properties (name, type, value);
@@ -0,0 +1,3 @@
+ALTER TABLE notes DROP COLUMN archived;
+ALTER TABLE notes DROP COLUMN favorite;
+ALTER TABLE notes DROP COLUMN pinned;
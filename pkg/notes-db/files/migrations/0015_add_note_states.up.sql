@@ -0,0 +1,5 @@
+-- Flags set by the user on each note. Archived notes are left out of note
+-- lists unless asked for.
+ALTER TABLE notes ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notes ADD COLUMN favorite INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE notes ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
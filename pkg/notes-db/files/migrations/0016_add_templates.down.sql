@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_templates_owner_sub;
+DROP TABLE IF EXISTS templates;
@@ -0,0 +1,14 @@
+-- Title & content are text/template source, rendered when a note is created
+-- from the template.
+CREATE TABLE IF NOT EXISTS
+    templates
+    ( id INTEGER PRIMARY KEY
+    , owner_sub TEXT NOT NULL
+    , name TEXT NOT NULL
+    , title TEXT NOT NULL
+    , content TEXT NOT NULL
+    , created_on TEXT NOT NULL
+    , updated_on TEXT NOT NULL
+    );
+
+CREATE INDEX IF NOT EXISTS idx_templates_owner_sub ON templates (owner_sub);
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_notifications_note_id;
+DROP INDEX IF EXISTS idx_notifications_owner_sub;
+DROP TABLE IF EXISTS notifications;
+
+DROP INDEX IF EXISTS idx_notes_next_reminder_at;
+
+ALTER TABLE notes DROP COLUMN next_reminder_at;
+ALTER TABLE notes DROP COLUMN recurrence;
+ALTER TABLE notes DROP COLUMN remind_at;
+ALTER TABLE notes DROP COLUMN due_on;
@@ -0,0 +1,24 @@
+-- remind_at is when the reminder of a note was set to fire and anchors its
+-- recurrence; next_reminder_at is when it fires next, or NULL once a one-off
+-- reminder has fired.
+ALTER TABLE notes ADD COLUMN due_on TEXT;
+ALTER TABLE notes ADD COLUMN remind_at TEXT;
+ALTER TABLE notes ADD COLUMN recurrence TEXT CHECK (recurrence IN ('daily', 'weekly', 'monthly'));
+ALTER TABLE notes ADD COLUMN next_reminder_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_notes_next_reminder_at ON notes (next_reminder_at);
+
+-- In-app notifications, e.g. of reminders that fired.
+CREATE TABLE IF NOT EXISTS
+    notifications
+    ( id INTEGER PRIMARY KEY
+    , owner_sub TEXT NOT NULL
+    , note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , title TEXT NOT NULL
+    , message TEXT NOT NULL
+    , created_on TEXT NOT NULL
+    , read_on TEXT
+    );
+
+CREATE INDEX IF NOT EXISTS idx_notifications_owner_sub ON notifications (owner_sub);
+CREATE INDEX IF NOT EXISTS idx_notifications_note_id ON notifications (note_id);
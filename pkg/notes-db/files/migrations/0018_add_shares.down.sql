@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_shares_note_id;
+DROP INDEX IF EXISTS idx_shares_owner_sub;
+DROP TABLE IF EXISTS shares;
@@ -0,0 +1,19 @@
+-- Public links to single notes. Only a SHA-256 hash of each token is kept, and
+-- revoked shares are kept along with their access counts.
+CREATE TABLE IF NOT EXISTS
+    shares
+    ( id INTEGER PRIMARY KEY
+    , owner_sub TEXT NOT NULL
+    , note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE
+    , token_hash TEXT NOT NULL UNIQUE
+    , mode TEXT NOT NULL CHECK (mode IN ('text', 'html'))
+    , password_hash TEXT
+    , expires_on TEXT
+    , created_on TEXT NOT NULL
+    , revoked_on TEXT
+    , access_count INTEGER NOT NULL DEFAULT 0
+    , last_accessed_on TEXT
+    );
+
+CREATE INDEX IF NOT EXISTS idx_shares_owner_sub ON shares (owner_sub);
+CREATE INDEX IF NOT EXISTS idx_shares_note_id ON shares (note_id);
@@ -0,0 +1,87 @@
+package notesdb
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LegacyImport records that a note of the legacy file-based store
+// (internal/notes) has been imported. NoteID is nil if the imported note has
+// since been purged.
+type LegacyImport struct {
+	LegacyID   string
+	NoteID     *int64
+	ImportedOn time.Time
+}
+
+// GetLegacyImport returns the import of the owner's legacy note, or nil if it
+// hasn't been imported.
+func GetLegacyImport(db DBTX, owner string, legacyID string) (*LegacyImport, error) {
+	stmt, err := db.Prepare("SELECT legacy_id, note_id, imported_on FROM legacy_imports WHERE owner_sub = ? AND legacy_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	imported := &LegacyImport{}
+	var noteID sql.NullInt64
+	var importedOn string
+	err = stmt.QueryRow(owner, legacyID).Scan(&imported.LegacyID, &noteID, &importedOn)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if noteID.Valid {
+		imported.NoteID = &noteID.Int64
+	}
+	if imported.ImportedOn, err = parseTime(importedOn); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// ImportLegacyNote creates a note for the owner from a note of the legacy
+// store, keeping its timestamps, unless it has been imported before. It
+// returns the import along with whether it happened just now. The note goes
+// through the store like any other, so its content is stored, encrypted,
+// indexed & recorded as its first revision as usual.
+func (s *SQLiteStore) ImportLegacyNote(owner string, legacyID string, title string, content []byte, createdOn time.Time, updatedOn time.Time) (*LegacyImport, bool, error) {
+	var imported *LegacyImport
+	created := false
+	err := s.atomically(func(tx *SQLiteStore) error {
+		var err error
+		if imported, err = GetLegacyImport(tx.db(), owner, legacyID); err != nil || imported != nil {
+			return err
+		}
+
+		entry, err := tx.NewNote(owner, title)
+		if err != nil {
+			return err
+		}
+		if err := tx.SetNoteContents(owner, entry.ID, content, 0); err != nil {
+			return err
+		}
+		if err := tx.RecordRevision(owner, entry.ID, 0); err != nil {
+			return err
+		}
+		if err := tx.SetNoteTimes(owner, entry.ID, createdOn, updatedOn); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		_, err = tx.db().Exec("INSERT INTO legacy_imports (owner_sub, legacy_id, note_id, imported_on) VALUES (?, ?, ?, ?)",
+			owner, legacyID, entry.ID, formatTime(now))
+		if err != nil {
+			return err
+		}
+		imported = &LegacyImport{LegacyID: legacyID, NoteID: &entry.ID, ImportedOn: now.Truncate(time.Second)}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return imported, created, nil
+}
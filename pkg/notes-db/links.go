@@ -0,0 +1,253 @@
+package notesdb
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+var noteLinkPattern = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+
+// noteLinkRef is a link as written in a note's content: [[#123]] links to a
+// note by ID, anything else between double brackets to a note by title.
+type noteLinkRef struct {
+	text        string
+	targetID    *int64
+	targetTitle string
+}
+
+// parseNoteLinks returns the distinct links in the content, in the order they
+// first appear.
+func parseNoteLinks(content []byte) []*noteLinkRef {
+	refs := []*noteLinkRef{}
+	seen := map[string]bool{}
+	for _, match := range noteLinkPattern.FindAllSubmatch(content, -1) {
+		text := strings.TrimSpace(string(match[1]))
+		if text == "" || seen[foldASCII(text)] {
+			continue
+		}
+		seen[foldASCII(text)] = true
+
+		ref := &noteLinkRef{text: text, targetTitle: text}
+		if idStr, ok := strings.CutPrefix(text, "#"); ok {
+			if id, err := strconv.ParseInt(idStr, 10, 64); err == nil {
+				ref.targetID, ref.targetTitle = &id, ""
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// SetNoteLinks replaces the links recorded for the note with the ones in the
+// given content.
+func SetNoteLinks(db DBTX, id int64, content []byte) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM note_links WHERE source_id = ?", id); err != nil {
+		return err
+	}
+	for _, ref := range parseNoteLinks(content) {
+		var targetTitle sql.NullString
+		if ref.targetID == nil {
+			targetTitle = sql.NullString{String: ref.targetTitle, Valid: true}
+		}
+		_, err := tx.Exec("INSERT INTO note_links (source_id, text, target_id, target_title) VALUES (?, ?, ?, ?)",
+			id, ref.text, ref.targetID, targetTitle)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetNoteLinks returns the links in the note's content. Links that don't
+// resolve to one of the owner's live notes have no target.
+func GetNoteLinks(db DBTX, owner string, id int64) ([]*notes.NoteLink, error) {
+	return queryNoteLinks(db, "links.source_id = ?", owner, id)
+}
+
+// GetBacklinks returns the links in the owner's live notes that resolve to
+// the note.
+func GetBacklinks(db DBTX, owner string, id int64) ([]*notes.NoteLink, error) {
+	return queryNoteLinks(db, "links.target_id = ?", owner, id)
+}
+
+// GetNoteGraph returns all of the owner's live notes along with the links
+// between them.
+func GetNoteGraph(db DBTX, owner string) (*notes.NoteGraph, error) {
+	graph := &notes.NoteGraph{Nodes: []*notes.NoteGraphNode{}, Edges: []*notes.NoteGraphEdge{}}
+
+	rows, err := db.Query("SELECT id, title FROM notes WHERE owner_sub = ? AND deleted_on IS NULL ORDER BY id", owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node := &notes.NoteGraphNode{}
+		if err := rows.Scan(&node.ID, &node.Title); err != nil {
+			return nil, err
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	edgeRows, err := db.Query(`
+        WITH `+noteLinksCTE+`
+        SELECT DISTINCT source_id, target_id
+        FROM links
+        WHERE target_id IS NOT NULL
+        ORDER BY source_id, target_id`,
+		owner)
+	if err != nil {
+		return nil, err
+	}
+	defer edgeRows.Close()
+	for edgeRows.Next() {
+		edge := &notes.NoteGraphEdge{}
+		if err := edgeRows.Scan(&edge.Source, &edge.Target); err != nil {
+			return nil, err
+		}
+		graph.Edges = append(graph.Edges, edge)
+	}
+	if err := edgeRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return graph, nil
+}
+
+// ReindexNoteLinks records the links of every note, regardless of owner, from
+// its current content as decoded by decode (see GetNotesWithPreview). It
+// returns the number of notes indexed.
+func ReindexNoteLinks(db *sql.DB, files *ContentFileStore, decode func([]byte) ([]byte, error)) (int, error) {
+	rows, err := db.Query(`
+        SELECT notes.id, notes.content_type_id, notes_content.content
+        FROM notes
+            LEFT JOIN notes_content ON notes.id = notes_content.note_id
+        ORDER BY notes.id`)
+	if err != nil {
+		return 0, err
+	}
+	contents := map[int64][]byte{}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var contentType int
+		var content []byte
+		if err := rows.Scan(&id, &contentType, &content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if contentType == CONTENT_FILE {
+			if content, err = files.Read(id); err != nil {
+				rows.Close()
+				return 0, err
+			}
+		}
+		if decode != nil {
+			if content, err = decode(content); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to decode content of note %d: %w", id, err)
+			}
+		}
+		contents[id] = content
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	err = RunInTx(db, func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if err := SetNoteLinks(tx, id, contents[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Private
+
+// noteLinksCTE resolves every link in the live notes of an owner (the only
+// parameter). ID links resolve to the note with that ID & title links to the
+// oldest note with that title, ignoring case, as long as it is live & has the
+// same owner.
+const noteLinksCTE = `links AS (
+            SELECT
+                note_links.rowid AS link_id,
+                source.id AS source_id,
+                source.title AS source_title,
+                note_links.text,
+                CASE WHEN note_links.target_id IS NOT NULL THEN (
+                    SELECT id FROM notes AS target
+                    WHERE target.id = note_links.target_id
+                        AND target.owner_sub = source.owner_sub AND target.deleted_on IS NULL
+                ) ELSE (
+                    SELECT id FROM notes AS target
+                    WHERE target.title = note_links.target_title COLLATE NOCASE
+                        AND target.owner_sub = source.owner_sub AND target.deleted_on IS NULL
+                    ORDER BY target.id LIMIT 1
+                ) END AS target_id
+            FROM note_links
+                JOIN notes AS source ON source.id = note_links.source_id
+            WHERE source.owner_sub = ? AND source.deleted_on IS NULL
+        )`
+
+func queryNoteLinks(db DBTX, where string, owner string, id int64) ([]*notes.NoteLink, error) {
+	stmt, err := db.Prepare(`
+        WITH ` + noteLinksCTE + `
+        SELECT links.source_id, links.source_title, links.text, links.target_id, target.title
+        FROM links
+            LEFT JOIN notes AS target ON target.id = links.target_id
+        WHERE ` + where + `
+        ORDER BY links.source_id, links.link_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*notes.NoteLink{}
+	for rows.Next() {
+		link := &notes.NoteLink{}
+		var targetID sql.NullInt64
+		var targetTitle sql.NullString
+		if err := rows.Scan(&link.SourceID, &link.SourceTitle, &link.Text, &targetID, &targetTitle); err != nil {
+			return nil, err
+		}
+		if targetID.Valid {
+			link.TargetID = &targetID.Int64
+			link.TargetTitle = &targetTitle.String
+		}
+		links = append(links, link)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
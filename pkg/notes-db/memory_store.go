@@ -0,0 +1,1522 @@
+package notesdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+// MemoryStore is a Store that keeps everything in memory. It is safe for
+// concurrent use & behaves like SQLiteStore, except that nothing survives a
+// restart and search is a simple case-insensitive term match.
+type MemoryStore struct {
+	mu                 countingRWMutex
+	notes              map[int64]*memoryNote
+	notebooks          map[int64]*memoryNotebook
+	templates          map[int64]*memoryTemplate
+	notifications      map[int64]*memoryNotification
+	shares             map[int64]*memoryShare
+	tags               map[string]map[string]string // owner -> lower-cased name -> name
+	nextNoteID         int64
+	nextNotebookID     int64
+	nextTemplateID     int64
+	nextAttachmentID   int64
+	nextNotificationID int64
+	nextShareID        int64
+}
+
+type memoryNote struct {
+	owner     string
+	note      notes.Note
+	tags      []string // lower-cased keys into MemoryStore.tags
+	content   []byte
+	deletedOn *time.Time
+	revisions []*memoryRevision
+	// Like revisions, attachments & links are never modified in place.
+	attachments []*memoryAttachment
+	links       []*noteLinkRef
+	// Property values are never modified in place, only replaced.
+	properties map[string]*PropertyValue
+}
+
+type memoryAttachment struct {
+	attachment notes.Attachment
+	data       []byte
+}
+
+type memoryRevision struct {
+	number    int64
+	title     string
+	content   []byte
+	createdOn time.Time
+}
+
+type memoryNotebook struct {
+	owner    string
+	notebook notes.Notebook
+}
+
+type memoryTemplate struct {
+	owner    string
+	template notes.Template
+}
+
+type memoryNotification struct {
+	owner        string
+	notification notes.Notification
+}
+
+type memoryShare struct {
+	owner        string
+	tokenHash    string
+	passwordHash string
+	share        notes.Share
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		notes:              map[int64]*memoryNote{},
+		notebooks:          map[int64]*memoryNotebook{},
+		templates:          map[int64]*memoryTemplate{},
+		notifications:      map[int64]*memoryNotification{},
+		shares:             map[int64]*memoryShare{},
+		tags:               map[string]map[string]string{},
+		nextNoteID:         1,
+		nextNotebookID:     1,
+		nextTemplateID:     1,
+		nextAttachmentID:   1,
+		nextNotificationID: 1,
+		nextShareID:        1,
+	}
+}
+
+// maxAtomicAttempts is how many times Atomically runs its unit of work before
+// giving up on the store being left alone long enough.
+const maxAtomicAttempts = 10
+
+var errConcurrentModification = errors.New("store was modified while the unit of work ran")
+
+// Atomically runs fn against a copy of the store, which replaces the store's
+// contents only if fn succeeds & the store wasn't written to in the meantime.
+// Otherwise fn is run again on a fresh copy. The store isn't locked while fn
+// runs, so it can still be used from within fn, but writing to it there means
+// fn can never succeed.
+func (s *MemoryStore) Atomically(fn func(tx Store) error) error {
+	for i := 0; i < maxAtomicAttempts; i++ {
+		s.mu.RLock()
+		writes := s.mu.writes
+		tx := s.clone()
+		s.mu.RUnlock()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		if s.swap(tx, writes) {
+			return nil
+		}
+	}
+	return errConcurrentModification
+}
+
+func (s *MemoryStore) NewNote(owner string, title string) (*IndexEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memoryNow()
+	n := &memoryNote{
+		owner: owner,
+		note:  notes.Note{ID: s.nextNoteID, Title: title, CreatedOn: now, UpdatedOn: now, Version: 1},
+	}
+	s.notes[n.note.ID] = n
+	s.nextNoteID++
+	return s.entry(n), nil
+}
+
+func (s *MemoryStore) GetNote(owner string, id int64) (*IndexEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return nil, nil
+	}
+	return s.entry(n), nil
+}
+
+func (s *MemoryStore) GetNotes(owner string, filter *NoteFilter, page *NotePage) ([]*IndexEntry, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filtered, nextCursor, err := s.pageNotes(s.filterNotes(owner, filter), page)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := []*IndexEntry{}
+	for _, n := range filtered {
+		entries = append(entries, s.entry(n))
+	}
+	return entries, nextCursor, nil
+}
+
+func (s *MemoryStore) GetNotesWithPreview(owner string, filter *NoteFilter, page *NotePage, previewLength int) ([]*IndexEntryWithPreview, string, error) {
+	if previewLength <= 0 || previewLength >= 100000 {
+		return nil, "", fmt.Errorf("preview length must be greater than 0 and less than 100KB: %d", previewLength)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filtered, nextCursor, err := s.pageNotes(s.filterNotes(owner, filter), page)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := []*IndexEntryWithPreview{}
+	for _, n := range filtered {
+		entries = append(entries, &IndexEntryWithPreview{IndexEntry: s.entry(n), ContentPreview: contentPreview(n.content, previewLength)})
+	}
+	return entries, nextCursor, nil
+}
+
+func (s *MemoryStore) UpdateNote(owner string, id int64, title string, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return nil
+	}
+	if expectedVersion != 0 && n.note.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	n.note.Title = title
+	n.touch()
+	return nil
+}
+
+func (s *MemoryStore) TouchNote(owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.liveNote(owner, id); n != nil {
+		n.touch()
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetNoteTimes(owner string, id int64, createdOn time.Time, updatedOn time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.liveNote(owner, id); n != nil {
+		n.note.CreatedOn = createdOn.UTC().Truncate(time.Second)
+		n.note.UpdatedOn = updatedOn.UTC().Truncate(time.Second)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetNoteState(owner string, id int64, state NoteState, on bool) error {
+	if err := validateNoteState(state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return nil
+	}
+	switch state {
+	case NoteStatePinned:
+		n.note.Pinned = on
+	case NoteStateFavorite:
+		n.note.Favorite = on
+	case NoteStateArchived:
+		n.note.Archived = on
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetNoteContents(owner string, id int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil || n.content == nil {
+		return nil, nil
+	}
+	return append([]byte{}, n.content...), nil
+}
+
+func (s *MemoryStore) SetNoteContents(owner string, id int64, content []byte, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return ErrNoteNotFound
+	}
+	if expectedVersion != 0 && n.note.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	n.content = append([]byte{}, content...)
+	n.links = parseNoteLinks(content)
+	n.touch()
+	return nil
+}
+
+func (s *MemoryStore) DeleteNote(owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.liveNote(owner, id); n != nil {
+		now := memoryNow()
+		n.deletedOn = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) SearchNotes(owner string, query string, limit int) ([]*SearchResult, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be greater than 0: %d", limit)
+	}
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []*SearchResult{}
+	for _, n := range s.filterNotes(owner, nil) {
+		title, content := strings.ToLower(n.note.Title), strings.ToLower(string(n.content))
+		var titleHits, contentHits int
+		for _, term := range terms {
+			t, c := strings.Count(title, term), strings.Count(content, term)
+			if t+c == 0 {
+				titleHits, contentHits = 0, 0
+				break
+			}
+			titleHits += t
+			contentHits += c
+		}
+		if titleHits+contentHits == 0 {
+			continue
+		}
+		results = append(results, &SearchResult{
+			IndexEntry:     s.entry(n),
+			TitleHighlight: highlightTerms(n.note.Title, terms),
+			Snippet:        snippetTerms(string(n.content), terms),
+			// Like bm25, lower is better.
+			Rank: -(searchTitleWeight*float64(titleHits) + searchContentWeight*float64(contentHits)),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank < results[j].Rank })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) RecordRevision(owner string, id int64, maxRevisions int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner {
+		return nil
+	}
+
+	number := int64(1)
+	if len(n.revisions) > 0 {
+		number = n.revisions[len(n.revisions)-1].number + 1
+	}
+	n.revisions = append(n.revisions, &memoryRevision{
+		number:    number,
+		title:     n.note.Title,
+		content:   append([]byte{}, n.content...),
+		createdOn: memoryNow(),
+	})
+	if maxRevisions > 0 && len(n.revisions) > maxRevisions {
+		n.revisions = n.revisions[len(n.revisions)-maxRevisions:]
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetRevisions(owner string, id int64) ([]*notes.Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revisions := []*notes.Revision{}
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner {
+		return revisions, nil
+	}
+	for i := len(n.revisions) - 1; i >= 0; i-- {
+		r := n.revisions[i]
+		revisions = append(revisions, &notes.Revision{NoteID: id, Number: r.number, Title: r.title, CreatedOn: r.createdOn})
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStore) GetRevision(owner string, id int64, rev int64) (*notes.RevisionWithContent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner {
+		return nil, nil
+	}
+	for _, r := range n.revisions {
+		if r.number == rev {
+			return &notes.RevisionWithContent{
+				Revision: &notes.Revision{NoteID: id, Number: r.number, Title: r.title, CreatedOn: r.createdOn},
+				Content:  string(r.content),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) GetTrashedNotes(owner string) ([]*TrashEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []*TrashEntry{}
+	for _, n := range s.sortedNotes() {
+		if n.owner == owner && n.deletedOn != nil {
+			entries = append(entries, &TrashEntry{IndexEntry: s.entry(n), DeletedOn: *n.deletedOn})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DeletedOn.After(entries[j].DeletedOn) })
+	return entries, nil
+}
+
+func (s *MemoryStore) RestoreNote(owner string, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner || n.deletedOn == nil {
+		return false, nil
+	}
+	n.deletedOn = nil
+	return true, nil
+}
+
+func (s *MemoryStore) PurgeNote(owner string, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner || n.deletedOn == nil {
+		return false, nil
+	}
+	s.purgeNote(id)
+	return true, nil
+}
+
+func (s *MemoryStore) PurgeTrash(deletedBefore time.Time) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := []int64{}
+	for _, n := range s.sortedNotes() {
+		if n.deletedOn != nil && !n.deletedOn.After(deletedBefore) {
+			s.purgeNote(n.note.ID)
+			purged = append(purged, n.note.ID)
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStore) AddTags(owner string, id int64, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner {
+		return nil
+	}
+	ownerTags, ok := s.tags[owner]
+	if !ok {
+		ownerTags = map[string]string{}
+		s.tags[owner] = ownerTags
+	}
+	for _, t := range tags {
+		key := strings.ToLower(t)
+		if _, ok := ownerTags[key]; !ok {
+			ownerTags[key] = t
+		}
+		if !containsString(n.tags, key) {
+			n.tags = append(n.tags, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RemoveTag(owner string, id int64, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(tag)
+	if n, ok := s.notes[id]; ok && n.owner == owner {
+		for i, t := range n.tags {
+			if t == key {
+				n.tags = append(n.tags[:i:i], n.tags[i+1:]...)
+				break
+			}
+		}
+	}
+
+	// As with the DB, tags that are no longer attached to any note are deleted.
+	used := map[string]bool{}
+	for _, n := range s.notes {
+		if n.owner == owner {
+			for _, t := range n.tags {
+				used[t] = true
+			}
+		}
+	}
+	for t := range s.tags[owner] {
+		if !used[t] {
+			delete(s.tags[owner], t)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetTags(owner string) ([]*notes.Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int64{}
+	for _, n := range s.notes {
+		if n.owner == owner && n.deletedOn == nil {
+			for _, t := range n.tags {
+				counts[t]++
+			}
+		}
+	}
+
+	tags := []*notes.Tag{}
+	for key, name := range s.tags[owner] {
+		tags = append(tags, &notes.Tag{Name: name, Count: counts[key]})
+	}
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name) })
+	return tags, nil
+}
+
+func (s *MemoryStore) SetProperties(owner string, id int64, patch map[string]*PropertyValue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return nil
+	}
+	if n.properties == nil {
+		n.properties = map[string]*PropertyValue{}
+	}
+	for name, value := range patch {
+		if value == nil {
+			delete(n.properties, name)
+		} else {
+			n.properties[name] = value
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) NewNotebook(owner string, name string, parentID *int64) (*notes.Notebook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNotebookExists(owner, parentID); err != nil {
+		return nil, err
+	}
+
+	now := memoryNow()
+	nb := &memoryNotebook{
+		owner:    owner,
+		notebook: notes.Notebook{ID: s.nextNotebookID, ParentID: copyID(parentID), Name: name, CreatedOn: now, UpdatedOn: now},
+	}
+	s.notebooks[nb.notebook.ID] = nb
+	s.nextNotebookID++
+	return copyNotebook(&nb.notebook), nil
+}
+
+func (s *MemoryStore) GetNotebooks(owner string) ([]*notes.Notebook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notebooks := []*notes.Notebook{}
+	for _, nb := range s.notebooks {
+		if nb.owner == owner {
+			notebooks = append(notebooks, copyNotebook(&nb.notebook))
+		}
+	}
+	sort.Slice(notebooks, func(i, j int) bool {
+		if notebooks[i].Name != notebooks[j].Name {
+			return notebooks[i].Name < notebooks[j].Name
+		}
+		return notebooks[i].ID < notebooks[j].ID
+	})
+	return notebooks, nil
+}
+
+func (s *MemoryStore) GetNotebook(owner string, id int64) (*notes.Notebook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nb, ok := s.notebooks[id]
+	if !ok || nb.owner != owner {
+		return nil, nil
+	}
+	return copyNotebook(&nb.notebook), nil
+}
+
+func (s *MemoryStore) UpdateNotebook(owner string, id int64, name string, parentID *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNotebookExists(owner, parentID); err != nil {
+		return err
+	}
+	if parentID != nil && s.notebookSubtree(id)[*parentID] {
+		return ErrNotebookCycle
+	}
+
+	if nb, ok := s.notebooks[id]; ok && nb.owner == owner {
+		nb.notebook.Name = name
+		nb.notebook.ParentID = copyID(parentID)
+		nb.notebook.UpdatedOn = memoryNow()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteNotebook(owner string, id int64, policy NotebookDeletePolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nb, ok := s.notebooks[id]
+	if !ok || nb.owner != owner {
+		return ErrNotebookNotFound
+	}
+
+	switch policy {
+	case NotebookDeleteReject:
+		for _, child := range s.notebooks {
+			if child.notebook.ParentID != nil && *child.notebook.ParentID == id {
+				return ErrNotebookNotEmpty
+			}
+		}
+		for _, n := range s.notes {
+			if n.deletedOn == nil && n.note.NotebookID != nil && *n.note.NotebookID == id {
+				return ErrNotebookNotEmpty
+			}
+		}
+	case NotebookDeleteTrash:
+		subtree := s.notebookSubtree(id)
+		now := memoryNow()
+		for _, n := range s.notes {
+			if n.deletedOn == nil && n.note.NotebookID != nil && subtree[*n.note.NotebookID] {
+				n.deletedOn = &now
+			}
+		}
+	case NotebookDeleteReparent:
+		for _, child := range s.notebooks {
+			if child.notebook.ParentID != nil && *child.notebook.ParentID == id {
+				child.notebook.ParentID = copyID(nb.notebook.ParentID)
+			}
+		}
+		for _, n := range s.notes {
+			if n.note.NotebookID != nil && *n.note.NotebookID == id {
+				n.note.NotebookID = copyID(nb.notebook.ParentID)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid notebook delete policy: %s", policy)
+	}
+
+	// Descendant notebooks go with it; any notes left in them, i.e. those
+	// already in the trash, are moved to the top level.
+	subtree := s.notebookSubtree(id)
+	for nbID := range subtree {
+		delete(s.notebooks, nbID)
+	}
+	for _, n := range s.notes {
+		if n.note.NotebookID != nil && subtree[*n.note.NotebookID] {
+			n.note.NotebookID = nil
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) MoveNote(owner string, id int64, notebookID *int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNotebookExists(owner, notebookID); err != nil {
+		return err
+	}
+	if n := s.liveNote(owner, id); n != nil {
+		n.note.NotebookID = copyID(notebookID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) NewTemplate(owner string, name string, title string, content string) (*notes.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memoryNow()
+	t := &memoryTemplate{
+		owner:    owner,
+		template: notes.Template{ID: s.nextTemplateID, Name: name, Title: title, Content: content, CreatedOn: now, UpdatedOn: now},
+	}
+	s.templates[t.template.ID] = t
+	s.nextTemplateID++
+	c := t.template
+	return &c, nil
+}
+
+func (s *MemoryStore) GetTemplates(owner string) ([]*notes.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates := []*notes.Template{}
+	for _, t := range s.templates {
+		if t.owner == owner {
+			c := t.template
+			templates = append(templates, &c)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+
+func (s *MemoryStore) GetTemplate(owner string, id int64) (*notes.Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.templates[id]
+	if !ok || t.owner != owner {
+		return nil, nil
+	}
+	c := t.template
+	return &c, nil
+}
+
+func (s *MemoryStore) UpdateTemplate(owner string, id int64, name string, title string, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.templates[id]; ok && t.owner == owner {
+		t.template.Name = name
+		t.template.Title = title
+		t.template.Content = content
+		t.template.UpdatedOn = memoryNow()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteTemplate(owner string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.templates[id]; ok && t.owner == owner {
+		delete(s.templates, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetReminder(owner string, id int64, dueOn *time.Time, remindAt *time.Time, recurrence Recurrence) error {
+	if err := ValidateReminder(remindAt, recurrence); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, id)
+	if n == nil {
+		return nil
+	}
+	n.note.DueOn = copyTime(dueOn)
+	n.note.RemindAt = copyTime(remindAt)
+	n.note.Recurrence = string(recurrence)
+	n.note.NextReminderAt = nil
+	if remindAt != nil {
+		first := FirstReminderAt(*n.note.RemindAt, recurrence, time.Now().UTC())
+		n.note.NextReminderAt = &first
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetUpcomingReminders(owner string, before time.Time, limit int) ([]*notes.Reminder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reminders := []*notes.Reminder{}
+	for _, r := range s.reminders(func(n *memoryNote) bool { return n.owner == owner && n.note.NextReminderAt.Before(before) }) {
+		reminders = append(reminders, r.Reminder)
+	}
+	if len(reminders) > limit {
+		reminders = reminders[:limit]
+	}
+	return reminders, nil
+}
+
+func (s *MemoryStore) GetDueReminders(now time.Time) ([]*DueReminder, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.reminders(func(n *memoryNote) bool { return !n.note.NextReminderAt.After(now) }), nil
+}
+
+func (s *MemoryStore) GetNextReminderTime() (*time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reminders := s.reminders(func(*memoryNote) bool { return true })
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	next := reminders[0].At
+	return &next, nil
+}
+
+func (s *MemoryStore) MarkReminderFired(r *DueReminder, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notes[r.NoteID]
+	if !ok || n.owner != r.Owner || n.note.NextReminderAt == nil || !n.note.NextReminderAt.Equal(r.At) || !n.note.RemindAt.Equal(r.RemindAt) {
+		return nil
+	}
+	n.note.NextReminderAt = NextReminderAt(r.RemindAt, Recurrence(r.Recurrence), now.UTC().Truncate(time.Second))
+	return nil
+}
+
+func (s *MemoryStore) AddNotification(owner string, noteID int64, title string, message string) (*notes.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := &memoryNotification{
+		owner:        owner,
+		notification: notes.Notification{ID: s.nextNotificationID, NoteID: noteID, Title: title, Message: message, CreatedOn: memoryNow()},
+	}
+	s.notifications[n.notification.ID] = n
+	s.nextNotificationID++
+	c := n.notification
+	return &c, nil
+}
+
+func (s *MemoryStore) GetNotifications(owner string, unreadOnly bool, limit int) ([]*notes.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	notifications := []*notes.Notification{}
+	for _, n := range s.notifications {
+		if n.owner == owner && (!unreadOnly || n.notification.ReadOn == nil) {
+			c := n.notification
+			c.ReadOn = copyTime(n.notification.ReadOn)
+			notifications = append(notifications, &c)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		if !notifications[i].CreatedOn.Equal(notifications[j].CreatedOn) {
+			return notifications[i].CreatedOn.After(notifications[j].CreatedOn)
+		}
+		return notifications[i].ID > notifications[j].ID
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+func (s *MemoryStore) MarkNotificationRead(owner string, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.notifications[id]
+	if !ok || n.owner != owner {
+		return false, nil
+	}
+	if n.notification.ReadOn == nil {
+		now := memoryNow()
+		n.notification.ReadOn = &now
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) NewShare(owner string, noteID int64, mode string, password string, expiresOn *time.Time) (*notes.Share, error) {
+	if err := ValidateShare(mode, password, expiresOn, time.Now()); err != nil {
+		return nil, err
+	}
+	token, tokenHash, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := hashSharePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.liveNote(owner, noteID) == nil {
+		return nil, nil
+	}
+	sh := &memoryShare{
+		owner:        owner,
+		tokenHash:    tokenHash,
+		passwordHash: passwordHash,
+		share: notes.Share{
+			ID:          s.nextShareID,
+			NoteID:      noteID,
+			Mode:        mode,
+			HasPassword: passwordHash != "",
+			ExpiresOn:   copyTime(expiresOn),
+			CreatedOn:   memoryNow(),
+		},
+	}
+	s.shares[sh.share.ID] = sh
+	s.nextShareID++
+	c := sh.share
+	c.Token = token
+	return &c, nil
+}
+
+func (s *MemoryStore) GetShares(owner string, noteID *int64) ([]*notes.Share, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shares := []*notes.Share{}
+	for _, sh := range s.shares {
+		if sh.owner == owner && (noteID == nil || sh.share.NoteID == *noteID) {
+			c := sh.share
+			shares = append(shares, &c)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if !shares[i].CreatedOn.Equal(shares[j].CreatedOn) {
+			return shares[i].CreatedOn.After(shares[j].CreatedOn)
+		}
+		return shares[i].ID > shares[j].ID
+	})
+	return shares, nil
+}
+
+func (s *MemoryStore) GetShareByToken(token string) (*ResolvedShare, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokenHash := hashShareToken(token)
+	for _, sh := range s.shares {
+		if sh.tokenHash == tokenHash {
+			c := sh.share
+			return &ResolvedShare{Share: &c, Owner: sh.owner, passwordHash: sh.passwordHash}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) RevokeShare(owner string, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, ok := s.shares[id]
+	if !ok || sh.owner != owner {
+		return false, nil
+	}
+	if sh.share.RevokedOn == nil {
+		now := memoryNow()
+		sh.share.RevokedOn = &now
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) RecordShareAccess(id int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sh, ok := s.shares[id]; ok {
+		sh.share.AccessCount++
+		sh.share.LastAccessedOn = copyTime(&now)
+	}
+	return nil
+}
+
+func (s *MemoryStore) AddAttachment(owner string, noteID int64, name string, mimeType string, data io.Reader) (*notes.Attachment, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, noteID)
+	if n == nil {
+		return nil, nil
+	}
+	a := &memoryAttachment{
+		attachment: notes.Attachment{
+			ID:        s.nextAttachmentID,
+			NoteID:    noteID,
+			Name:      name,
+			MimeType:  mimeType,
+			Size:      int64(len(content)),
+			SHA256:    hex.EncodeToString(sum[:]),
+			CreatedOn: memoryNow(),
+		},
+		data: content,
+	}
+	n.attachments = append(n.attachments, a)
+	s.nextAttachmentID++
+	attachment := a.attachment
+	return &attachment, nil
+}
+
+func (s *MemoryStore) GetAttachments(owner string, noteID int64) ([]*notes.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachments := []*notes.Attachment{}
+	if n := s.liveNote(owner, noteID); n != nil {
+		for _, a := range n.attachments {
+			attachment := a.attachment
+			attachments = append(attachments, &attachment)
+		}
+	}
+	return attachments, nil
+}
+
+func (s *MemoryStore) GetAttachment(owner string, noteID int64, id int64) (*notes.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if a := s.findAttachment(owner, noteID, id); a != nil {
+		attachment := a.attachment
+		return &attachment, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) OpenAttachment(owner string, noteID int64, id int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if a := s.findAttachment(owner, noteID, id); a != nil {
+		return io.NopCloser(bytes.NewReader(a.data)), nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) DeleteAttachment(owner string, noteID int64, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.liveNote(owner, noteID)
+	if n == nil {
+		return false, nil
+	}
+	for i, a := range n.attachments {
+		if a.attachment.ID == id {
+			n.attachments = append(append([]*memoryAttachment{}, n.attachments[:i]...), n.attachments[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStore) GetNoteLinks(owner string, id int64) ([]*notes.NoteLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	links := []*notes.NoteLink{}
+	if n := s.liveNote(owner, id); n != nil {
+		for _, ref := range n.links {
+			links = append(links, s.resolveLink(n, ref))
+		}
+	}
+	return links, nil
+}
+
+func (s *MemoryStore) GetBacklinks(owner string, id int64) ([]*notes.NoteLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	links := []*notes.NoteLink{}
+	for _, n := range s.sortedNotes() {
+		if n.owner != owner || n.deletedOn != nil {
+			continue
+		}
+		for _, ref := range n.links {
+			if link := s.resolveLink(n, ref); link.TargetID != nil && *link.TargetID == id {
+				links = append(links, link)
+			}
+		}
+	}
+	return links, nil
+}
+
+func (s *MemoryStore) GetNoteGraph(owner string) (*notes.NoteGraph, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	graph := &notes.NoteGraph{Nodes: []*notes.NoteGraphNode{}, Edges: []*notes.NoteGraphEdge{}}
+	for _, n := range s.sortedNotes() {
+		if n.owner != owner || n.deletedOn != nil {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, &notes.NoteGraphNode{ID: n.note.ID, Title: n.note.Title})
+		targets := map[int64]bool{}
+		for _, ref := range n.links {
+			if link := s.resolveLink(n, ref); link.TargetID != nil {
+				targets[*link.TargetID] = true
+			}
+		}
+		for target := range targets {
+			graph.Edges = append(graph.Edges, &notes.NoteGraphEdge{Source: n.note.ID, Target: target})
+		}
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		return a.Source < b.Source || (a.Source == b.Source && a.Target < b.Target)
+	})
+	return graph, nil
+}
+
+// Private
+
+// countingRWMutex is a sync.RWMutex that counts how often it has been locked
+// for writing, i.e. how often the store may have been written to.
+type countingRWMutex struct {
+	sync.RWMutex
+	writes int64
+}
+
+func (m *countingRWMutex) Lock() {
+	m.RWMutex.Lock()
+	m.writes++
+}
+
+// memoryNow returns the current time at the precision the DB stores it.
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// swap replaces the store's contents with those of tx, unless the store has
+// been written to since it had the given number of writes.
+func (s *MemoryStore) swap(tx *MemoryStore, writes int64) bool {
+	s.mu.RWMutex.Lock()
+	defer s.mu.RWMutex.Unlock()
+	if s.mu.writes != writes {
+		return false
+	}
+
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	s.notes, s.notebooks, s.templates, s.notifications, s.shares, s.tags = tx.notes, tx.notebooks, tx.templates, tx.notifications, tx.shares, tx.tags
+	s.nextNoteID, s.nextNotebookID, s.nextTemplateID, s.nextAttachmentID = tx.nextNoteID, tx.nextNotebookID, tx.nextTemplateID, tx.nextAttachmentID
+	s.nextNotificationID, s.nextShareID = tx.nextNotificationID, tx.nextShareID
+	s.mu.writes++
+	return true
+}
+
+// clone returns a deep copy of the store. The caller must hold s.mu.
+func (s *MemoryStore) clone() *MemoryStore {
+	c := NewMemoryStore()
+	c.nextNoteID, c.nextNotebookID, c.nextTemplateID, c.nextAttachmentID = s.nextNoteID, s.nextNotebookID, s.nextTemplateID, s.nextAttachmentID
+	c.nextNotificationID, c.nextShareID = s.nextNotificationID, s.nextShareID
+	for id, n := range s.notes {
+		nc := *n
+		nc.note.NotebookID = copyID(n.note.NotebookID)
+		nc.tags = append([]string{}, n.tags...)
+		nc.properties = map[string]*PropertyValue{}
+		for name, value := range n.properties {
+			nc.properties[name] = value
+		}
+		// Content & revisions are never modified in place, only replaced.
+		nc.revisions = append([]*memoryRevision{}, n.revisions...)
+		nc.attachments = append([]*memoryAttachment{}, n.attachments...)
+		c.notes[id] = &nc
+	}
+	for id, nb := range s.notebooks {
+		nbc := *nb
+		nbc.notebook.ParentID = copyID(nb.notebook.ParentID)
+		c.notebooks[id] = &nbc
+	}
+	for id, t := range s.templates {
+		tc := *t
+		c.templates[id] = &tc
+	}
+	for id, n := range s.notifications {
+		nc := *n
+		c.notifications[id] = &nc
+	}
+	for id, sh := range s.shares {
+		shc := *sh
+		c.shares[id] = &shc
+	}
+	for owner, tags := range s.tags {
+		c.tags[owner] = map[string]string{}
+		for k, v := range tags {
+			c.tags[owner][k] = v
+		}
+	}
+	return c
+}
+
+// touch marks the note as updated, bumping its version. The caller must hold
+// the store's lock.
+func (n *memoryNote) touch() {
+	n.note.UpdatedOn = memoryNow()
+	n.note.Version++
+}
+
+// purgeNote deletes the note along with its notifications & shares. The
+// caller must hold s.mu.
+func (s *MemoryStore) purgeNote(id int64) {
+	delete(s.notes, id)
+	for notificationID, n := range s.notifications {
+		if n.notification.NoteID == id {
+			delete(s.notifications, notificationID)
+		}
+	}
+	for shareID, sh := range s.shares {
+		if sh.share.NoteID == id {
+			delete(s.shares, shareID)
+		}
+	}
+}
+
+// liveNote returns the note if it belongs to the owner & isn't in the trash.
+// The caller must hold s.mu.
+func (s *MemoryStore) liveNote(owner string, id int64) *memoryNote {
+	n, ok := s.notes[id]
+	if !ok || n.owner != owner || n.deletedOn != nil {
+		return nil
+	}
+	return n
+}
+
+// sortedNotes returns every note ordered by ID. The caller must hold s.mu.
+func (s *MemoryStore) sortedNotes() []*memoryNote {
+	sorted := make([]*memoryNote, 0, len(s.notes))
+	for _, n := range s.notes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].note.ID < sorted[j].note.ID })
+	return sorted
+}
+
+// filterNotes returns the owner's live notes matching the filter, ordered by
+// ID. The caller must hold s.mu.
+func (s *MemoryStore) filterNotes(owner string, filter *NoteFilter) []*memoryNote {
+	if filter == nil {
+		filter = &NoteFilter{}
+	}
+	var notebooks map[int64]bool
+	if filter.NotebookID != nil {
+		if filter.IncludeSubNotebooks {
+			notebooks = s.notebookSubtree(*filter.NotebookID)
+		} else {
+			notebooks = map[int64]bool{*filter.NotebookID: true}
+		}
+	}
+	wantTags := uniqueTags(filter.Tags)
+
+	filtered := []*memoryNote{}
+	for _, n := range s.sortedNotes() {
+		if n.owner != owner || n.deletedOn != nil {
+			continue
+		}
+		if notebooks != nil && (n.note.NotebookID == nil || !notebooks[*n.note.NotebookID]) {
+			continue
+		}
+		if filter.UpdatedAfter != nil && !n.note.UpdatedOn.After(filter.UpdatedAfter.Truncate(time.Second)) {
+			continue
+		}
+		if filter.CreatedBefore != nil && !n.note.CreatedOn.Before(filter.CreatedBefore.Truncate(time.Second)) {
+			continue
+		}
+		if !matchesPropertyFilters(n.properties, filter.Properties) || !matchesView(&n.note, filter.View) {
+			continue
+		}
+		if len(wantTags) > 0 {
+			matched := 0
+			for _, t := range wantTags {
+				if containsString(n.tags, strings.ToLower(t)) {
+					matched++
+				}
+			}
+			if matched == 0 || (filter.MatchAllTags && matched < len(wantTags)) {
+				continue
+			}
+		}
+		filtered = append(filtered, n)
+	}
+	return filtered
+}
+
+// pageNotes sorts the notes & cuts out the requested page, returning the
+// cursor of the next page or "" if it is the last one.
+func (s *MemoryStore) pageNotes(filtered []*memoryNote, page *NotePage) ([]*memoryNote, string, error) {
+	if err := page.validate(); err != nil {
+		return nil, "", err
+	}
+	cursor, err := page.decodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool { return compareNotes(page, &filtered[i].note, &filtered[j].note) < 0 })
+	if cursor != nil {
+		start := sort.Search(len(filtered), func(i int) bool { return afterCursor(page, cursor, &filtered[i].note) })
+		filtered = filtered[start:]
+	}
+
+	nextCursor := ""
+	if page != nil && page.Limit > 0 && len(filtered) > page.Limit {
+		filtered = filtered[:page.Limit]
+		nextCursor = page.nextCursor(&filtered[len(filtered)-1].note)
+	}
+	return filtered, nextCursor, nil
+}
+
+// reminders returns the reminders of the live notes matching the predicate,
+// soonest first. The caller must hold s.mu.
+func (s *MemoryStore) reminders(matches func(n *memoryNote) bool) []*DueReminder {
+	reminders := []*DueReminder{}
+	for _, n := range s.sortedNotes() {
+		if n.deletedOn != nil || n.note.NextReminderAt == nil || !matches(n) {
+			continue
+		}
+		reminders = append(reminders, &DueReminder{
+			Reminder: &notes.Reminder{
+				NoteID:     n.note.ID,
+				Title:      n.note.Title,
+				At:         *n.note.NextReminderAt,
+				DueOn:      copyTime(n.note.DueOn),
+				Recurrence: n.note.Recurrence,
+			},
+			Owner:    n.owner,
+			RemindAt: *n.note.RemindAt,
+		})
+	}
+	sort.SliceStable(reminders, func(i, j int) bool { return reminders[i].At.Before(reminders[j].At) })
+	return reminders
+}
+
+// notebookSubtree returns the IDs of the notebook & all of its descendants.
+// The caller must hold s.mu.
+func (s *MemoryStore) notebookSubtree(id int64) map[int64]bool {
+	subtree := map[int64]bool{id: true}
+	for grew := true; grew; {
+		grew = false
+		for _, nb := range s.notebooks {
+			if nb.notebook.ParentID != nil && subtree[*nb.notebook.ParentID] && !subtree[nb.notebook.ID] {
+				subtree[nb.notebook.ID] = true
+				grew = true
+			}
+		}
+	}
+	return subtree
+}
+
+// checkNotebookExists returns ErrNotebookNotFound unless id is nil (i.e. the
+// top level) or refers to one of the owner's notebooks. The caller must hold s.mu.
+func (s *MemoryStore) checkNotebookExists(owner string, id *int64) error {
+	if id == nil {
+		return nil
+	}
+	if nb, ok := s.notebooks[*id]; !ok || nb.owner != owner {
+		return ErrNotebookNotFound
+	}
+	return nil
+}
+
+// findAttachment returns the attachment if it belongs to one of the owner's
+// live notes. The caller must hold s.mu.
+func (s *MemoryStore) findAttachment(owner string, noteID int64, id int64) *memoryAttachment {
+	n := s.liveNote(owner, noteID)
+	if n == nil {
+		return nil
+	}
+	for _, a := range n.attachments {
+		if a.attachment.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// resolveLink resolves a link in the content of the note the way the
+// note_links queries do. The caller must hold s.mu.
+func (s *MemoryStore) resolveLink(source *memoryNote, ref *noteLinkRef) *notes.NoteLink {
+	link := &notes.NoteLink{SourceID: source.note.ID, SourceTitle: source.note.Title, Text: ref.text}
+	var target *memoryNote
+	if ref.targetID != nil {
+		target = s.liveNote(source.owner, *ref.targetID)
+	} else {
+		for _, n := range s.sortedNotes() {
+			if n.owner == source.owner && n.deletedOn == nil && foldASCII(n.note.Title) == foldASCII(ref.targetTitle) {
+				target = n
+				break
+			}
+		}
+	}
+	if target != nil {
+		id, title := target.note.ID, target.note.Title
+		link.TargetID, link.TargetTitle = &id, &title
+	}
+	return link
+}
+
+// entry returns a copy of the note that is safe to hand out. The caller must
+// hold s.mu.
+func (s *MemoryStore) entry(n *memoryNote) *IndexEntry {
+	note := n.note
+	note.NotebookID = copyID(n.note.NotebookID)
+	note.Tags = []string{}
+	for _, t := range n.tags {
+		note.Tags = append(note.Tags, s.tags[n.owner][t])
+	}
+	sort.Slice(note.Tags, func(i, j int) bool { return strings.ToLower(note.Tags[i]) < strings.ToLower(note.Tags[j]) })
+	note.Properties = map[string]any{}
+	for name, value := range n.properties {
+		note.Properties[name] = value.Value
+	}
+	return &IndexEntry{Note: &note, ContentType: CONTENT_SQL}
+}
+
+func copyNotebook(nb *notes.Notebook) *notes.Notebook {
+	c := *nb
+	c.ParentID = copyID(nb.ParentID)
+	return &c
+}
+
+// copyTime returns a copy of the time in UTC, truncated to the second like
+// times stored in SQLite.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := t.UTC().Truncate(time.Second)
+	return &c
+}
+
+func copyID(id *int64) *int64 {
+	if id == nil {
+		return nil
+	}
+	c := *id
+	return &c
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightTerms wraps every case-insensitive occurrence of the terms in s
+// with the search highlight markers.
+func highlightTerms(s string, terms []string) string {
+	lower := strings.ToLower(s)
+	if len(lower) != len(s) {
+		// Lower-casing changed the byte offsets, so matches can't be mapped back.
+		return s
+	}
+	marked := make([]bool, len(s))
+	for _, term := range terms {
+		for i := 0; ; {
+			j := strings.Index(lower[i:], term)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(term); k++ {
+				marked[k] = true
+			}
+			i += j + len(term)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(SearchHighlightStart)
+		}
+		b.WriteByte(s[i])
+		if marked[i] && (i == len(s)-1 || !marked[i+1]) {
+			b.WriteString(SearchHighlightEnd)
+		}
+	}
+	return b.String()
+}
+
+// snippetTerms returns a window of words around the first word of s that
+// contains one of the terms, highlighted like highlightTerms.
+func snippetTerms(s string, terms []string) string {
+	words := strings.Fields(s)
+	first := 0
+	for i, w := range words {
+		lower := strings.ToLower(w)
+		found := false
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				found = true
+				break
+			}
+		}
+		if found {
+			first = i
+			break
+		}
+	}
+
+	start := first - searchSnippetTokens/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + searchSnippetTokens
+	if end > len(words) {
+		end = len(words)
+	}
+
+	snippet := highlightTerms(strings.Join(words[start:end], " "), terms)
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(words) {
+		snippet += "..."
+	}
+	return snippet
+}
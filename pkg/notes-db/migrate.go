@@ -0,0 +1,262 @@
+package notesdb
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//go:embed files/migrations/*.sql
+	migrationFiles embed.FS
+
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+	// ErrFTS5Unavailable is returned by MigrateUp if go-sqlite3 was built
+	// without the FTS5 module, which note search needs.
+	ErrFTS5Unavailable = errors.New("SQLite was built without FTS5 (build with -tags sqlite_fts5)")
+)
+
+const (
+	migrationsDir = "files/migrations"
+)
+
+// Migration is a single numbered schema change. Migrations live in
+// files/migrations as pairs of NNNN_name.up.sql & NNNN_name.down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	*Migration
+	Applied   bool
+	AppliedOn time.Time
+}
+
+// LoadMigrations returns all embedded migrations ordered by version.
+func LoadMigrations() ([]*Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationFilePattern.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", e.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", e.Name(), err)
+		}
+		name, direction := match[2], match[3]
+
+		contents, err := fs.ReadFile(migrationFiles, path.Join(migrationsDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("conflicting names for migration %d: %s, %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// GetMigrationStatus returns every known migration along with whether & when
+// it was applied to the given database.
+func GetMigrationStatus(db *sql.DB) ([]*MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	applied, err := getAppliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*MigrationStatus{}
+	for _, m := range migrations {
+		status := &MigrationStatus{Migration: m}
+		status.AppliedOn, status.Applied = applied[m.Version]
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies all pending migrations up to & including the target
+// version in a single transaction. A target <= 0 migrates to the latest version.
+func MigrateUp(db *sql.DB, target int) ([]*Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	applied, err := getAppliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO schema_migrations (version, name, applied_on) VALUES (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	run := []*Migration{}
+	for _, m := range migrations {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if _, err := tx.Exec(m.Up); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				err = ErrFTS5Unavailable
+			}
+			return nil, fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := stmt.Exec(m.Version, m.Name, formatTime(time.Now().UTC())); err != nil {
+			return nil, err
+		}
+		run = append(run, m)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// MigrateDown reverts the given number of most recently applied migrations
+// in a single transaction.
+func MigrateDown(db *sql.DB, steps int) ([]*Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	applied, err := getAppliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare("DELETE FROM schema_migrations WHERE version = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	run := []*Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(run) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) cannot be reverted: no down script", m.Version, m.Name)
+		}
+		if _, err := tx.Exec(m.Down); err != nil {
+			return nil, fmt.Errorf("failed to revert migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if _, err := stmt.Exec(m.Version); err != nil {
+			return nil, err
+		}
+		run = append(run, m)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// Private
+
+func getAppliedMigrations(tx *sql.Tx) (map[int]time.Time, error) {
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS
+            schema_migrations
+            ( version INTEGER PRIMARY KEY
+            , name TEXT NOT NULL
+            , applied_on TEXT NOT NULL
+            )`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT version, applied_on FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedOnStr string
+		if err := rows.Scan(&version, &appliedOnStr); err != nil {
+			return nil, err
+		}
+		appliedOn, err := parseTime(appliedOnStr)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedOn
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,273 @@
+package notesdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+var (
+	ErrNotebookNotFound = errors.New("notebook not found")
+	ErrNotebookCycle    = errors.New("notebook cannot be moved into itself or one of its descendants")
+	ErrNotebookNotEmpty = errors.New("notebook is not empty")
+)
+
+type NotebookDeletePolicy string
+
+const (
+	// NotebookDeleteReject refuses to delete a notebook that contains notes or
+	// other notebooks.
+	NotebookDeleteReject NotebookDeletePolicy = "reject"
+	// NotebookDeleteTrash moves every note in the notebook & its descendants to
+	// the trash and deletes the descendants along with the notebook.
+	NotebookDeleteTrash NotebookDeletePolicy = "trash"
+	// NotebookDeleteReparent moves the notebook's notes & child notebooks up
+	// into its parent before deleting it.
+	NotebookDeleteReparent NotebookDeletePolicy = "reparent"
+)
+
+// notebookSubtreeSQL selects the ID of a notebook, bound to its single
+// parameter, along with the IDs of all of its descendants.
+const notebookSubtreeSQL = `
+                WITH RECURSIVE subtree(id) AS (
+                    SELECT ?
+                    UNION
+                    SELECT notebooks.id FROM notebooks JOIN subtree ON notebooks.parent_id = subtree.id)
+                SELECT id FROM subtree`
+
+func NewNotebook(db DBTX, owner string, name string, parentID *int64) (*notes.Notebook, error) {
+	tx, err := begin(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkNotebookExists(tx, owner, parentID); err != nil {
+		return nil, err
+	}
+
+	now := formatTime(time.Now().UTC())
+	result, err := tx.Exec("INSERT INTO notebooks (owner_sub, parent_id, name, created_on, updated_on) VALUES (?, ?, ?, ?, ?)",
+		owner, parentID, name, now, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetNotebook(db, owner, id)
+}
+
+func GetNotebooks(db DBTX, owner string) ([]*notes.Notebook, error) {
+	stmt, err := db.Prepare(`
+        SELECT id, parent_id, name, created_on, updated_on
+        FROM notebooks
+        WHERE owner_sub = ?
+        ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notebooks := []*notes.Notebook{}
+	for rows.Next() {
+		notebook, err := scanNotebook(rows)
+		if err != nil {
+			return nil, err
+		}
+		notebooks = append(notebooks, notebook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notebooks, nil
+}
+
+// GetNotebook returns the notebook with the given ID, or nil if it doesn't exist.
+func GetNotebook(db DBTX, owner string, id int64) (*notes.Notebook, error) {
+	stmt, err := db.Prepare(`
+        SELECT id, parent_id, name, created_on, updated_on
+        FROM notebooks
+        WHERE id = ? AND owner_sub = ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	notebook, err := scanNotebook(stmt.QueryRow(id, owner))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return notebook, nil
+}
+
+// UpdateNotebook renames the notebook & moves it under the given parent, or
+// to the top level if parentID is nil.
+func UpdateNotebook(db DBTX, owner string, id int64, name string, parentID *int64) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkNotebookExists(tx, owner, parentID); err != nil {
+		return err
+	}
+	if parentID != nil {
+		var inSubtree bool
+		err := tx.QueryRow("SELECT ? IN ("+notebookSubtreeSQL+")", *parentID, id).Scan(&inSubtree)
+		if err != nil {
+			return err
+		}
+		if inSubtree {
+			return ErrNotebookCycle
+		}
+	}
+
+	_, err = tx.Exec("UPDATE notebooks SET name = ?, parent_id = ?, updated_on = ? WHERE id = ? AND owner_sub = ?",
+		name, parentID, formatTime(time.Now().UTC()), id, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteNotebook deletes the notebook, handling its contents according to the
+// given policy. With NotebookDeleteReject it returns ErrNotebookNotEmpty if
+// the notebook has any contents.
+func DeleteNotebook(db DBTX, owner string, id int64, policy NotebookDeletePolicy) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentID sql.NullInt64
+	err = tx.QueryRow("SELECT parent_id FROM notebooks WHERE id = ? AND owner_sub = ?", id, owner).Scan(&parentID)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return ErrNotebookNotFound
+	} else if err != nil {
+		return err
+	}
+
+	switch policy {
+	case NotebookDeleteReject:
+		var nonEmpty bool
+		err := tx.QueryRow(`
+            SELECT
+                EXISTS (SELECT 1 FROM notebooks WHERE parent_id = ?)
+                OR EXISTS (SELECT 1 FROM notes WHERE notebook_id = ? AND deleted_on IS NULL)`,
+			id, id).Scan(&nonEmpty)
+		if err != nil {
+			return err
+		}
+		if nonEmpty {
+			return ErrNotebookNotEmpty
+		}
+	case NotebookDeleteTrash:
+		_, err := tx.Exec(`
+            UPDATE notes SET deleted_on = ?
+            WHERE deleted_on IS NULL AND notebook_id IN (`+notebookSubtreeSQL+`)`,
+			formatTime(time.Now().UTC()), id)
+		if err != nil {
+			return err
+		}
+	case NotebookDeleteReparent:
+		if _, err := tx.Exec("UPDATE notebooks SET parent_id = ? WHERE parent_id = ?", parentID, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE notes SET notebook_id = ? WHERE notebook_id = ?", parentID, id); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid notebook delete policy: %s", policy)
+	}
+
+	// Descendant notebooks go with it (ON DELETE CASCADE); any notes left in
+	// them, i.e. those already in the trash, are moved to the top level.
+	if _, err := tx.Exec("DELETE FROM notebooks WHERE id = ? AND owner_sub = ?", id, owner); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveNote moves the note into the given notebook, or to the top level if
+// notebookID is nil.
+func MoveNote(db DBTX, owner string, id int64, notebookID *int64) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkNotebookExists(tx, owner, notebookID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE notes SET notebook_id = ? WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL",
+		notebookID, id, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Private
+
+// checkNotebookExists returns ErrNotebookNotFound unless id is nil (i.e. the
+// top level) or refers to one of the owner's notebooks.
+func checkNotebookExists(tx DBTX, owner string, id *int64) error {
+	if id == nil {
+		return nil
+	}
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM notebooks WHERE id = ? AND owner_sub = ?)", *id, owner).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotebookNotFound
+	}
+	return nil
+}
+
+func scanNotebook(row rowScanner) (*notes.Notebook, error) {
+	notebook := &notes.Notebook{}
+	var parentID sql.NullInt64
+	var createdOn, updatedOn string
+	if err := row.Scan(&notebook.ID, &parentID, &notebook.Name, &createdOn, &updatedOn); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		notebook.ParentID = &parentID.Int64
+	}
+	var err error
+	if notebook.CreatedOn, err = parseTime(createdOn); err != nil {
+		return nil, err
+	}
+	if notebook.UpdatedOn, err = parseTime(updatedOn); err != nil {
+		return nil, err
+	}
+	return notebook, nil
+}
@@ -2,9 +2,12 @@ package notesdb
 
 import (
 	"database/sql"
-	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,15 +15,22 @@ import (
 	"github.com/mrshanahan/notes-api/pkg/notes"
 )
 
-var (
-	//go:embed files/create_notes_tables.sql
-	CREATE_NOTES_TABLES_SQL string
+const (
+	CONTENT_SQL  = 1
+	CONTENT_FILE = 2
 )
 
-const (
-	CONTENT_SQL = 1
+var (
+	ErrVersionConflict = errors.New("note has been modified since the expected version")
+	ErrNoteNotFound    = errors.New("note not found")
 )
 
+// ContentTypeNames maps the names used in configuration to content types.
+var ContentTypeNames = map[string]int{
+	"sql":  CONTENT_SQL,
+	"file": CONTENT_FILE,
+}
+
 type IndexEntry struct {
 	*notes.Note
 	ContentType int `json:"-"`
@@ -31,50 +41,55 @@ type IndexEntryWithPreview struct {
 	ContentPreview string `json:"content_preview"`
 }
 
+// Initialize opens the database at the given path & applies any pending
+// schema migrations.
 func Initialize(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
-	if db == nil {
-		panic("WTF???")
-	}
 
-	// https://stackoverflow.com/questions/13641250/sqlite-delete-cascade-not-working
-	_, err = db.Exec("PRAGMA foreign_keys=on;")
+	applied, err := MigrateUp(db, 0)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, err
+	for _, m := range applied {
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
 	}
 
-	_, err = db.Exec(CREATE_NOTES_TABLES_SQL)
+	return db, nil
+}
+
+// Open opens the database at the given path without applying migrations.
+func Open(path string) (*sql.DB, error) {
+	// Foreign keys are enabled via the DSN rather than a one-off PRAGMA so that
+	// every pooled connection gets them, not just the first one.
+	// https://stackoverflow.com/questions/13641250/sqlite-delete-cascade-not-working
+	// The busy timeout lets commands like rekey write while the server runs.
+	// Transactions take the write lock up front: units of work read before
+	// they write, and a deferred transaction that has read can't wait out the
+	// busy timeout for the lock, so it would fail with "database is locked".
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate", path))
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
-
 	return db, nil
 }
 
-func NewNote(db *sql.DB, title string) (*IndexEntry, error) {
-	stmt, err := db.Prepare("INSERT INTO notes (title, created_on, updated_on) VALUES (?, ?, ?)")
+func NewNote(db DBTX, owner string, title string, contentType int) (*IndexEntry, error) {
+	stmt, err := db.Prepare("INSERT INTO notes (owner_sub, title, created_on, updated_on, content_type_id) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
 	now := time.Now().UTC()
-	result, err := stmt.Exec(title, formatTime(now), formatTime(now))
+	result, err := stmt.Exec(owner, title, formatTime(now), formatTime(now), contentType)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +98,7 @@ func NewNote(db *sql.DB, title string) (*IndexEntry, error) {
 	if err != nil {
 		return nil, err
 	}
-	entry, err := GetNote(db, id)
+	entry, err := GetNote(db, owner, id)
 	if err != nil {
 		return nil, err
 	}
@@ -91,62 +106,134 @@ func NewNote(db *sql.DB, title string) (*IndexEntry, error) {
 	return entry, nil
 }
 
-func GetNotesWithPreview(db *sql.DB, previewLength int) ([]*IndexEntryWithPreview, error) {
+// NoteState is one of the flags that can be set on a note.
+type NoteState string
+
+const (
+	NoteStatePinned   NoteState = "pinned"
+	NoteStateFavorite NoteState = "favorite"
+	NoteStateArchived NoteState = "archived"
+)
+
+// NoteStates are all of the valid values of NoteState.
+var NoteStates = []NoteState{NoteStatePinned, NoteStateFavorite, NoteStateArchived}
+
+// NoteView selects notes by their states.
+type NoteView string
+
+const (
+	// NoteViewAll, like the empty view, selects every note.
+	NoteViewAll NoteView = "all"
+	// NoteViewActive selects the notes that aren't archived.
+	NoteViewActive   NoteView = "active"
+	NoteViewArchived NoteView = "archived"
+	// NoteViewFavorites selects favorite notes, whether archived or not.
+	NoteViewFavorites NoteView = "favorites"
+)
+
+// NoteViews are all of the valid values of NoteView.
+var NoteViews = []NoteView{NoteViewActive, NoteViewArchived, NoteViewFavorites, NoteViewAll}
+
+// NoteFilter narrows down the notes returned by GetNotes & GetNotesWithPreview.
+// The zero value matches every note.
+type NoteFilter struct {
+	// Tags restricts results to notes with these tags. If MatchAllTags is set
+	// a note must have every tag, otherwise any one of them is enough.
+	Tags         []string
+	MatchAllTags bool
+
+	// NotebookID restricts results to notes directly in this notebook, or in
+	// any of its descendants if IncludeSubNotebooks is set.
+	NotebookID          *int64
+	IncludeSubNotebooks bool
+
+	// UpdatedAfter & CreatedBefore, if set, restrict results to notes last
+	// updated after or created before the given times.
+	UpdatedAfter  *time.Time
+	CreatedBefore *time.Time
+
+	// Properties restricts results to notes matching every one of these.
+	Properties []*PropertyFilter
+
+	// View restricts results to the notes in it.
+	View NoteView
+}
+
+// GetNotesWithPreview returns one page of the owner's notes matching the
+// filter, along with the cursor of the next page or "" if it is the last one.
+// Previews are cut from content decoded with decode (e.g. to decrypt it), or
+// from the content as stored if decode is nil. Notes whose content isn't in
+// the DB have no preview.
+func GetNotesWithPreview(db DBTX, owner string, filter *NoteFilter, page *NotePage, previewLength int, decode func([]byte) ([]byte, error)) ([]*IndexEntryWithPreview, string, error) {
 	if previewLength <= 0 || previewLength >= 100000 {
-		return nil, fmt.Errorf("preview length must be greater than 0 and less than 100KB: %d", previewLength)
+		return nil, "", fmt.Errorf("preview length must be greater than 0 and less than 100KB: %d", previewLength)
+	}
+	filterSQL, filterArgs := buildNoteFilter(filter)
+	pageSQL, pageArgs, orderLimitSQL, err := buildNotePage(page)
+	if err != nil {
+		return nil, "", err
 	}
 	stmt, err := db.Prepare(`
         SELECT
-            id,
-            title,
-            created_on,
-            updated_on,
-            content_type_id,
-            IIF(content_type_id = 1,
-                IIF(LENGTH(content) > ?,
-                    SUBSTR(content, 0, ?) || '...',
-                    content),
-                NULL)
+            ` + noteColumns + `,
+            IIF(content_type_id = 1, content, NULL)
         FROM notes
-            LEFT JOIN notes_content on notes.id = notes_content.note_id`)
+            LEFT JOIN notes_content on notes.id = notes_content.note_id
+        WHERE owner_sub = ? AND deleted_on IS NULL` + filterSQL + pageSQL + orderLimitSQL)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(previewLength, previewLength)
+	args := append([]any{owner}, filterArgs...)
+	args = append(args, pageArgs...)
+	rows, err := stmt.Query(args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer rows.Close()
 
 	notes := []*IndexEntryWithPreview{}
 	for rows.Next() {
-		note, err := scanNoteWithPreviewRows(rows)
+		note, err := scanNoteWithPreviewRows(rows, previewLength, decode)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		notes = append(notes, note)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return notes, nil
+	nextCursor := ""
+	if page != nil && page.Limit > 0 && len(notes) > page.Limit {
+		notes = notes[:page.Limit]
+		nextCursor = page.nextCursor(notes[len(notes)-1].Note)
+	}
+	return notes, nextCursor, nil
 }
 
-func GetNotes(db *sql.DB) ([]*IndexEntry, error) {
-	stmt, err := db.Prepare("SELECT id, title, created_on, updated_on, content_type_id FROM notes")
+// GetNotes returns one page of the owner's notes matching the filter, along
+// with the cursor of the next page or "" if it is the last one.
+func GetNotes(db DBTX, owner string, filter *NoteFilter, page *NotePage) ([]*IndexEntry, string, error) {
+	filterSQL, filterArgs := buildNoteFilter(filter)
+	pageSQL, pageArgs, orderLimitSQL, err := buildNotePage(page)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	stmt, err := db.Prepare("SELECT " + noteColumns + " FROM notes WHERE owner_sub = ? AND deleted_on IS NULL" + filterSQL + pageSQL + orderLimitSQL)
+	if err != nil {
+		return nil, "", err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	args := append([]any{owner}, filterArgs...)
+	args = append(args, pageArgs...)
+	rows, err := stmt.Query(args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer rows.Close()
@@ -155,37 +242,44 @@ func GetNotes(db *sql.DB) ([]*IndexEntry, error) {
 	for rows.Next() {
 		note, err := scanNoteRows(rows)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		notes = append(notes, note)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return notes, nil
+	nextCursor := ""
+	if page != nil && page.Limit > 0 && len(notes) > page.Limit {
+		notes = notes[:page.Limit]
+		nextCursor = page.nextCursor(notes[len(notes)-1].Note)
+	}
+	return notes, nextCursor, nil
 }
 
-func DeleteNote(db *sql.DB, id int64) error {
-	stmt, err := db.Prepare("DELETE FROM notes WHERE id = ?")
+// DeleteNote moves the note to the trash. Trashed notes can be brought back
+// with RestoreNote until they are purged.
+func DeleteNote(db DBTX, owner string, id int64) error {
+	stmt, err := db.Prepare("UPDATE notes SET deleted_on = ? WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id)
+	_, err = stmt.Exec(formatTime(time.Now().UTC()), id, owner)
 	return err
 }
 
-func GetNote(db *sql.DB, id int64) (*IndexEntry, error) {
-	stmt, err := db.Prepare("SELECT id, title, created_on, updated_on, content_type_id FROM notes WHERE id = ?")
+func GetNote(db DBTX, owner string, id int64) (*IndexEntry, error) {
+	stmt, err := db.Prepare("SELECT " + noteColumns + " FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL")
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(id)
+	row := stmt.QueryRow(id, owner)
 
 	note, err := scanNoteRow(row)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
@@ -197,36 +291,49 @@ func GetNote(db *sql.DB, id int64) (*IndexEntry, error) {
 	return note, nil
 }
 
-func UpdateNote(db *sql.DB, id int64, title string) error {
-	stmt, err := db.Prepare("UPDATE notes SET title = ?, updated_on = ? WHERE id = ?")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+// UpdateNote changes the title of the note. If expectedVersion is not 0 the
+// note is only updated if it is still at that version, otherwise
+// ErrVersionConflict is returned.
+func UpdateNote(db DBTX, owner string, id int64, title string, expectedVersion int64) error {
+	return updateNoteVersion(db, owner, id, expectedVersion, "title = ?,", title)
+}
+
+// TouchNote marks the note as updated, bumping its version.
+func TouchNote(db DBTX, owner string, id int64) error {
+	return updateNoteVersion(db, owner, id, 0, "")
+}
 
-	_, err = stmt.Exec(title, formatTime(time.Now().UTC()), id)
+// SetNoteTimes overwrites when the note was created & last updated without
+// bumping its version.
+func SetNoteTimes(db DBTX, owner string, id int64, createdOn time.Time, updatedOn time.Time) error {
+	_, err := db.Exec("UPDATE notes SET created_on = ?, updated_on = ? WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL",
+		formatTime(createdOn.UTC()), formatTime(updatedOn.UTC()), id, owner)
 	return err
 }
 
-func TouchNote(db *sql.DB, id int64) error {
-	stmt, err := db.Prepare("UPDATE notes SET updated_on = ? WHERE id = ?")
-	if err != nil {
+// SetNoteState sets or clears the state on the note without bumping its
+// version.
+func SetNoteState(db DBTX, owner string, id int64, state NoteState, on bool) error {
+	if err := validateNoteState(state); err != nil {
 		return err
 	}
-	defer stmt.Close()
-
-	_, err = stmt.Exec(formatTime(time.Now().UTC()), id)
+	// The state is also the name of its column.
+	_, err := db.Exec("UPDATE notes SET "+string(state)+" = ? WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL", on, id, owner)
 	return err
 }
 
-func GetNoteContents(db *sql.DB, id int64) ([]byte, error) {
-	stmt, err := db.Prepare("SELECT content FROM notes_content WHERE note_id = ?")
+func GetNoteContents(db DBTX, owner string, id int64) ([]byte, error) {
+	stmt, err := db.Prepare(`
+        SELECT content
+        FROM notes_content
+            JOIN notes ON notes.id = notes_content.note_id
+        WHERE note_id = ? AND owner_sub = ?`)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(id)
+	row := stmt.QueryRow(id, owner)
 
 	var content []byte
 	err = row.Scan(&content)
@@ -239,29 +346,113 @@ func GetNoteContents(db *sql.DB, id int64) ([]byte, error) {
 	return content, nil
 }
 
-func SetNoteContents(db *sql.DB, id int64, content []byte) error {
-	// TODO: Update updated_on field on main note (or have it be column in notes_content?)
-	stmt, err := db.Prepare(`
-        INSERT INTO notes_content (note_id, content) VALUES (?, ?)
-            ON CONFLICT(note_id) DO UPDATE SET content = excluded.content`)
+// SetNoteContents replaces the content of the note & marks it as updated. If
+// expectedVersion is not 0 the content is only replaced if the note is still
+// at that version, otherwise ErrVersionConflict is returned. If the note
+// doesn't exist or is in the trash ErrNoteNotFound is returned. The content is
+// stored as given; its links & search index entry are up to the caller (see
+// SetNoteLinks & IndexNoteContent).
+func SetNoteContents(db DBTX, owner string, id int64, content []byte, expectedVersion int64) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkNoteExists(tx, owner, id); err != nil {
+		return err
+	}
+	if err := updateNoteVersion(tx, owner, id, expectedVersion, ""); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO notes_content (note_id, content)
+            SELECT id, ? FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL
+            ON CONFLICT(note_id) DO UPDATE SET content = excluded.content`,
+		content, id, owner)
 	if err != nil {
 		return err
 	}
+
+	return tx.Commit()
+}
+
+// AssignUnownedNotes gives every note without an owner to the given owner.
+// Notes created before ownership was introduced have an empty owner.
+func AssignUnownedNotes(db DBTX, owner string) (int64, error) {
+	stmt, err := db.Prepare("UPDATE notes SET owner_sub = ? WHERE owner_sub = ''")
+	if err != nil {
+		return 0, err
+	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(id, content)
-	return err
+	result, err := stmt.Exec(owner)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // Private
 
-func scanNoteRow(row *sql.Row) (*IndexEntry, error) {
-	note := &IndexEntry{Note: &notes.Note{}}
-	var createdOn, updatedOn string
-	err := row.Scan(&note.ID, &note.Title, &createdOn, &updatedOn, &note.ContentType)
+// checkNoteExists returns ErrNoteNotFound unless the owner has a note with the
+// ID that isn't in the trash.
+func checkNoteExists(tx DBTX, owner string, id int64) error {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL)", id, owner).Scan(&exists)
 	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoteNotFound
+	}
+	return nil
+}
+
+// noteColumns are the columns of a note expected by scanNote, in order.
+// Tags & properties are aggregated into JSON so they can be loaded in the same
+// query.
+const noteColumns = `
+            notes.id,
+            notes.title,
+            notes.created_on,
+            notes.updated_on,
+            notes.content_type_id,
+            (SELECT json_group_array(name) FROM (
+                SELECT tags.name
+                FROM note_tags
+                    JOIN tags ON tags.id = note_tags.tag_id
+                WHERE note_tags.note_id = notes.id
+                ORDER BY tags.name)),
+            ` + notePropertiesSQL + `,
+            notes.notebook_id,
+            notes.pinned,
+            notes.favorite,
+            notes.archived,
+            notes.due_on,
+            notes.remind_at,
+            notes.recurrence,
+            notes.next_reminder_at,
+            notes.version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanNote scans the columns in noteColumns, followed by any extra columns
+// into the given destinations.
+func scanNote(row rowScanner, extra ...any) (*IndexEntry, error) {
+	note := &IndexEntry{Note: &notes.Note{}}
+	var createdOn, updatedOn, tags, properties string
+	var notebookID sql.NullInt64
+	var dueOn, remindAt, recurrence, nextReminderAt sql.NullString
+	dest := append([]any{&note.ID, &note.Title, &createdOn, &updatedOn, &note.ContentType, &tags, &properties, &notebookID,
+		&note.Pinned, &note.Favorite, &note.Archived, &dueOn, &remindAt, &recurrence, &nextReminderAt, &note.Version}, extra...)
+	if err := row.Scan(dest...); err != nil {
 		return nil, err
 	}
+	var err error
 	note.CreatedOn, err = parseTime(createdOn)
 	if err != nil {
 		return nil, err
@@ -270,39 +461,178 @@ func scanNoteRow(row *sql.Row) (*IndexEntry, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal([]byte(tags), &note.Tags); err != nil {
+		return nil, fmt.Errorf("invalid tags for note %d: %w", note.ID, err)
+	}
+	if err := json.Unmarshal([]byte(properties), &note.Properties); err != nil {
+		return nil, fmt.Errorf("invalid properties for note %d: %w", note.ID, err)
+	}
+	if notebookID.Valid {
+		note.NotebookID = &notebookID.Int64
+	}
+	if note.DueOn, err = parseNullTime(dueOn); err != nil {
+		return nil, err
+	}
+	if note.RemindAt, err = parseNullTime(remindAt); err != nil {
+		return nil, err
+	}
+	if note.NextReminderAt, err = parseNullTime(nextReminderAt); err != nil {
+		return nil, err
+	}
+	note.Recurrence = recurrence.String
 	return note, nil
 }
 
-func scanNoteWithPreviewRows(rows *sql.Rows) (*IndexEntryWithPreview, error) {
-	note := &IndexEntryWithPreview{IndexEntry: &IndexEntry{Note: &notes.Note{}}}
-	var createdOn, updatedOn string
-	rows.Scan(&note.ID, &note.Title, &createdOn, &updatedOn, &note.ContentType, &note.ContentPreview)
-	var err error
-	note.CreatedOn, err = parseTime(createdOn)
+func scanNoteRow(row *sql.Row) (*IndexEntry, error) {
+	return scanNote(row)
+}
+
+func scanNoteWithPreviewRows(rows *sql.Rows, previewLength int, decode func([]byte) ([]byte, error)) (*IndexEntryWithPreview, error) {
+	var content []byte
+	note, err := scanNote(rows, &content)
 	if err != nil {
 		return nil, err
 	}
-	note.UpdatedOn, err = parseTime(updatedOn)
-	if err != nil {
-		return nil, err
+	if decode != nil {
+		if content, err = decode(content); err != nil {
+			return nil, fmt.Errorf("failed to decode content of note %d: %w", note.ID, err)
+		}
 	}
-	return note, nil
+	return &IndexEntryWithPreview{IndexEntry: note, ContentPreview: contentPreview(content, previewLength)}, nil
+}
+
+// contentPreview returns the first previewLength characters of the content,
+// followed by an ellipsis if there is more.
+func contentPreview(content []byte, previewLength int) string {
+	preview := []rune(string(content))
+	if len(preview) > previewLength {
+		preview = append(preview[:previewLength], []rune("...")...)
+	}
+	return string(preview)
 }
 
 func scanNoteRows(rows *sql.Rows) (*IndexEntry, error) {
-	note := &IndexEntry{Note: &notes.Note{}}
-	var createdOn, updatedOn string
-	rows.Scan(&note.ID, &note.Title, &createdOn, &updatedOn, &note.ContentType)
-	var err error
-	note.CreatedOn, err = parseTime(createdOn)
+	return scanNote(rows)
+}
+
+// updateNoteVersion bumps the version & updated_on of the note, applying any
+// extra assignments in set (which must end with a comma) with the given args.
+// If expectedVersion is not 0 and the note exists at a different version,
+// nothing is changed & ErrVersionConflict is returned.
+func updateNoteVersion(ex DBTX, owner string, id int64, expectedVersion int64, set string, args ...any) error {
+	args = append(args, formatTime(time.Now().UTC()), id, owner, expectedVersion, expectedVersion)
+	result, err := ex.Exec(`
+        UPDATE notes SET `+set+` updated_on = ?, version = version + 1
+        WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL AND (? = 0 OR version = ?)`,
+		args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	note.UpdatedOn, err = parseTime(updatedOn)
+	affected, err := result.RowsAffected()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return note, nil
+	if affected > 0 || expectedVersion == 0 {
+		return nil
+	}
+
+	var exists bool
+	err = ex.QueryRow("SELECT EXISTS (SELECT 1 FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL)", id, owner).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func validateNoteState(state NoteState) error {
+	if !slices.Contains(NoteStates, state) {
+		return fmt.Errorf("invalid note state: %s", state)
+	}
+	return nil
+}
+
+// matchesView returns whether the note is in the view, like buildNoteFilter
+// does in SQL.
+func matchesView(note *notes.Note, view NoteView) bool {
+	switch view {
+	case NoteViewActive:
+		return !note.Archived
+	case NoteViewArchived:
+		return note.Archived
+	case NoteViewFavorites:
+		return note.Favorite
+	default:
+		return true
+	}
+}
+
+func buildNoteFilter(filter *NoteFilter) (string, []any) {
+	if filter == nil {
+		return "", nil
+	}
+
+	clauses, args := []string{}, []any{}
+	if len(filter.Tags) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.Tags)), ", ")
+		clause := `
+            notes.id IN (
+                SELECT note_tags.note_id
+                FROM note_tags
+                    JOIN tags ON tags.id = note_tags.tag_id
+                WHERE tags.name IN (` + placeholders + `)
+                GROUP BY note_tags.note_id`
+		for _, t := range filter.Tags {
+			args = append(args, t)
+		}
+		if filter.MatchAllTags {
+			clause += `
+                HAVING COUNT(DISTINCT tags.name) = ?`
+			args = append(args, len(uniqueTags(filter.Tags)))
+		}
+		clauses = append(clauses, clause+")")
+	}
+
+	if filter.NotebookID != nil {
+		if filter.IncludeSubNotebooks {
+			clauses = append(clauses, `
+            notes.notebook_id IN (`+notebookSubtreeSQL+`)`)
+		} else {
+			clauses = append(clauses, "notes.notebook_id = ?")
+		}
+		args = append(args, *filter.NotebookID)
+	}
+
+	if filter.UpdatedAfter != nil {
+		clauses = append(clauses, "notes.updated_on > ?")
+		args = append(args, formatTime(filter.UpdatedAfter.UTC()))
+	}
+	if filter.CreatedBefore != nil {
+		clauses = append(clauses, "notes.created_on < ?")
+		args = append(args, formatTime(filter.CreatedBefore.UTC()))
+	}
+
+	for _, p := range filter.Properties {
+		clause, propertyArgs := buildPropertyFilter(p)
+		clauses = append(clauses, clause)
+		args = append(args, propertyArgs...)
+	}
+
+	switch filter.View {
+	case NoteViewActive:
+		clauses = append(clauses, "notes.archived = 0")
+	case NoteViewArchived:
+		clauses = append(clauses, "notes.archived = 1")
+	case NoteViewFavorites:
+		clauses = append(clauses, "notes.favorite = 1")
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
 }
 
 func formatTime(t time.Time) string {
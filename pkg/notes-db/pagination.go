@@ -0,0 +1,227 @@
+package notesdb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type NoteSort string
+
+const (
+	NoteSortCreatedOn NoteSort = "created_on"
+	NoteSortUpdatedOn NoteSort = "updated_on"
+	// NoteSortTitle sorts titles case-insensitively.
+	NoteSortTitle NoteSort = "title"
+)
+
+// NoteSorts are all of the valid values of NoteSort.
+var NoteSorts = []NoteSort{NoteSortCreatedOn, NoteSortUpdatedOn, NoteSortTitle}
+
+// NotePage selects one page of the notes returned by GetNotes &
+// GetNotesWithPreview. A nil page returns every note, oldest first.
+type NotePage struct {
+	// Sort defaults to NoteSortCreatedOn. Ties are broken by note ID.
+	Sort       NoteSort
+	Descending bool
+
+	// PinnedFirst puts pinned notes before all others, each in the order
+	// given by Sort & Descending.
+	PinnedFirst bool
+
+	// Limit is the maximum number of notes in the page; 0 means no limit.
+	Limit int
+
+	// Cursor is the next cursor returned with the previous page, or "" for
+	// the first page. It is only valid with the same Sort, Descending &
+	// PinnedFirst.
+	Cursor string
+}
+
+// noteCursor is the position after the last note of a page. It is handed out
+// to clients base64-encoded, so they should treat it as opaque.
+type noteCursor struct {
+	Sort       NoteSort `json:"s"`
+	Descending bool     `json:"d"`
+	Value      string   `json:"v"`
+	ID         int64    `json:"id"`
+
+	// Pinned is only set if PinnedFirst is.
+	PinnedFirst bool `json:"pf,omitempty"`
+	Pinned      bool `json:"p,omitempty"`
+}
+
+func (p *NotePage) sort() NoteSort {
+	if p == nil || p.Sort == "" {
+		return NoteSortCreatedOn
+	}
+	return p.Sort
+}
+
+func (p *NotePage) descending() bool {
+	return p != nil && p.Descending
+}
+
+func (p *NotePage) pinnedFirst() bool {
+	return p != nil && p.PinnedFirst
+}
+
+// decodeCursor returns the position the page starts after, or nil for the
+// first page.
+func (p *NotePage) decodeCursor() (*noteCursor, error) {
+	if p == nil || p.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &noteCursor{}
+	if err := json.Unmarshal(raw, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != p.sort() || cursor.Descending != p.descending() || cursor.PinnedFirst != p.pinnedFirst() {
+		return nil, fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidCursor)
+	}
+	return cursor, nil
+}
+
+// nextCursor returns the cursor for the page after the one ending with the
+// given note.
+func (p *NotePage) nextCursor(last *notes.Note) string {
+	cursor := &noteCursor{Sort: p.sort(), Descending: p.descending(), Value: noteSortValue(last, p.sort()), ID: last.ID}
+	if p.pinnedFirst() {
+		cursor.PinnedFirst, cursor.Pinned = true, last.Pinned
+	}
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func (p *NotePage) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Limit < 0 {
+		return fmt.Errorf("limit cannot be negative: %d", p.Limit)
+	}
+	switch p.sort() {
+	case NoteSortCreatedOn, NoteSortUpdatedOn, NoteSortTitle:
+		return nil
+	default:
+		return fmt.Errorf("invalid sort: %s", p.Sort)
+	}
+}
+
+// buildNotePage returns the SQL to append to the WHERE clause of a notes query
+// to skip the notes before the cursor, and the ORDER BY & LIMIT clauses that
+// follow it. One more note than the limit is selected so callers can tell
+// whether there is a next page.
+func buildNotePage(page *NotePage) (string, []any, string, error) {
+	if err := page.validate(); err != nil {
+		return "", nil, "", err
+	}
+	cursor, err := page.decodeCursor()
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	column := map[NoteSort]string{
+		NoteSortCreatedOn: "notes.created_on",
+		NoteSortUpdatedOn: "notes.updated_on",
+		NoteSortTitle:     "notes.title COLLATE NOCASE",
+	}[page.sort()]
+	op, dir := ">", "ASC"
+	if page.descending() {
+		op, dir = "<", "DESC"
+	}
+
+	where, args := "", []any{}
+	if cursor != nil {
+		where = fmt.Sprintf("(%s %s ? OR (%s = ? AND notes.id %s ?))", column, op, column, op)
+		args = append(args, cursor.Value, cursor.Value, cursor.ID)
+		if page.pinnedFirst() {
+			// Pinned notes come first whichever way the rest is sorted.
+			where = fmt.Sprintf("(notes.pinned < ? OR (notes.pinned = ? AND %s))", where)
+			args = append([]any{cursor.Pinned, cursor.Pinned}, args...)
+		}
+		where = " AND " + where
+	}
+	orderLimit := fmt.Sprintf(" ORDER BY %s %s, notes.id %s", column, dir, dir)
+	if page.pinnedFirst() {
+		orderLimit = " ORDER BY notes.pinned DESC," + strings.TrimPrefix(orderLimit, " ORDER BY")
+	}
+	if page != nil && page.Limit > 0 {
+		orderLimit += fmt.Sprintf(" LIMIT %d", page.Limit+1)
+	}
+	return where, args, orderLimit, nil
+}
+
+// compareNotes orders notes the same way buildNotePage does, returning a
+// negative number if a comes before b.
+func compareNotes(page *NotePage, a *notes.Note, b *notes.Note) int {
+	if page.pinnedFirst() && a.Pinned != b.Pinned {
+		return comparePinned(a.Pinned)
+	}
+	return compareSortKeys(page, noteSortValue(a, page.sort()), a.ID, noteSortValue(b, page.sort()), b.ID)
+}
+
+// afterCursor returns whether the note comes after the cursor in the page's order.
+func afterCursor(page *NotePage, cursor *noteCursor, note *notes.Note) bool {
+	if page.pinnedFirst() && note.Pinned != cursor.Pinned {
+		return comparePinned(note.Pinned) > 0
+	}
+	return compareSortKeys(page, noteSortValue(note, page.sort()), note.ID, cursor.Value, cursor.ID) > 0
+}
+
+// comparePinned compares a note to one whose pinned state differs from it.
+func comparePinned(pinned bool) int {
+	if pinned {
+		return -1
+	}
+	return 1
+}
+
+func compareSortKeys(page *NotePage, aValue string, aID int64, bValue string, bID int64) int {
+	c := strings.Compare(aValue, bValue)
+	if c == 0 {
+		switch {
+		case aID < bID:
+			c = -1
+		case aID > bID:
+			c = 1
+		}
+	}
+	if page.descending() {
+		c = -c
+	}
+	return c
+}
+
+func noteSortValue(note *notes.Note, s NoteSort) string {
+	switch s {
+	case NoteSortUpdatedOn:
+		return formatTime(note.UpdatedOn)
+	case NoteSortTitle:
+		return foldASCII(note.Title)
+	default:
+		return formatTime(note.CreatedOn)
+	}
+}
+
+// foldASCII lower-cases only ASCII letters, to match SQLite's NOCASE collation.
+func foldASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
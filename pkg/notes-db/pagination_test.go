@@ -0,0 +1,81 @@
+package notesdb
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// collectPages pages through the owner's notes & returns their IDs in order.
+func collectPages(t *testing.T, store Store, owner string, page NotePage) []int64 {
+	t.Helper()
+	ids := []int64{}
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("paging did not end")
+		}
+		entries, next, err := store.GetNotes(owner, nil, &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+		}
+		if next == "" {
+			return ids
+		}
+		page.Cursor = next
+	}
+}
+
+func TestPinnedFirstCursorBreaksTiesByID(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// All of the notes share the same times, so the sort value ties
+			// everywhere & only the pinned flag & ID tell them apart.
+			created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			ids := []int64{}
+			for i := 0; i < 6; i++ {
+				entry, err := store.NewNote("owner", "Note")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.SetNoteTimes("owner", entry.ID, created, created); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, entry.ID)
+			}
+			for _, i := range []int{1, 4} {
+				if err := store.SetNoteState("owner", ids[i], NoteStatePinned, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ascending := []int64{ids[1], ids[4], ids[0], ids[2], ids[3], ids[5]}
+			descending := []int64{ids[4], ids[1], ids[5], ids[3], ids[2], ids[0]}
+			for _, sort := range NoteSorts {
+				for _, limit := range []int{0, 1, 2, 4} {
+					got := collectPages(t, store, "owner", NotePage{Sort: sort, PinnedFirst: true, Limit: limit})
+					if !slices.Equal(got, ascending) {
+						t.Errorf("sort %s, limit %d: expected %v, got %v", sort, limit, ascending, got)
+					}
+					got = collectPages(t, store, "owner", NotePage{Sort: sort, Descending: true, PinnedFirst: true, Limit: limit})
+					if !slices.Equal(got, descending) {
+						t.Errorf("sort %s descending, limit %d: expected %v, got %v", sort, limit, descending, got)
+					}
+				}
+			}
+
+			// A cursor from a pinned-first page can't be used without it.
+			_, next, err := store.GetNotes("owner", nil, &NotePage{PinnedFirst: true, Limit: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = store.GetNotes("owner", nil, &NotePage{Limit: 1, Cursor: next})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package notesdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PropertyString = "string"
+	PropertyNumber = "number"
+	PropertyBool   = "bool"
+	PropertyDate   = "date"
+
+	PropertyDateFormat     = "2006-01-02"
+	MaxPropertyValueLength = 1024
+)
+
+// PropertyTypes are all of the types of property values.
+var PropertyTypes = []string{PropertyString, PropertyNumber, PropertyBool, PropertyDate}
+
+// PropertyOps are the comparison operators of property filters, longest
+// first so that e.g. >= is matched before >.
+var PropertyOps = []string{"!=", ">=", "<=", "=", ">", "<"}
+
+// Property names may not contain the characters of PropertyOps, so that
+// filters can be told apart from names.
+var propertyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,63}$`)
+
+// PropertyValue is a typed property value. Value is a string for strings &
+// dates (as PropertyDateFormat), a float64 for numbers & a bool for bools.
+type PropertyValue struct {
+	Type  string
+	Value any
+}
+
+// NewPropertyValue types a value decoded from JSON. Strings in
+// PropertyDateFormat are dates; objects, arrays & nulls aren't valid values.
+func NewPropertyValue(v any) (*PropertyValue, error) {
+	switch v := v.(type) {
+	case string:
+		if len(v) > MaxPropertyValueLength {
+			return nil, fmt.Errorf("property values cannot be longer than %d characters", MaxPropertyValueLength)
+		}
+		if isPropertyDate(v) {
+			return &PropertyValue{Type: PropertyDate, Value: v}, nil
+		}
+		return &PropertyValue{Type: PropertyString, Value: v}, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("property values must be finite numbers")
+		}
+		return &PropertyValue{Type: PropertyNumber, Value: v}, nil
+	case bool:
+		return &PropertyValue{Type: PropertyBool, Value: v}, nil
+	default:
+		return nil, fmt.Errorf("property values must be strings, numbers, bools or dates (YYYY-MM-DD)")
+	}
+}
+
+// ParsePropertyPatch parses a JSON merge patch (RFC 7396) of a note's
+// properties: a null value unsets the property, which is returned as nil, and
+// any other value sets it.
+func ParsePropertyPatch(data []byte) (map[string]*PropertyValue, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return nil, fmt.Errorf("expected a JSON object of properties")
+	}
+	patch := map[string]*PropertyValue{}
+	for name, v := range raw {
+		if err := validatePropertyName(name); err != nil {
+			return nil, err
+		}
+		if v == nil {
+			patch[name] = nil
+			continue
+		}
+		value, err := NewPropertyValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		patch[name] = value
+	}
+	return patch, nil
+}
+
+// PropertyFilter matches notes whose property compares to Value with Op. Only
+// properties of the same type as Value match, except that != also matches
+// notes without the property.
+type PropertyFilter struct {
+	Name  string
+	Op    string
+	Value *PropertyValue
+}
+
+// ParsePropertyFilter parses a filter like status=open or priority>2. The
+// type of the value is inferred: true & false are bools, anything that parses
+// as a number is a number, YYYY-MM-DD is a date & everything else a string.
+// It can also be given after the name, as in code:string=42, in which case the
+// value must be of that type.
+func ParsePropertyFilter(expr string) (*PropertyFilter, error) {
+	i := strings.IndexAny(expr, "!=<>")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid property filter (expected NAME, an operator & a value): %s", expr)
+	}
+	name, typ, typed := strings.Cut(expr[:i], ":")
+	filter := &PropertyFilter{Name: name}
+	if err := validatePropertyName(filter.Name); err != nil {
+		return nil, err
+	}
+	if typed && !slices.Contains(PropertyTypes, typ) {
+		return nil, fmt.Errorf("invalid property type (expected one of %s): %s", strings.Join(PropertyTypes, ", "), typ)
+	}
+	for _, op := range PropertyOps {
+		if strings.HasPrefix(expr[i:], op) {
+			filter.Op = op
+			break
+		}
+	}
+	if filter.Op == "" {
+		return nil, fmt.Errorf("invalid property filter operator (expected one of %s): %s", strings.Join(PropertyOps, " "), expr)
+	}
+
+	raw := expr[i+len(filter.Op):]
+	if !typed {
+		filter.Value = inferPropertyValue(raw)
+		return filter, nil
+	}
+	value, err := parsePropertyValue(typ, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filter.Name, err)
+	}
+	filter.Value = value
+	return filter, nil
+}
+
+// SetProperties applies the patch to the note's properties, unsetting the
+// ones that map to nil.
+func SetProperties(db DBTX, owner string, id int64, patch map[string]*PropertyValue) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL)", id, owner).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	for name, value := range patch {
+		if value == nil {
+			_, err = tx.Exec("DELETE FROM note_properties WHERE note_id = ? AND name = ?", id, name)
+		} else {
+			_, err = tx.Exec(`
+                INSERT INTO note_properties (note_id, name, type, value) VALUES (?, ?, ?, ?)
+                ON CONFLICT (note_id, name) DO UPDATE SET type = excluded.type, value = excluded.value`,
+				id, name, value.Type, value.sqlValue())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Private
+
+// notePropertiesSQL aggregates the properties of notes.id into a JSON object,
+// turning bools back from 0 & 1.
+const notePropertiesSQL = `(SELECT json_group_object(name, IIF(type = 'bool', json(IIF(value, 'true', 'false')), value))
+                FROM note_properties
+                WHERE note_properties.note_id = notes.id)`
+
+func validatePropertyName(name string) error {
+	if !propertyNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid property name (expected up to 64 letters, digits, '_', '.' or '-'): %s", name)
+	}
+	return nil
+}
+
+// inferPropertyValue types a value given as text the way ParsePropertyFilter
+// describes.
+func inferPropertyValue(raw string) *PropertyValue {
+	if raw == "true" || raw == "false" {
+		return &PropertyValue{Type: PropertyBool, Value: raw == "true"}
+	}
+	if isPropertyDate(raw) {
+		return &PropertyValue{Type: PropertyDate, Value: raw}
+	}
+	if n, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+		return &PropertyValue{Type: PropertyNumber, Value: n}
+	}
+	return &PropertyValue{Type: PropertyString, Value: raw}
+}
+
+// parsePropertyValue parses a value given as text as the given type.
+func parsePropertyValue(typ string, raw string) (*PropertyValue, error) {
+	switch typ {
+	case PropertyNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("invalid number: %s", raw)
+		}
+		return &PropertyValue{Type: PropertyNumber, Value: n}, nil
+	case PropertyBool:
+		if raw != "true" && raw != "false" {
+			return nil, fmt.Errorf("invalid bool (expected true or false): %s", raw)
+		}
+		return &PropertyValue{Type: PropertyBool, Value: raw == "true"}, nil
+	case PropertyDate:
+		if !isPropertyDate(raw) {
+			return nil, fmt.Errorf("invalid date (expected YYYY-MM-DD): %s", raw)
+		}
+		return &PropertyValue{Type: PropertyDate, Value: raw}, nil
+	default:
+		return &PropertyValue{Type: PropertyString, Value: raw}, nil
+	}
+}
+
+func isPropertyDate(s string) bool {
+	if len(s) != len(PropertyDateFormat) {
+		return false
+	}
+	_, err := time.Parse(PropertyDateFormat, s)
+	return err == nil
+}
+
+func (v *PropertyValue) sqlValue() any {
+	if b, ok := v.Value.(bool); ok {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	return v.Value
+}
+
+// buildPropertyFilter returns the SQL clause matching the filter.
+func buildPropertyFilter(filter *PropertyFilter) (string, []any) {
+	args := []any{filter.Name, filter.Value.Type, filter.Value.sqlValue()}
+	if filter.Op == "!=" {
+		return `
+            notes.id NOT IN (
+                SELECT note_id FROM note_properties
+                WHERE name = ? AND type = ? AND value = ?)`, args
+	}
+	return `
+            notes.id IN (
+                SELECT note_id FROM note_properties
+                WHERE name = ? AND type = ? AND value ` + filter.Op + ` ?)`, args
+}
+
+// comparePropertyValues compares two values of the same type like SQLite
+// does, returning -1, 0 or 1.
+func comparePropertyValues(a *PropertyValue, b *PropertyValue) int {
+	switch av := a.Value.(type) {
+	case string:
+		return strings.Compare(av, b.Value.(string))
+	case float64:
+		bv := b.Value.(float64)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+		return 0
+	case bool:
+		bv := b.Value.(bool)
+		if av == bv {
+			return 0
+		} else if !av {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func matchesPropertyFilters(properties map[string]*PropertyValue, filters []*PropertyFilter) bool {
+	for _, f := range filters {
+		if !matchesPropertyFilter(properties, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesPropertyFilter returns whether a note with the given properties
+// matches the filter, like buildPropertyFilter does in SQL.
+func matchesPropertyFilter(properties map[string]*PropertyValue, filter *PropertyFilter) bool {
+	value, ok := properties[filter.Name]
+	if !ok || value.Type != filter.Value.Type {
+		return filter.Op == "!="
+	}
+	cmp := comparePropertyValues(value, filter.Value)
+	switch filter.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package notesdb
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePropertyFilter(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		name  string
+		op    string
+		typ   string
+		value any
+	}{
+		{"status=open", "status", "=", PropertyString, "open"},
+		{"status!=open", "status", "!=", PropertyString, "open"},
+		{"priority>2", "priority", ">", PropertyNumber, 2.0},
+		{"priority>=2.5", "priority", ">=", PropertyNumber, 2.5},
+		{"priority<-1", "priority", "<", PropertyNumber, -1.0},
+		{"priority<=0", "priority", "<=", PropertyNumber, 0.0},
+		{"draft=true", "draft", "=", PropertyBool, true},
+		{"draft!=false", "draft", "!=", PropertyBool, false},
+		{"due<2024-06-01", "due", "<", PropertyDate, "2024-06-01"},
+		// Only the first operator counts; the rest is part of the value.
+		{"eq==x", "eq", "=", PropertyString, "=x"},
+		{"a.b-c_d=", "a.b-c_d", "=", PropertyString, ""},
+		{"n=NaN", "n", "=", PropertyString, "NaN"},
+		{"d=2024-13-01", "d", "=", PropertyString, "2024-13-01"},
+		// Explicit types override inference.
+		{"code:string=42", "code", "=", PropertyString, "42"},
+		{"code:string=true", "code", "=", PropertyString, "true"},
+		{"n:number>=1e3", "n", ">=", PropertyNumber, 1000.0},
+		{"draft:bool!=true", "draft", "!=", PropertyBool, true},
+		{"due:date<2024-06-01", "due", "<", PropertyDate, "2024-06-01"},
+	} {
+		f, err := ParsePropertyFilter(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %s", tc.expr, err)
+			continue
+		}
+		if f.Name != tc.name || f.Op != tc.op || f.Value.Type != tc.typ || f.Value.Value != tc.value {
+			t.Errorf("%s: expected %s %s %s %v, got %s %s %s %v", tc.expr, tc.name, tc.op, tc.typ, tc.value, f.Name, f.Op, f.Value.Type, f.Value.Value)
+		}
+	}
+
+	for _, expr := range []string{"status", "=open", "bad name=x", "status!open", "n:int=1", "n:number=x", "d:bool=yes", "d:date=2024-13-01", ":string=x"} {
+		if f, err := ParsePropertyFilter(expr); err == nil {
+			t.Errorf("%s: expected an error, got %+v", expr, f)
+		}
+	}
+}
+
+func TestMatchesPropertyFilter(t *testing.T) {
+	properties := map[string]*PropertyValue{
+		"status":   {Type: PropertyString, Value: "open"},
+		"priority": {Type: PropertyNumber, Value: 3.0},
+		"draft":    {Type: PropertyBool, Value: false},
+		"code":     {Type: PropertyString, Value: "42"},
+	}
+	for _, tc := range []struct {
+		expr    string
+		matches bool
+	}{
+		{"status=open", true},
+		{"status=closed", false},
+		{"priority>2", true},
+		{"priority>=3", true},
+		{"priority<3", false},
+		{"draft=false", true},
+		{"draft>false", false},
+		// Values of another type never match, except with !=.
+		{"priority=open", false},
+		{"priority!=open", true},
+		// Nor do missing properties.
+		{"missing=x", false},
+		{"missing!=x", true},
+		// Strings that look like numbers only match as strings.
+		{"code=42", false},
+		{"code:string=42", true},
+		{"code:string>4", true},
+	} {
+		f, err := ParsePropertyFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.expr, err)
+		}
+		if got := matchesPropertyFilter(properties, f); got != tc.matches {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.matches, got)
+		}
+	}
+}
+
+func TestPropertyFilters(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			patches := []string{
+				`{"code": "42", "priority": 3}`,
+				`{"code": 42, "due": "2024-06-01"}`,
+				`{"priority": 1, "draft": true}`,
+			}
+			ids := []int64{}
+			for _, p := range patches {
+				entry, err := store.NewNote("owner", "Note")
+				if err != nil {
+					t.Fatal(err)
+				}
+				patch, err := ParsePropertyPatch([]byte(p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.SetProperties("owner", entry.ID, patch); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, entry.ID)
+			}
+
+			for _, tc := range []struct {
+				exprs    []string
+				expected []int64
+			}{
+				{[]string{"code=42"}, []int64{ids[1]}},
+				{[]string{"code:string=42"}, []int64{ids[0]}},
+				{[]string{"priority>=1", "priority<3"}, []int64{ids[2]}},
+				{[]string{"due<2024-06-02"}, []int64{ids[1]}},
+				{[]string{"draft!=true"}, []int64{ids[0], ids[1]}},
+			} {
+				filter := &NoteFilter{}
+				for _, expr := range tc.exprs {
+					f, err := ParsePropertyFilter(expr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					filter.Properties = append(filter.Properties, f)
+				}
+				entries, _, err := store.GetNotes("owner", filter, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []int64{}
+				for _, e := range entries {
+					got = append(got, e.ID)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, tc.expected) {
+					t.Errorf("%v: expected %v, got %v", tc.exprs, tc.expected, got)
+				}
+			}
+		})
+	}
+}
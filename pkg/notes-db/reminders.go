@@ -0,0 +1,313 @@
+package notesdb
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+// Recurrence is how often a reminder repeats. Occurrences are computed in UTC
+// from the time the reminder was set for; monthly ones that fall on a day the
+// month doesn't have move to its last day.
+type Recurrence string
+
+const (
+	RecurrenceNone    Recurrence = ""
+	RecurrenceDaily   Recurrence = "daily"
+	RecurrenceWeekly  Recurrence = "weekly"
+	RecurrenceMonthly Recurrence = "monthly"
+)
+
+// Recurrences are all of the valid values of Recurrence, besides RecurrenceNone.
+var Recurrences = []Recurrence{RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly}
+
+// DueReminder is a reminder that is due to fire.
+type DueReminder struct {
+	*notes.Reminder
+	Owner string
+	// RemindAt is what the reminder was set for, which anchors its recurrence.
+	RemindAt time.Time
+}
+
+// ValidateReminder returns an error unless the recurrence is valid & only
+// given along with a time to remind at.
+func ValidateReminder(remindAt *time.Time, recurrence Recurrence) error {
+	if recurrence != RecurrenceNone && !slices.Contains(Recurrences, recurrence) {
+		return fmt.Errorf("invalid recurrence (expected 'daily', 'weekly' or 'monthly'): %s", recurrence)
+	}
+	if remindAt == nil && recurrence != RecurrenceNone {
+		return fmt.Errorf("recurrence requires remind_at")
+	}
+	return nil
+}
+
+// FirstReminderAt returns when a reminder set for remindAt fires first: at
+// remindAt, unless it recurs & remindAt has passed, in which case it is the
+// next occurrence. One-off reminders set in the past fire right away.
+func FirstReminderAt(remindAt time.Time, recurrence Recurrence, now time.Time) time.Time {
+	if recurrence == RecurrenceNone || !remindAt.Before(now) {
+		return remindAt
+	}
+	return nextOccurrence(remindAt, recurrence, now)
+}
+
+// NextReminderAt returns when a reminder set for remindAt fires after having
+// fired at or before now, or nil if it doesn't recur. Occurrences missed while
+// the server was down are skipped.
+func NextReminderAt(remindAt time.Time, recurrence Recurrence, now time.Time) *time.Time {
+	if recurrence == RecurrenceNone {
+		return nil
+	}
+	next := nextOccurrence(remindAt, recurrence, now)
+	return &next
+}
+
+// SetReminder sets when the note is due & when to be reminded of it, either
+// of which may be nil, without bumping its version.
+func SetReminder(db DBTX, owner string, id int64, dueOn *time.Time, remindAt *time.Time, recurrence Recurrence) error {
+	if err := ValidateReminder(remindAt, recurrence); err != nil {
+		return err
+	}
+	var next *time.Time
+	if remindAt != nil {
+		first := FirstReminderAt(remindAt.UTC().Truncate(time.Second), recurrence, time.Now().UTC())
+		next = &first
+	}
+	_, err := db.Exec(`
+        UPDATE notes SET due_on = ?, remind_at = ?, recurrence = ?, next_reminder_at = ?
+        WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL`,
+		formatNullTime(dueOn), formatNullTime(remindAt), nullRecurrence(recurrence), formatNullTime(next), id, owner)
+	return err
+}
+
+// GetUpcomingReminders returns the owner's reminders that fire before the
+// given time, soonest first, including overdue ones that haven't fired yet.
+func GetUpcomingReminders(db DBTX, owner string, before time.Time, limit int) ([]*notes.Reminder, error) {
+	due, err := queryReminders(db, `
+        WHERE owner_sub = ? AND deleted_on IS NULL AND next_reminder_at < ?
+        ORDER BY next_reminder_at, id
+        LIMIT ?`,
+		owner, formatTime(before.UTC()), limit)
+	if err != nil {
+		return nil, err
+	}
+	reminders := []*notes.Reminder{}
+	for _, r := range due {
+		reminders = append(reminders, r.Reminder)
+	}
+	return reminders, nil
+}
+
+// GetDueReminders returns the reminders of every owner due to fire at or
+// before now, oldest first.
+func GetDueReminders(db DBTX, now time.Time) ([]*DueReminder, error) {
+	return queryReminders(db, `
+        WHERE deleted_on IS NULL AND next_reminder_at <= ?
+        ORDER BY next_reminder_at, id`,
+		formatTime(now.UTC()))
+}
+
+// GetNextReminderTime returns when the next reminder of any owner fires, or
+// nil if there is none.
+func GetNextReminderTime(db DBTX) (*time.Time, error) {
+	var next sql.NullString
+	err := db.QueryRow("SELECT MIN(next_reminder_at) FROM notes WHERE deleted_on IS NULL").Scan(&next)
+	if err != nil {
+		return nil, err
+	}
+	return parseNullTime(next)
+}
+
+// MarkReminderFired moves the reminder on to its next occurrence after now,
+// or clears it if it doesn't recur. Nothing changes if the note's reminder
+// has been changed since it was read.
+func MarkReminderFired(db DBTX, r *DueReminder, now time.Time) error {
+	next := NextReminderAt(r.RemindAt, Recurrence(r.Recurrence), now.UTC())
+	_, err := db.Exec(`
+        UPDATE notes SET next_reminder_at = ?
+        WHERE id = ? AND owner_sub = ? AND next_reminder_at = ? AND remind_at = ?`,
+		formatNullTime(next), r.NoteID, r.Owner, formatTime(r.At), formatTime(r.RemindAt))
+	return err
+}
+
+func AddNotification(db DBTX, owner string, noteID int64, title string, message string) (*notes.Notification, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	result, err := db.Exec("INSERT INTO notifications (owner_sub, note_id, title, message, created_on) VALUES (?, ?, ?, ?, ?)",
+		owner, noteID, title, message, formatTime(now))
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &notes.Notification{ID: id, NoteID: noteID, Title: title, Message: message, CreatedOn: now}, nil
+}
+
+// GetNotifications returns the owner's notifications, newest first, leaving
+// out the ones that have been read if unreadOnly is set.
+func GetNotifications(db DBTX, owner string, unreadOnly bool, limit int) ([]*notes.Notification, error) {
+	stmt, err := db.Prepare(`
+        SELECT id, note_id, title, message, created_on, read_on
+        FROM notifications
+        WHERE owner_sub = ? AND (? = 0 OR read_on IS NULL)
+        ORDER BY created_on DESC, id DESC
+        LIMIT ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*notes.Notification{}
+	for rows.Next() {
+		n := &notes.Notification{}
+		var createdOn string
+		var readOn sql.NullString
+		if err := rows.Scan(&n.ID, &n.NoteID, &n.Title, &n.Message, &createdOn, &readOn); err != nil {
+			return nil, err
+		}
+		if n.CreatedOn, err = parseTime(createdOn); err != nil {
+			return nil, err
+		}
+		if n.ReadOn, err = parseNullTime(readOn); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// MarkNotificationRead returns false if the notification doesn't exist.
+// Notifications that were already read keep the time they were first read.
+func MarkNotificationRead(db DBTX, owner string, id int64) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM notifications WHERE id = ? AND owner_sub = ?)", id, owner).Scan(&exists)
+	if err != nil || !exists {
+		return false, err
+	}
+	_, err = db.Exec("UPDATE notifications SET read_on = ? WHERE id = ? AND owner_sub = ? AND read_on IS NULL",
+		formatTime(time.Now().UTC()), id, owner)
+	return err == nil, err
+}
+
+// Private
+
+// nextOccurrence returns the first occurrence of the recurrence anchored at
+// remindAt that is after the given time.
+func nextOccurrence(remindAt time.Time, recurrence Recurrence, after time.Time) time.Time {
+	// Start close to the answer instead of stepping through every occurrence
+	// since remindAt.
+	n := 0
+	if elapsed := after.Sub(remindAt); elapsed > 0 {
+		switch recurrence {
+		case RecurrenceDaily:
+			n = int(elapsed/(24*time.Hour)) - 1
+		case RecurrenceWeekly:
+			n = int(elapsed/(7*24*time.Hour)) - 1
+		case RecurrenceMonthly:
+			n = (after.Year()-remindAt.Year())*12 + int(after.Month()-remindAt.Month()) - 1
+		}
+	}
+	for n = max(n, 0); ; n++ {
+		if next := occurrence(remindAt, recurrence, n); next.After(after) {
+			return next
+		}
+	}
+}
+
+// occurrence returns the nth occurrence of the recurrence anchored at
+// remindAt, the 0th being remindAt itself.
+func occurrence(remindAt time.Time, recurrence Recurrence, n int) time.Time {
+	switch recurrence {
+	case RecurrenceDaily:
+		return remindAt.AddDate(0, 0, n)
+	case RecurrenceWeekly:
+		return remindAt.AddDate(0, 0, 7*n)
+	case RecurrenceMonthly:
+		year, month, day := remindAt.Date()
+		// Day 0 of the month after is the last day of the month.
+		lastDay := time.Date(year, month+time.Month(n)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		return time.Date(year, month+time.Month(n), min(day, lastDay),
+			remindAt.Hour(), remindAt.Minute(), remindAt.Second(), remindAt.Nanosecond(), remindAt.Location())
+	default:
+		return remindAt
+	}
+}
+
+// queryReminders selects the reminders of the notes matching the rest of the
+// query.
+func queryReminders(db DBTX, query string, args ...any) ([]*DueReminder, error) {
+	rows, err := db.Query(`
+        SELECT owner_sub, id, title, next_reminder_at, remind_at, due_on, recurrence
+        FROM notes`+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*DueReminder{}
+	for rows.Next() {
+		r := &DueReminder{Reminder: &notes.Reminder{}}
+		var at, remindAt string
+		var dueOn, recurrence sql.NullString
+		if err := rows.Scan(&r.Owner, &r.NoteID, &r.Title, &at, &remindAt, &dueOn, &recurrence); err != nil {
+			return nil, err
+		}
+		if r.At, err = parseTime(at); err != nil {
+			return nil, err
+		}
+		if r.RemindAt, err = parseTime(remindAt); err != nil {
+			return nil, err
+		}
+		if r.DueOn, err = parseNullTime(dueOn); err != nil {
+			return nil, err
+		}
+		r.Recurrence = recurrence.String
+		reminders = append(reminders, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+func nullRecurrence(recurrence Recurrence) any {
+	if recurrence == RecurrenceNone {
+		return nil
+	}
+	return string(recurrence)
+}
+
+func formatNullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return formatTime(t.UTC())
+}
+
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := parseTime(s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
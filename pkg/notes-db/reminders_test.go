@@ -0,0 +1,75 @@
+package notesdb
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestNextOccurrenceClampsMonthlyToMonthEnd(t *testing.T) {
+	jan31 := date(2024, time.January, 31)
+	for _, tc := range []struct {
+		after    time.Time
+		expected time.Time
+	}{
+		{jan31.Add(-time.Second), jan31},
+		{jan31, date(2024, time.February, 29)}, // leap year
+		{date(2024, time.February, 29), date(2024, time.March, 31)},
+		{date(2024, time.March, 31), date(2024, time.April, 30)},
+		{date(2024, time.April, 30), date(2024, time.May, 31)},
+		{date(2025, time.January, 31), date(2025, time.February, 28)},
+		// The clamped day doesn't stick: the anchor stays the 31st.
+		{date(2025, time.February, 28), date(2025, time.March, 31)},
+		{date(2025, time.December, 31), date(2026, time.January, 31)},
+		// Long after the anchor, starting from the estimate.
+		{date(2031, time.June, 1), date(2031, time.June, 30)},
+	} {
+		if got := nextOccurrence(jan31, RecurrenceMonthly, tc.after); !got.Equal(tc.expected) {
+			t.Errorf("after %s: expected %s, got %s", tc.after, tc.expected, got)
+		}
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	anchor := date(2024, time.March, 1)
+	for _, tc := range []struct {
+		recurrence Recurrence
+		after      time.Time
+		expected   time.Time
+	}{
+		{RecurrenceDaily, anchor, date(2024, time.March, 2)},
+		{RecurrenceDaily, anchor.Add(-time.Hour), anchor},
+		{RecurrenceDaily, date(2024, time.March, 10).Add(time.Hour), date(2024, time.March, 11)},
+		{RecurrenceWeekly, anchor, date(2024, time.March, 8)},
+		{RecurrenceWeekly, date(2024, time.March, 8), date(2024, time.March, 15)},
+		{RecurrenceWeekly, date(2025, time.March, 1), date(2025, time.March, 7)},
+		{RecurrenceMonthly, anchor, date(2024, time.April, 1)},
+		{RecurrenceMonthly, date(2024, time.December, 15), date(2025, time.January, 1)},
+	} {
+		if got := nextOccurrence(anchor, tc.recurrence, tc.after); !got.Equal(tc.expected) {
+			t.Errorf("%s after %s: expected %s, got %s", tc.recurrence, tc.after, tc.expected, got)
+		}
+	}
+}
+
+func TestFirstReminderAt(t *testing.T) {
+	now := date(2024, time.March, 10)
+	past := date(2024, time.March, 1)
+	future := date(2024, time.March, 20)
+
+	if got := FirstReminderAt(past, RecurrenceNone, now); !got.Equal(past) {
+		t.Errorf("one-off reminders in the past should fire right away, got %s", got)
+	}
+	if got := FirstReminderAt(future, RecurrenceDaily, now); !got.Equal(future) {
+		t.Errorf("reminders in the future should fire when set for, got %s", got)
+	}
+	if got := FirstReminderAt(past, RecurrenceWeekly, now); !got.Equal(date(2024, time.March, 15)) {
+		t.Errorf("recurring reminders in the past should start at their next occurrence, got %s", got)
+	}
+	if got := NextReminderAt(past, RecurrenceNone, now); got != nil {
+		t.Errorf("one-off reminders should not fire again, got %s", got)
+	}
+}
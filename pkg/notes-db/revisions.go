@@ -0,0 +1,118 @@
+package notesdb
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+// RecordRevision snapshots the current title of the note along with the given
+// content as a new revision. The content is passed in since it may not live in
+// the DB (see CONTENT_FILE). If maxRevisions is greater than 0, the oldest
+// revisions beyond that many are discarded.
+func RecordRevision(db DBTX, owner string, id int64, content []byte, maxRevisions int) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO notes_revisions (note_id, revision, title, content, created_on)
+            SELECT
+                notes.id,
+                (SELECT COALESCE(MAX(revision), 0) + 1 FROM notes_revisions WHERE note_id = notes.id),
+                notes.title,
+                ?,
+                ?
+            FROM notes
+            WHERE notes.id = ? AND notes.owner_sub = ?`,
+		content, formatTime(time.Now().UTC()), id, owner)
+	if err != nil {
+		return err
+	}
+
+	if maxRevisions > 0 {
+		_, err = tx.Exec(`
+            DELETE FROM notes_revisions
+            WHERE note_id = ?
+                AND revision <= (SELECT MAX(revision) FROM notes_revisions WHERE note_id = ?) - ?`,
+			id, id, maxRevisions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func GetRevisions(db DBTX, owner string, id int64) ([]*notes.Revision, error) {
+	stmt, err := db.Prepare(`
+        SELECT note_id, revision, notes_revisions.title, notes_revisions.created_on
+        FROM notes_revisions
+            JOIN notes ON notes.id = notes_revisions.note_id
+        WHERE note_id = ? AND owner_sub = ?
+        ORDER BY revision DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*notes.Revision{}
+	for rows.Next() {
+		revision := &notes.Revision{}
+		var createdOn string
+		if err := rows.Scan(&revision.NoteID, &revision.Number, &revision.Title, &createdOn); err != nil {
+			return nil, err
+		}
+		revision.CreatedOn, err = parseTime(createdOn)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetRevision returns the given revision of the note, or nil if it doesn't exist.
+func GetRevision(db DBTX, owner string, id int64, rev int64) (*notes.RevisionWithContent, error) {
+	stmt, err := db.Prepare(`
+        SELECT note_id, revision, notes_revisions.title, notes_revisions.created_on, notes_revisions.content
+        FROM notes_revisions
+            JOIN notes ON notes.id = notes_revisions.note_id
+        WHERE note_id = ? AND revision = ? AND owner_sub = ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	revision := &notes.RevisionWithContent{Revision: &notes.Revision{}}
+	var createdOn string
+	var content []byte
+	err = stmt.QueryRow(id, rev, owner).Scan(&revision.NoteID, &revision.Number, &revision.Title, &createdOn, &content)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	revision.Content = string(content)
+	revision.CreatedOn, err = parseTime(createdOn)
+	if err != nil {
+		return nil, err
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,90 @@
+package notesdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SearchHighlightStart = "<mark>"
+	SearchHighlightEnd   = "</mark>"
+
+	searchSnippetTokens = 16
+	searchTitleWeight   = 10.0
+	searchContentWeight = 1.0
+)
+
+type SearchResult struct {
+	*IndexEntry
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+	Rank           float64 `json:"rank"`
+}
+
+// SearchNotes runs a full-text search over the titles & contents of the
+// owner's notes, returning at most limit results ordered by relevance (bm25).
+// Each whitespace-separated term in the query must appear in the note.
+func SearchNotes(db DBTX, owner string, query string, limit int) ([]*SearchResult, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be greater than 0: %d", limit)
+	}
+	match := buildSearchMatch(query)
+	if match == "" {
+		return []*SearchResult{}, nil
+	}
+
+	stmt, err := db.Prepare(`
+        SELECT
+            ` + noteColumns + `,
+            highlight(notes_search, 0, ?, ?),
+            snippet(notes_search, 1, ?, ?, '...', ?),
+            bm25(notes_search, ?, ?) AS rank
+        FROM notes_search
+            JOIN notes ON notes.id = notes_search.rowid
+        WHERE notes_search MATCH ? AND notes.owner_sub = ? AND notes.deleted_on IS NULL
+        ORDER BY rank
+        LIMIT ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(
+		SearchHighlightStart, SearchHighlightEnd,
+		SearchHighlightStart, SearchHighlightEnd, searchSnippetTokens,
+		searchTitleWeight, searchContentWeight,
+		match, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		result := &SearchResult{}
+		result.IndexEntry, err = scanNote(rows, &result.TitleHighlight, &result.Snippet, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Private
+
+// buildSearchMatch turns free-form user input into an FTS5 MATCH expression.
+// Every term is quoted so that FTS5 operators & punctuation in the input are
+// matched literally rather than causing syntax errors.
+func buildSearchMatch(query string) string {
+	terms := []string{}
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,264 @@
+package notesdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+const (
+	// ShareModeText serves the content of a shared note as plain text &
+	// ShareModeHTML as an HTML page along with its title.
+	ShareModeText = "text"
+	ShareModeHTML = "html"
+
+	// MaxSharePasswordLength is the most bcrypt hashes.
+	MaxSharePasswordLength = 72
+
+	shareTokenBytes = 32
+)
+
+// ShareModes are all of the valid share modes.
+var ShareModes = []string{ShareModeText, ShareModeHTML}
+
+// ResolvedShare is a share looked up by its token, along with what is needed
+// to check access to it.
+type ResolvedShare struct {
+	*notes.Share
+	Owner        string
+	passwordHash string
+}
+
+// IsActive returns whether the share has been neither revoked nor expired as
+// of now.
+func (s *ResolvedShare) IsActive(now time.Time) bool {
+	return s.RevokedOn == nil && (s.ExpiresOn == nil || now.Before(*s.ExpiresOn))
+}
+
+// CheckPassword returns whether the password opens the share, which it always
+// does if the share has none.
+func (s *ResolvedShare) CheckPassword(password string) bool {
+	if s.passwordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(s.passwordHash), []byte(password)) == nil
+}
+
+// ValidateShare returns an error unless the mode is valid, the password isn't
+// too long & the share doesn't expire at or before now.
+func ValidateShare(mode string, password string, expiresOn *time.Time, now time.Time) error {
+	if !slices.Contains(ShareModes, mode) {
+		return fmt.Errorf("invalid mode (expected one of %s): %s", strings.Join(ShareModes, ", "), mode)
+	}
+	if len(password) > MaxSharePasswordLength {
+		return fmt.Errorf("passwords cannot be longer than %d bytes", MaxSharePasswordLength)
+	}
+	if expiresOn != nil && !expiresOn.After(now) {
+		return fmt.Errorf("expires_on must be in the future")
+	}
+	return nil
+}
+
+// NewShare creates a share of the note with a new random token, returned in
+// the share. An empty password means none is needed. It returns nil if the
+// note doesn't exist or is in the trash.
+func NewShare(db DBTX, owner string, noteID int64, mode string, password string, expiresOn *time.Time) (*notes.Share, error) {
+	if err := ValidateShare(mode, password, expiresOn, time.Now()); err != nil {
+		return nil, err
+	}
+	token, tokenHash, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := hashSharePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := begin(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NULL)", noteID, owner).Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+	result, err := tx.Exec(`
+        INSERT INTO shares (owner_sub, note_id, token_hash, mode, password_hash, expires_on, created_on)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		owner, noteID, tokenHash, mode, nullString(passwordHash), formatNullTime(expiresOn), formatTime(time.Now().UTC()))
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	share, err := getShare(tx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	share.Token = token
+	return share, nil
+}
+
+// GetShares returns the owner's shares, newest first, including revoked &
+// expired ones. If noteID isn't nil only the shares of that note are returned.
+func GetShares(db DBTX, owner string, noteID *int64) ([]*notes.Share, error) {
+	stmt, err := db.Prepare(`
+        SELECT ` + shareColumns + `
+        FROM shares
+        WHERE owner_sub = ? AND (? IS NULL OR note_id = ?)
+        ORDER BY created_on DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner, noteID, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []*notes.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share.Share)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// GetShareByToken returns the share with the given token, of any owner, or nil
+// if there is none. The share may have been revoked or have expired.
+func GetShareByToken(db DBTX, token string) (*ResolvedShare, error) {
+	share, err := scanShare(db.QueryRow(`
+        SELECT `+shareColumns+`
+        FROM shares
+        WHERE token_hash = ?`,
+		hashShareToken(token)))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// RevokeShare returns false if the share doesn't exist. Shares that were
+// already revoked keep the time they were first revoked.
+func RevokeShare(db DBTX, owner string, id int64) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM shares WHERE id = ? AND owner_sub = ?)", id, owner).Scan(&exists)
+	if err != nil || !exists {
+		return false, err
+	}
+	_, err = db.Exec("UPDATE shares SET revoked_on = ? WHERE id = ? AND owner_sub = ? AND revoked_on IS NULL",
+		formatTime(time.Now().UTC()), id, owner)
+	return err == nil, err
+}
+
+// RecordShareAccess counts an access of the share at the given time.
+func RecordShareAccess(db DBTX, id int64, now time.Time) error {
+	_, err := db.Exec("UPDATE shares SET access_count = access_count + 1, last_accessed_on = ? WHERE id = ?",
+		formatTime(now.UTC()), id)
+	return err
+}
+
+// Private
+
+const shareColumns = `id, owner_sub, note_id, mode, password_hash, expires_on, created_on, revoked_on, access_count, last_accessed_on`
+
+// newShareToken returns a random URL-safe token along with its hash.
+func newShareToken() (string, string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashShareToken(token), nil
+}
+
+// hashShareToken hashes a token for storage. Tokens are random, so unlike
+// passwords they don't need a slow hash.
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashSharePassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func getShare(db DBTX, owner string, id int64) (*notes.Share, error) {
+	share, err := scanShare(db.QueryRow("SELECT "+shareColumns+" FROM shares WHERE id = ? AND owner_sub = ?", id, owner))
+	if err != nil {
+		return nil, err
+	}
+	return share.Share, nil
+}
+
+func scanShare(row rowScanner) (*ResolvedShare, error) {
+	share := &ResolvedShare{Share: &notes.Share{}}
+	var passwordHash, expiresOn, revokedOn, lastAccessedOn sql.NullString
+	var createdOn string
+	if err := row.Scan(&share.ID, &share.Owner, &share.NoteID, &share.Mode, &passwordHash, &expiresOn, &createdOn, &revokedOn, &share.AccessCount, &lastAccessedOn); err != nil {
+		return nil, err
+	}
+	share.passwordHash = passwordHash.String
+	share.HasPassword = passwordHash.Valid
+
+	var err error
+	if share.ExpiresOn, err = parseNullTime(expiresOn); err != nil {
+		return nil, err
+	}
+	if share.CreatedOn, err = parseTime(createdOn); err != nil {
+		return nil, err
+	}
+	if share.RevokedOn, err = parseNullTime(revokedOn); err != nil {
+		return nil, err
+	}
+	if share.LastAccessedOn, err = parseNullTime(lastAccessedOn); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
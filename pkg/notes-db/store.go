@@ -0,0 +1,715 @@
+package notesdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+var (
+	ErrInvalidContentType = errors.New("note has invalid ContentType")
+)
+
+// Store is everything the API needs to persist notes. Every operation is
+// scoped to the owner passed in; notes belonging to anyone else behave as if
+// they don't exist.
+type Store interface {
+	// Atomically runs fn as a single unit of work: either everything it does
+	// through the Store passed to it takes effect, or, if it returns an error,
+	// none of it does. Calls made through the outer Store while fn runs are
+	// not part of the unit of work; reading through it is fine, but writing
+	// through it may make either fail.
+	Atomically(fn func(tx Store) error) error
+
+	NoteStore
+	SearchStore
+	RevisionStore
+	TrashStore
+	TagStore
+	PropertyStore
+	NotebookStore
+	TemplateStore
+	ReminderStore
+	ShareStore
+	AttachmentStore
+	LinkStore
+}
+
+type NoteStore interface {
+	NewNote(owner string, title string) (*IndexEntry, error)
+	// GetNote returns nil if the note doesn't exist or is in the trash.
+	GetNote(owner string, id int64) (*IndexEntry, error)
+	// GetNotes & GetNotesWithPreview return one page of notes along with the
+	// cursor of the next page, or "" if it is the last one.
+	GetNotes(owner string, filter *NoteFilter, page *NotePage) ([]*IndexEntry, string, error)
+	GetNotesWithPreview(owner string, filter *NoteFilter, page *NotePage, previewLength int) ([]*IndexEntryWithPreview, string, error)
+	// UpdateNote & SetNoteContents bump the version of the note. If
+	// expectedVersion is not 0 & the note is at a different version they
+	// change nothing and return ErrVersionConflict.
+	UpdateNote(owner string, id int64, title string, expectedVersion int64) error
+	// TouchNote marks the note as updated, bumping its version.
+	TouchNote(owner string, id int64) error
+	// SetNoteTimes overwrites when the note was created & last updated, e.g.
+	// to keep the timestamps of an imported note. It doesn't bump its version.
+	SetNoteTimes(owner string, id int64, createdOn time.Time, updatedOn time.Time) error
+	// SetNoteState sets or clears the state on the note. It doesn't bump its
+	// version.
+	SetNoteState(owner string, id int64, state NoteState, on bool) error
+	// GetNoteContents returns nil if the note has no content yet.
+	GetNoteContents(owner string, id int64) ([]byte, error)
+	// SetNoteContents also records the links in the new content (see
+	// LinkStore). It returns ErrNoteNotFound if there is no such note.
+	SetNoteContents(owner string, id int64, content []byte, expectedVersion int64) error
+	// DeleteNote moves the note to the trash.
+	DeleteNote(owner string, id int64) error
+}
+
+type SearchStore interface {
+	SearchNotes(owner string, query string, limit int) ([]*SearchResult, error)
+}
+
+type RevisionStore interface {
+	// RecordRevision snapshots the current title & content of the note,
+	// keeping at most maxRevisions (if greater than 0) per note.
+	RecordRevision(owner string, id int64, maxRevisions int) error
+	GetRevisions(owner string, id int64) ([]*notes.Revision, error)
+	// GetRevision returns nil if the revision doesn't exist.
+	GetRevision(owner string, id int64, rev int64) (*notes.RevisionWithContent, error)
+}
+
+type TrashStore interface {
+	GetTrashedNotes(owner string) ([]*TrashEntry, error)
+	RestoreNote(owner string, id int64) (bool, error)
+	PurgeNote(owner string, id int64) (bool, error)
+	// PurgeTrash purges notes of every owner trashed at or before the given time.
+	PurgeTrash(deletedBefore time.Time) ([]int64, error)
+}
+
+type TagStore interface {
+	AddTags(owner string, id int64, tags []string) error
+	RemoveTag(owner string, id int64, tag string) error
+	GetTags(owner string) ([]*notes.Tag, error)
+}
+
+type PropertyStore interface {
+	// SetProperties sets the properties in the patch on the note, unsetting
+	// the ones that map to nil. It doesn't bump the note's version.
+	SetProperties(owner string, id int64, patch map[string]*PropertyValue) error
+}
+
+type NotebookStore interface {
+	NewNotebook(owner string, name string, parentID *int64) (*notes.Notebook, error)
+	GetNotebooks(owner string) ([]*notes.Notebook, error)
+	// GetNotebook returns nil if the notebook doesn't exist.
+	GetNotebook(owner string, id int64) (*notes.Notebook, error)
+	UpdateNotebook(owner string, id int64, name string, parentID *int64) error
+	DeleteNotebook(owner string, id int64, policy NotebookDeletePolicy) error
+	MoveNote(owner string, id int64, notebookID *int64) error
+}
+
+type TemplateStore interface {
+	NewTemplate(owner string, name string, title string, content string) (*notes.Template, error)
+	GetTemplates(owner string) ([]*notes.Template, error)
+	// GetTemplate returns nil if the template doesn't exist.
+	GetTemplate(owner string, id int64) (*notes.Template, error)
+	UpdateTemplate(owner string, id int64, name string, title string, content string) error
+	DeleteTemplate(owner string, id int64) error
+}
+
+// ReminderStore keeps the due dates & reminders of notes, and the in-app
+// notifications of reminders that fired. Reminders of notes in the trash
+// don't fire.
+type ReminderStore interface {
+	// SetReminder sets when the note is due & when to be reminded of it,
+	// either of which may be nil. It doesn't bump the note's version.
+	SetReminder(owner string, id int64, dueOn *time.Time, remindAt *time.Time, recurrence Recurrence) error
+	GetUpcomingReminders(owner string, before time.Time, limit int) ([]*notes.Reminder, error)
+	// GetDueReminders & GetNextReminderTime cover the reminders of every owner.
+	GetDueReminders(now time.Time) ([]*DueReminder, error)
+	// GetNextReminderTime returns nil if no reminder is set.
+	GetNextReminderTime() (*time.Time, error)
+	MarkReminderFired(r *DueReminder, now time.Time) error
+	AddNotification(owner string, noteID int64, title string, message string) (*notes.Notification, error)
+	GetNotifications(owner string, unreadOnly bool, limit int) ([]*notes.Notification, error)
+	MarkNotificationRead(owner string, id int64) (bool, error)
+}
+
+// ShareStore keeps public links to notes. Shares of notes in the trash can't
+// be used until the note is restored.
+type ShareStore interface {
+	// NewShare returns nil if the note doesn't exist.
+	NewShare(owner string, noteID int64, mode string, password string, expiresOn *time.Time) (*notes.Share, error)
+	// GetShares returns the shares of the note, or of every note if noteID is
+	// nil.
+	GetShares(owner string, noteID *int64) ([]*notes.Share, error)
+	// GetShareByToken & RecordShareAccess cover the shares of every owner.
+	GetShareByToken(token string) (*ResolvedShare, error)
+	RevokeShare(owner string, id int64) (bool, error)
+	RecordShareAccess(id int64, now time.Time) error
+}
+
+// AttachmentStore keeps binary attachments of notes, separately from their
+// content. Attachments are kept while their note is in the trash & removed
+// when it is purged.
+type AttachmentStore interface {
+	// AddAttachment streams data into a new attachment of the note, filling
+	// in its size & SHA-256. It returns nil if the note doesn't exist.
+	AddAttachment(owner string, noteID int64, name string, mimeType string, data io.Reader) (*notes.Attachment, error)
+	GetAttachments(owner string, noteID int64) ([]*notes.Attachment, error)
+	// GetAttachment returns nil if the attachment doesn't exist.
+	GetAttachment(owner string, noteID int64, id int64) (*notes.Attachment, error)
+	// OpenAttachment returns the data of the attachment, or nil if it doesn't
+	// exist. The caller must close it.
+	OpenAttachment(owner string, noteID int64, id int64) (io.ReadCloser, error)
+	DeleteAttachment(owner string, noteID int64, id int64) (bool, error)
+}
+
+// LinkStore resolves the wiki-style links in note content: [[#123]] links to
+// the note with that ID & [[Title]] to the oldest note with that title,
+// ignoring case. Links are resolved when read, so they follow renames of the
+// notes they point to, and only ever resolve to the owner's live notes.
+type LinkStore interface {
+	// GetNoteLinks returns the links in the note's content; broken links have
+	// no target.
+	GetNoteLinks(owner string, id int64) ([]*notes.NoteLink, error)
+	// GetBacklinks returns the links in any of the owner's notes that resolve
+	// to the note.
+	GetBacklinks(owner string, id int64) ([]*notes.NoteLink, error)
+	GetNoteGraph(owner string) (*notes.NoteGraph, error)
+}
+
+// SQLiteStore is the Store backed by the SQLite DB & the functions in this
+// package. The content of CONTENT_FILE notes is kept in Files, and the data
+// of attachments in Attachments. If Cipher is set, note content (including
+// revisions) is encrypted wherever it is stored & left out of the search
+// index, so only titles are searchable. Before that, content of at least
+// CompressionThreshold bytes is compressed (see CompressContent).
+type SQLiteStore struct {
+	DB                   *sql.DB
+	Files                *ContentFileStore
+	Attachments          *AttachmentFileStore
+	Cipher               *ContentCipher
+	CompressionThreshold int
+	DefaultContentType   int
+
+	// Set on the store passed to the function given to Atomically.
+	tx                 *sql.Tx
+	pendingFiles       map[int64]*pendingFile
+	pendingAttachments []*pendingAttachment
+}
+
+// pendingFile is a change to a content file made during Atomically. Files
+// can't be part of a DB transaction, so changes are held back until the
+// transaction commits. The content is as stored, i.e. compressed & encrypted
+// if need be.
+type pendingFile struct {
+	content []byte
+	remove  bool
+}
+
+// pendingAttachment is an attachment file added or removed during
+// Atomically, held back like pendingFile. Added files wait at tmpPath.
+type pendingAttachment struct {
+	noteID  int64
+	id      int64
+	tmpPath string
+	remove  bool
+}
+
+func NewSQLiteStore(db *sql.DB, files *ContentFileStore, attachments *AttachmentFileStore, cipher *ContentCipher, compressionThreshold int, defaultContentType int) *SQLiteStore {
+	return &SQLiteStore{
+		DB:                   db,
+		Files:                files,
+		Attachments:          attachments,
+		Cipher:               cipher,
+		CompressionThreshold: compressionThreshold,
+		DefaultContentType:   defaultContentType,
+	}
+}
+
+// Atomically runs fn in a DB transaction. Nested calls join the outer
+// transaction. Content file changes are applied once the transaction has
+// committed; if that fails the DB is left ahead of the file, which the next
+// write of the note's content fixes.
+func (s *SQLiteStore) Atomically(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	txStore := &SQLiteStore{
+		DB:                   s.DB,
+		Files:                s.Files,
+		Attachments:          s.Attachments,
+		Cipher:               s.Cipher,
+		CompressionThreshold: s.CompressionThreshold,
+		DefaultContentType:   s.DefaultContentType,
+		pendingFiles:         map[int64]*pendingFile{},
+	}
+	err := RunInTx(s.DB, func(tx *sql.Tx) error {
+		txStore.tx = tx
+		return fn(txStore)
+	})
+	if err != nil {
+		for _, a := range txStore.pendingAttachments {
+			if !a.remove {
+				os.Remove(a.tmpPath)
+			}
+		}
+		return err
+	}
+
+	for _, a := range txStore.pendingAttachments {
+		if a.remove {
+			s.removeAttachmentFile(a.noteID, a.id)
+		} else if err := s.Attachments.place(a.tmpPath, a.noteID, a.id); err != nil {
+			os.Remove(a.tmpPath)
+			return fmt.Errorf("failed to move attachment %d into place after commit: %w", a.id, err)
+		}
+	}
+
+	for id, f := range txStore.pendingFiles {
+		if f.remove {
+			s.removeNoteFiles(id)
+		} else if err := s.Files.Write(id, f.content); err != nil {
+			return fmt.Errorf("failed to write content file of note %d after commit: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) NewNote(owner string, title string) (*IndexEntry, error) {
+	return NewNote(s.db(), owner, title, s.DefaultContentType)
+}
+
+func (s *SQLiteStore) GetNote(owner string, id int64) (*IndexEntry, error) {
+	return GetNote(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) GetNotes(owner string, filter *NoteFilter, page *NotePage) ([]*IndexEntry, string, error) {
+	return GetNotes(s.db(), owner, filter, page)
+}
+
+func (s *SQLiteStore) GetNotesWithPreview(owner string, filter *NoteFilter, page *NotePage, previewLength int) ([]*IndexEntryWithPreview, string, error) {
+	return GetNotesWithPreview(s.db(), owner, filter, page, previewLength, s.decodeContent)
+}
+
+func (s *SQLiteStore) UpdateNote(owner string, id int64, title string, expectedVersion int64) error {
+	return UpdateNote(s.db(), owner, id, title, expectedVersion)
+}
+
+func (s *SQLiteStore) TouchNote(owner string, id int64) error {
+	return TouchNote(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) SetNoteTimes(owner string, id int64, createdOn time.Time, updatedOn time.Time) error {
+	return SetNoteTimes(s.db(), owner, id, createdOn, updatedOn)
+}
+
+func (s *SQLiteStore) SetNoteState(owner string, id int64, state NoteState, on bool) error {
+	return SetNoteState(s.db(), owner, id, state, on)
+}
+
+// GetNoteContents reads the content of the note from wherever its content
+// type says it is stored.
+func (s *SQLiteStore) GetNoteContents(owner string, id int64) ([]byte, error) {
+	note, err := GetNote(s.db(), owner, id)
+	if err != nil || note == nil {
+		return nil, err
+	}
+	var stored []byte
+	switch note.ContentType {
+	case CONTENT_SQL:
+		stored, err = GetNoteContents(s.db(), owner, id)
+	case CONTENT_FILE:
+		stored, err = s.readContentFile(id)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrInvalidContentType, note.ContentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeContent(stored)
+}
+
+// SetNoteContents replaces the content of the note wherever its content type
+// says it is stored.
+func (s *SQLiteStore) SetNoteContents(owner string, id int64, content []byte, expectedVersion int64) error {
+	note, err := GetNote(s.db(), owner, id)
+	if err != nil {
+		return err
+	}
+	if note == nil {
+		return ErrNoteNotFound
+	}
+	stored, err := s.encodeContent(content)
+	if err != nil {
+		return err
+	}
+	return s.atomically(func(tx *SQLiteStore) error {
+		switch note.ContentType {
+		case CONTENT_SQL:
+			if err := SetNoteContents(tx.db(), owner, id, stored, expectedVersion); err != nil {
+				return err
+			}
+		case CONTENT_FILE:
+			// The note may have been deleted since it was looked up.
+			if err := checkNoteExists(tx.db(), owner, id); err != nil {
+				return err
+			}
+			if err := updateNoteVersion(tx.db(), owner, id, expectedVersion, ""); err != nil {
+				return err
+			}
+			if err := tx.writeContentFile(id, stored); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: %d", ErrInvalidContentType, note.ContentType)
+		}
+		if err := SetNoteLinks(tx.db(), id, content); err != nil {
+			return err
+		}
+		if s.Cipher != nil {
+			content = nil
+		}
+		return IndexNoteContent(tx.db(), id, content)
+	})
+}
+
+func (s *SQLiteStore) DeleteNote(owner string, id int64) error {
+	return DeleteNote(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) SearchNotes(owner string, query string, limit int) ([]*SearchResult, error) {
+	return SearchNotes(s.db(), owner, query, limit)
+}
+
+func (s *SQLiteStore) RecordRevision(owner string, id int64, maxRevisions int) error {
+	content, err := s.GetNoteContents(owner, id)
+	if err != nil {
+		return err
+	}
+	stored, err := s.encodeContent(content)
+	if err != nil {
+		return err
+	}
+	return RecordRevision(s.db(), owner, id, stored, maxRevisions)
+}
+
+func (s *SQLiteStore) GetRevisions(owner string, id int64) ([]*notes.Revision, error) {
+	return GetRevisions(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) GetRevision(owner string, id int64, rev int64) (*notes.RevisionWithContent, error) {
+	revision, err := GetRevision(s.db(), owner, id, rev)
+	if err != nil || revision == nil {
+		return nil, err
+	}
+	content, err := s.decodeContent([]byte(revision.Content))
+	if err != nil {
+		return nil, err
+	}
+	revision.Content = string(content)
+	return revision, nil
+}
+
+func (s *SQLiteStore) GetTrashedNotes(owner string) ([]*TrashEntry, error) {
+	return GetTrashedNotes(s.db(), owner)
+}
+
+func (s *SQLiteStore) RestoreNote(owner string, id int64) (bool, error) {
+	return RestoreNote(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) PurgeNote(owner string, id int64) (bool, error) {
+	found, err := PurgeNote(s.db(), owner, id)
+	if err != nil || !found {
+		return found, err
+	}
+	s.removeNoteFiles(id)
+	return true, nil
+}
+
+func (s *SQLiteStore) PurgeTrash(deletedBefore time.Time) ([]int64, error) {
+	purged, err := PurgeTrash(s.db(), deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range purged {
+		s.removeNoteFiles(id)
+	}
+	return purged, nil
+}
+
+func (s *SQLiteStore) AddTags(owner string, id int64, tags []string) error {
+	return AddTags(s.db(), owner, id, tags)
+}
+
+func (s *SQLiteStore) RemoveTag(owner string, id int64, tag string) error {
+	return RemoveTag(s.db(), owner, id, tag)
+}
+
+func (s *SQLiteStore) GetTags(owner string) ([]*notes.Tag, error) {
+	return GetTags(s.db(), owner)
+}
+
+func (s *SQLiteStore) SetProperties(owner string, id int64, patch map[string]*PropertyValue) error {
+	return SetProperties(s.db(), owner, id, patch)
+}
+
+func (s *SQLiteStore) NewNotebook(owner string, name string, parentID *int64) (*notes.Notebook, error) {
+	return NewNotebook(s.db(), owner, name, parentID)
+}
+
+func (s *SQLiteStore) GetNotebooks(owner string) ([]*notes.Notebook, error) {
+	return GetNotebooks(s.db(), owner)
+}
+
+func (s *SQLiteStore) GetNotebook(owner string, id int64) (*notes.Notebook, error) {
+	return GetNotebook(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) UpdateNotebook(owner string, id int64, name string, parentID *int64) error {
+	return UpdateNotebook(s.db(), owner, id, name, parentID)
+}
+
+func (s *SQLiteStore) DeleteNotebook(owner string, id int64, policy NotebookDeletePolicy) error {
+	return DeleteNotebook(s.db(), owner, id, policy)
+}
+
+func (s *SQLiteStore) MoveNote(owner string, id int64, notebookID *int64) error {
+	return MoveNote(s.db(), owner, id, notebookID)
+}
+
+func (s *SQLiteStore) NewTemplate(owner string, name string, title string, content string) (*notes.Template, error) {
+	return NewTemplate(s.db(), owner, name, title, content)
+}
+
+func (s *SQLiteStore) GetTemplates(owner string) ([]*notes.Template, error) {
+	return GetTemplates(s.db(), owner)
+}
+
+func (s *SQLiteStore) GetTemplate(owner string, id int64) (*notes.Template, error) {
+	return GetTemplate(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) UpdateTemplate(owner string, id int64, name string, title string, content string) error {
+	return UpdateTemplate(s.db(), owner, id, name, title, content)
+}
+
+func (s *SQLiteStore) DeleteTemplate(owner string, id int64) error {
+	return DeleteTemplate(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) SetReminder(owner string, id int64, dueOn *time.Time, remindAt *time.Time, recurrence Recurrence) error {
+	return SetReminder(s.db(), owner, id, dueOn, remindAt, recurrence)
+}
+
+func (s *SQLiteStore) GetUpcomingReminders(owner string, before time.Time, limit int) ([]*notes.Reminder, error) {
+	return GetUpcomingReminders(s.db(), owner, before, limit)
+}
+
+func (s *SQLiteStore) GetDueReminders(now time.Time) ([]*DueReminder, error) {
+	return GetDueReminders(s.db(), now)
+}
+
+func (s *SQLiteStore) GetNextReminderTime() (*time.Time, error) {
+	return GetNextReminderTime(s.db())
+}
+
+func (s *SQLiteStore) MarkReminderFired(r *DueReminder, now time.Time) error {
+	return MarkReminderFired(s.db(), r, now)
+}
+
+func (s *SQLiteStore) AddNotification(owner string, noteID int64, title string, message string) (*notes.Notification, error) {
+	return AddNotification(s.db(), owner, noteID, title, message)
+}
+
+func (s *SQLiteStore) GetNotifications(owner string, unreadOnly bool, limit int) ([]*notes.Notification, error) {
+	return GetNotifications(s.db(), owner, unreadOnly, limit)
+}
+
+func (s *SQLiteStore) MarkNotificationRead(owner string, id int64) (bool, error) {
+	return MarkNotificationRead(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) NewShare(owner string, noteID int64, mode string, password string, expiresOn *time.Time) (*notes.Share, error) {
+	return NewShare(s.db(), owner, noteID, mode, password, expiresOn)
+}
+
+func (s *SQLiteStore) GetShares(owner string, noteID *int64) ([]*notes.Share, error) {
+	return GetShares(s.db(), owner, noteID)
+}
+
+func (s *SQLiteStore) GetShareByToken(token string) (*ResolvedShare, error) {
+	return GetShareByToken(s.db(), token)
+}
+
+func (s *SQLiteStore) RevokeShare(owner string, id int64) (bool, error) {
+	return RevokeShare(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) RecordShareAccess(id int64, now time.Time) error {
+	return RecordShareAccess(s.db(), id, now)
+}
+
+func (s *SQLiteStore) AddAttachment(owner string, noteID int64, name string, mimeType string, data io.Reader) (*notes.Attachment, error) {
+	tmpPath, size, sum, err := s.Attachments.writeTemp(data)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := NewAttachment(s.db(), owner, &notes.Attachment{
+		NoteID:   noteID,
+		Name:     name,
+		MimeType: mimeType,
+		Size:     size,
+		SHA256:   sum,
+	})
+	if err != nil || attachment == nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if s.tx != nil {
+		s.pendingAttachments = append(s.pendingAttachments, &pendingAttachment{noteID: noteID, id: attachment.ID, tmpPath: tmpPath})
+		return attachment, nil
+	}
+	if err := s.Attachments.place(tmpPath, noteID, attachment.ID); err != nil {
+		os.Remove(tmpPath)
+		if _, deleteErr := DeleteAttachment(s.db(), owner, noteID, attachment.ID); deleteErr != nil {
+			slog.Warn("failed to delete attachment without data",
+				"attachmentID", attachment.ID,
+				"err", deleteErr)
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *SQLiteStore) GetAttachments(owner string, noteID int64) ([]*notes.Attachment, error) {
+	return GetAttachments(s.db(), owner, noteID)
+}
+
+func (s *SQLiteStore) GetAttachment(owner string, noteID int64, id int64) (*notes.Attachment, error) {
+	return GetAttachment(s.db(), owner, noteID, id)
+}
+
+func (s *SQLiteStore) OpenAttachment(owner string, noteID int64, id int64) (io.ReadCloser, error) {
+	attachment, err := GetAttachment(s.db(), owner, noteID, id)
+	if err != nil || attachment == nil {
+		return nil, err
+	}
+	for _, a := range s.pendingAttachments {
+		if a.id == id && !a.remove {
+			return os.Open(a.tmpPath)
+		}
+	}
+	return s.Attachments.Open(noteID, id)
+}
+
+func (s *SQLiteStore) DeleteAttachment(owner string, noteID int64, id int64) (bool, error) {
+	found, err := DeleteAttachment(s.db(), owner, noteID, id)
+	if err != nil || !found {
+		return found, err
+	}
+	s.removeAttachmentFile(noteID, id)
+	return true, nil
+}
+
+func (s *SQLiteStore) GetNoteLinks(owner string, id int64) ([]*notes.NoteLink, error) {
+	return GetNoteLinks(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) GetBacklinks(owner string, id int64) ([]*notes.NoteLink, error) {
+	return GetBacklinks(s.db(), owner, id)
+}
+
+func (s *SQLiteStore) GetNoteGraph(owner string) (*notes.NoteGraph, error) {
+	return GetNoteGraph(s.db(), owner)
+}
+
+// Private
+
+// atomically is Atomically for the store's own methods.
+func (s *SQLiteStore) atomically(fn func(tx *SQLiteStore) error) error {
+	return s.Atomically(func(tx Store) error {
+		return fn(tx.(*SQLiteStore))
+	})
+}
+
+// encodeContent & decodeContent store content the way EncodeContent &
+// DecodeContent do, with the store's threshold & cipher.
+func (s *SQLiteStore) encodeContent(content []byte) ([]byte, error) {
+	return EncodeContent(content, s.CompressionThreshold, s.Cipher)
+}
+
+func (s *SQLiteStore) decodeContent(stored []byte) ([]byte, error) {
+	return DecodeContent(stored, s.Cipher)
+}
+
+// db returns the transaction of the unit of work the store is part of, if any.
+func (s *SQLiteStore) db() DBTX {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.DB
+}
+
+func (s *SQLiteStore) readContentFile(id int64) ([]byte, error) {
+	if f, ok := s.pendingFiles[id]; ok {
+		if f.remove {
+			return nil, nil
+		}
+		return f.content, nil
+	}
+	return s.Files.Read(id)
+}
+
+func (s *SQLiteStore) writeContentFile(id int64, content []byte) error {
+	if s.tx != nil {
+		s.pendingFiles[id] = &pendingFile{content: content}
+		return nil
+	}
+	return s.Files.Write(id, content)
+}
+
+// removeNoteFiles removes the content file & attachments of a purged note,
+// if any. The note itself is already gone at this point, so failures are only
+// logged.
+func (s *SQLiteStore) removeNoteFiles(id int64) {
+	if s.tx != nil {
+		s.pendingFiles[id] = &pendingFile{remove: true}
+		return
+	}
+	if err := s.Files.Remove(id); err != nil {
+		slog.Warn("failed to remove content file of purged note",
+			"noteID", id,
+			"err", err)
+	}
+	if err := s.Attachments.RemoveNote(id); err != nil {
+		slog.Warn("failed to remove attachments of purged note",
+			"noteID", id,
+			"err", err)
+	}
+}
+
+// removeAttachmentFile removes the data of a deleted attachment. Like
+// removeNoteFiles, failures are only logged.
+func (s *SQLiteStore) removeAttachmentFile(noteID int64, id int64) {
+	if s.tx != nil {
+		s.pendingAttachments = append(s.pendingAttachments, &pendingAttachment{noteID: noteID, id: id, remove: true})
+		return
+	}
+	if err := s.Attachments.Remove(noteID, id); err != nil {
+		slog.Warn("failed to remove data of deleted attachment",
+			"noteID", noteID,
+			"attachmentID", id,
+			"err", err)
+	}
+}
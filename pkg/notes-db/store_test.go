@@ -0,0 +1,170 @@
+package notesdb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// testStores returns a fresh store of each kind, so tests can check that they
+// behave the same. The SQLite store is left out if it can't be built without
+// FTS5.
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	stores := map[string]Store{"memory": NewMemoryStore()}
+	dir := t.TempDir()
+	db, err := Initialize(filepath.Join(dir, "notes.sqlite"))
+	if err != nil && errors.Is(err, ErrFTS5Unavailable) {
+		t.Logf("skipping the SQLite store: %s", err)
+		return stores
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	files, err := NewContentFileStore(filepath.Join(dir, "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := NewAttachmentFileStore(filepath.Join(dir, "attachments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["sqlite"] = NewSQLiteStore(db, files, attachments, nil, DefaultCompressionThreshold, CONTENT_SQL)
+	return stores
+}
+
+func TestAtomically(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			entry, err := store.NewNote("owner", "Before")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Failed units of work leave nothing behind, & the outer store can
+			// still be read from within them.
+			failed := errors.New("failed")
+			err = store.Atomically(func(tx Store) error {
+				if err := tx.UpdateNote("owner", entry.ID, "During", 0); err != nil {
+					return err
+				}
+				if _, err := tx.NewNote("owner", "Extra"); err != nil {
+					return err
+				}
+				outer, err := store.GetNote("owner", entry.ID)
+				if err != nil {
+					return err
+				}
+				if outer.Title != "Before" {
+					return fmt.Errorf("expected the outer store to be unchanged, got %s", outer.Title)
+				}
+				return failed
+			})
+			if !errors.Is(err, failed) {
+				t.Fatalf("expected the unit of work to fail, got %v", err)
+			}
+			entries, _, err := store.GetNotes("owner", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Title != "Before" {
+				t.Errorf("expected only the note from before, got %+v", entries)
+			}
+
+			err = store.Atomically(func(tx Store) error {
+				return tx.UpdateNote("owner", entry.ID, "After", 0)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if note, err := store.GetNote("owner", entry.ID); err != nil || note.Title != "After" {
+				t.Errorf("expected the title After, got %+v (err: %v)", note, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreAtomicallyIsSerializable(t *testing.T) {
+	store := NewMemoryStore()
+	entry, err := store.NewNote("owner", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each unit of work increments the title, so lost updates would show.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Atomically(func(tx Store) error {
+				note, err := tx.GetNote("owner", entry.ID)
+				if err != nil {
+					return err
+				}
+				n, err := strconv.Atoi(note.Title)
+				if err != nil {
+					return err
+				}
+				return tx.UpdateNote("owner", entry.ID, strconv.Itoa(n+1), 0)
+			})
+			if err != nil && !errors.Is(err, errConcurrentModification) {
+				t.Error(err)
+			} else if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	note, err := store.GetNote("owner", entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Title != strconv.Itoa(succeeded) {
+		t.Errorf("expected the title %d, got %s", succeeded, note.Title)
+	}
+
+	// Writing to the outer store from within a unit of work makes it fail
+	// rather than hang.
+	err = store.Atomically(func(tx Store) error {
+		return store.TouchNote("owner", entry.ID)
+	})
+	if !errors.Is(err, errConcurrentModification) {
+		t.Errorf("expected errConcurrentModification, got %v", err)
+	}
+}
+
+func TestSetNoteContentsOfMissingNote(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			trashed, err := store.NewNote("owner", "Trashed")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.DeleteNote("owner", trashed.ID); err != nil {
+				t.Fatal(err)
+			}
+			theirs, err := store.NewNote("someone-else", "Theirs")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range []int64{trashed.ID, theirs.ID, theirs.ID + 100} {
+				if err := store.SetNoteContents("owner", id, []byte("x"), 0); !errors.Is(err, ErrNoteNotFound) {
+					t.Errorf("note %d: expected ErrNoteNotFound, got %v", id, err)
+				}
+			}
+			if content, err := store.GetNoteContents("someone-else", theirs.ID); err != nil || content != nil {
+				t.Errorf("expected no content, got %q (err: %v)", content, err)
+			}
+		})
+	}
+}
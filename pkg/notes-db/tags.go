@@ -0,0 +1,142 @@
+package notesdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+const (
+	MaxTagLength = 64
+)
+
+// NormalizeTags trims whitespace from each tag & removes duplicates, which
+// are compared case-insensitively. It returns an error if any tag is empty or
+// too long.
+func NormalizeTags(tags []string) ([]string, error) {
+	trimmed := []string{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			return nil, fmt.Errorf("tags cannot be empty")
+		}
+		if len(t) > MaxTagLength {
+			return nil, fmt.Errorf("tags cannot be longer than %d characters: %s", MaxTagLength, t)
+		}
+		trimmed = append(trimmed, t)
+	}
+	return uniqueTags(trimmed), nil
+}
+
+// AddTags adds the given tags to the note, creating them for the owner if
+// they don't exist yet. Tags the note already has are ignored.
+func AddTags(db DBTX, owner string, id int64, tags []string) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range tags {
+		_, err := tx.Exec("INSERT OR IGNORE INTO tags (owner_sub, name) VALUES (?, ?)", owner, t)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+            INSERT OR IGNORE INTO note_tags (note_id, tag_id)
+                SELECT notes.id, tags.id
+                FROM notes
+                    JOIN tags ON tags.owner_sub = notes.owner_sub
+                WHERE notes.id = ? AND notes.owner_sub = ? AND tags.name = ?`,
+			id, owner, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveTag removes the tag from the note. Tags no longer attached to any
+// note are deleted.
+func RemoveTag(db DBTX, owner string, id int64, tag string) error {
+	tx, err := begin(db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        DELETE FROM note_tags
+        WHERE note_id = ?
+            AND tag_id IN (SELECT id FROM tags WHERE owner_sub = ? AND name = ?)`,
+		id, owner, tag)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+        DELETE FROM tags
+        WHERE owner_sub = ?
+            AND NOT EXISTS (SELECT 1 FROM note_tags WHERE note_tags.tag_id = tags.id)`,
+		owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTags returns all of the owner's tags along with the number of notes
+// (not counting those in the trash) that have each one.
+func GetTags(db DBTX, owner string) ([]*notes.Tag, error) {
+	stmt, err := db.Prepare(`
+        SELECT tags.name, COUNT(notes.id)
+        FROM tags
+            LEFT JOIN note_tags ON note_tags.tag_id = tags.id
+            LEFT JOIN notes ON notes.id = note_tags.note_id AND notes.deleted_on IS NULL
+        WHERE tags.owner_sub = ?
+        GROUP BY tags.id
+        ORDER BY tags.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*notes.Tag{}
+	for rows.Next() {
+		tag := &notes.Tag{}
+		if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Private
+
+func uniqueTags(tags []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, t := range tags {
+		key := strings.ToLower(t)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
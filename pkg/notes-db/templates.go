@@ -0,0 +1,269 @@
+package notesdb
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+// MaxRenderedTemplateSize is the most a template may render to, so that
+// templates can't exhaust memory. Templates can't run for long without
+// writing either: range only takes .Params & templates can't call templates
+// (see parseTemplate).
+const MaxRenderedTemplateSize = 1 << 20
+
+var ErrRenderedTemplateTooLarge = fmt.Errorf("rendered template is larger than %d bytes", MaxRenderedTemplateSize)
+
+// TemplateData is what templates are rendered with, e.g. {{.Date}} or
+// {{.Params.customer}}.
+type TemplateData struct {
+	// Now is when the note is created, in the requested time zone. Date &
+	// Time are it formatted as YYYY-MM-DD & HH:MM.
+	Now  time.Time
+	Date string
+	Time string
+	// User is the name of the user creating the note, from their token's
+	// claims, & UserID their subject.
+	User   string
+	UserID string
+	// Params are given by the user creating the note. Missing ones render as
+	// "".
+	Params map[string]string
+}
+
+// NewTemplateData returns the data to render templates with at the given
+// time.
+func NewTemplateData(now time.Time, user string, userID string, params map[string]string) *TemplateData {
+	if params == nil {
+		params = map[string]string{}
+	}
+	return &TemplateData{
+		Now:    now,
+		Date:   now.Format("2006-01-02"),
+		Time:   now.Format("15:04"),
+		User:   user,
+		UserID: userID,
+		Params: params,
+	}
+}
+
+// ValidateTemplate returns an error if the title or content of a template
+// don't parse.
+func ValidateTemplate(title string, content string) error {
+	if _, err := parseTemplate("title", title); err != nil {
+		return err
+	}
+	_, err := parseTemplate("content", content)
+	return err
+}
+
+// RenderTemplate renders the title & content of the template with the data.
+// Whitespace around the rendered title is trimmed.
+func RenderTemplate(t *notes.Template, data *TemplateData) (string, string, error) {
+	title, err := renderTemplate("title", t.Title, data)
+	if err != nil {
+		return "", "", err
+	}
+	content, err := renderTemplate("content", t.Content, data)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(title), content, nil
+}
+
+func NewTemplate(db DBTX, owner string, name string, title string, content string) (*notes.Template, error) {
+	now := formatTime(time.Now().UTC())
+	result, err := db.Exec("INSERT INTO templates (owner_sub, name, title, content, created_on, updated_on) VALUES (?, ?, ?, ?, ?, ?)",
+		owner, name, title, content, now, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetTemplate(db, owner, id)
+}
+
+func GetTemplates(db DBTX, owner string) ([]*notes.Template, error) {
+	stmt, err := db.Prepare(`
+        SELECT id, name, title, content, created_on, updated_on
+        FROM templates
+        WHERE owner_sub = ?
+        ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*notes.Template{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// GetTemplate returns the template with the given ID, or nil if it doesn't exist.
+func GetTemplate(db DBTX, owner string, id int64) (*notes.Template, error) {
+	stmt, err := db.Prepare(`
+        SELECT id, name, title, content, created_on, updated_on
+        FROM templates
+        WHERE id = ? AND owner_sub = ?`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	t, err := scanTemplate(stmt.QueryRow(id, owner))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func UpdateTemplate(db DBTX, owner string, id int64, name string, title string, content string) error {
+	_, err := db.Exec("UPDATE templates SET name = ?, title = ?, content = ?, updated_on = ? WHERE id = ? AND owner_sub = ?",
+		name, title, content, formatTime(time.Now().UTC()), id, owner)
+	return err
+}
+
+func DeleteTemplate(db DBTX, owner string, id int64) error {
+	_, err := db.Exec("DELETE FROM templates WHERE id = ? AND owner_sub = ?", id, owner)
+	return err
+}
+
+// Private
+
+// parseTemplate parses the source of a template. Params missing from
+// TemplateData.Params render as "" rather than "<no value>".
+//
+// Templates are refused unless they are bounded by their data: range over a
+// number (e.g. {{range 1000000000000}}) or over anything else besides .Params
+// could spin without writing anything, and so could templates calling
+// templates (including {{block}}), each doubling the work of the one before.
+func parseTemplate(name string, source string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	for _, defined := range t.Templates() {
+		if defined.Tree == nil {
+			continue
+		}
+		if err := checkTemplateNode(defined.Tree.Root); err != nil {
+			return nil, fmt.Errorf("template: %s: %w", defined.Name(), err)
+		}
+	}
+	return t, nil
+}
+
+func checkTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkTemplateBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return checkTemplateBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		if !isParamsPipe(n.Pipe) {
+			return fmt.Errorf("range is only allowed over .Params: %s", n.Pipe)
+		}
+		return checkTemplateBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		return fmt.Errorf("templates cannot call templates: %s", n)
+	}
+	return nil
+}
+
+func checkTemplateBranch(n *parse.BranchNode) error {
+	if err := checkTemplateNode(n.List); err != nil {
+		return err
+	}
+	return checkTemplateNode(n.ElseList)
+}
+
+// isParamsPipe returns whether the pipeline is just .Params, optionally
+// assigned to variables.
+func isParamsPipe(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	return ok && len(field.Ident) == 1 && field.Ident[0] == "Params"
+}
+
+func renderTemplate(name string, source string, data *TemplateData) (string, error) {
+	t, err := parseTemplate(name, source)
+	if err != nil {
+		return "", err
+	}
+	out := &limitedBuffer{limit: MaxRenderedTemplateSize}
+	if err := t.Execute(out, data); err != nil {
+		if errors.Is(err, ErrRenderedTemplateTooLarge) {
+			return "", ErrRenderedTemplateTooLarge
+		}
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// limitedBuffer is a bytes.Buffer that fails writes past its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, ErrRenderedTemplateTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+func scanTemplate(row rowScanner) (*notes.Template, error) {
+	t := &notes.Template{}
+	var createdOn, updatedOn string
+	if err := row.Scan(&t.ID, &t.Name, &t.Title, &t.Content, &createdOn, &updatedOn); err != nil {
+		return nil, err
+	}
+	var err error
+	if t.CreatedOn, err = parseTime(createdOn); err != nil {
+		return nil, err
+	}
+	if t.UpdatedOn, err = parseTime(updatedOn); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,60 @@
+package notesdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mrshanahan/notes-api/pkg/notes"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"Meeting {{.Date}} {{.Time}}",
+		"{{.Params.customer}} for {{.User}}",
+		"{{range $k, $v := .Params}}{{$k}}: {{$v}}\n{{end}}",
+		"{{if .Params.x}}{{range .Params}}{{.}}{{end}}{{else}}none{{end}}",
+		"{{with .Params}}{{.x}}{{end}}",
+	} {
+		if err := ValidateTemplate("Title", source); err != nil {
+			t.Errorf("%q: %s", source, err)
+		}
+	}
+
+	for _, source := range []string{
+		"{{.Date",
+		"{{range 1000000000}}x{{end}}",
+		"{{range .Date}}x{{end}}",
+		"{{if .User}}{{range .Now}}x{{end}}{{end}}",
+		"{{with .Params}}{{else}}{{range .Date}}x{{end}}{{end}}",
+		// Ranging over .Params inside a range over .Params still ranges over
+		// whatever the dot is at that point.
+		"{{range .Params}}{{range .}}x{{end}}{{end}}",
+		`{{define "t"}}{{template "t" .}}{{end}}{{template "t" .}}`,
+		`{{block "t" .}}x{{end}}`,
+	} {
+		if err := ValidateTemplate(source, ""); err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 14, 7, 0, 0, time.UTC)
+	data := NewTemplateData(now, "Ann", "ann-id", map[string]string{"b": "2", "a": "1"})
+	template := &notes.Template{
+		Title:   "  Meeting {{.Date}} with {{.Params.customer}}  ",
+		Content: "{{.Time}} by {{.User}} ({{.UserID}})\n{{range $k, $v := .Params}}{{$k}}={{$v}};{{end}}",
+	}
+
+	title, content, err := RenderTemplate(template, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "Meeting 2024-03-05 with" {
+		t.Errorf("unexpected title: %q", title)
+	}
+	if expected := "14:07 by Ann (ann-id)\na=1;b=2;"; content != expected {
+		t.Errorf("expected content %q, got %q", expected, content)
+	}
+}
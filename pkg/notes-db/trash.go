@@ -0,0 +1,127 @@
+package notesdb
+
+import "time"
+
+type TrashEntry struct {
+	*IndexEntry
+	DeletedOn time.Time `json:"deleted_on"`
+}
+
+func GetTrashedNotes(db DBTX, owner string) ([]*TrashEntry, error) {
+	stmt, err := db.Prepare(`
+        SELECT ` + noteColumns + `, deleted_on
+        FROM notes
+        WHERE owner_sub = ? AND deleted_on IS NOT NULL
+        ORDER BY deleted_on DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*TrashEntry{}
+	for rows.Next() {
+		entry := &TrashEntry{}
+		var deletedOn string
+		entry.IndexEntry, err = scanNote(rows, &deletedOn)
+		if err != nil {
+			return nil, err
+		}
+		if entry.DeletedOn, err = parseTime(deletedOn); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// RestoreNote takes the note out of the trash. It returns false if there is
+// no such note in the owner's trash.
+func RestoreNote(db DBTX, owner string, id int64) (bool, error) {
+	stmt, err := db.Prepare("UPDATE notes SET deleted_on = NULL WHERE id = ? AND owner_sub = ? AND deleted_on IS NOT NULL")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id, owner)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// PurgeNote permanently deletes a note from the trash, along with its content
+// & revisions. It returns false if there is no such note in the owner's trash.
+func PurgeNote(db DBTX, owner string, id int64) (bool, error) {
+	stmt, err := db.Prepare("DELETE FROM notes WHERE id = ? AND owner_sub = ? AND deleted_on IS NOT NULL")
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id, owner)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// PurgeTrash permanently deletes every note, regardless of owner, that was
+// moved to the trash at or before the given time. It returns the IDs of the
+// purged notes.
+func PurgeTrash(db DBTX, deletedBefore time.Time) ([]int64, error) {
+	tx, err := begin(db)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deletedBeforeStr := formatTime(deletedBefore.UTC())
+	rows, err := tx.Query("SELECT id FROM notes WHERE deleted_on IS NOT NULL AND deleted_on <= ?", deletedBeforeStr)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purged := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		purged = append(purged, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range purged {
+		if _, err := tx.Exec("DELETE FROM notes WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
@@ -0,0 +1,65 @@
+package notesdb
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DBTX is implemented by both *sql.DB & *sql.Tx. The functions in this
+// package take one so they can either run on their own or as one step of a
+// larger transaction started with RunInTx.
+type DBTX interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// RunInTx runs fn in a transaction, committing it if fn returns nil & rolling
+// it back otherwise.
+func RunInTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type txn interface {
+	DBTX
+	Commit() error
+	Rollback() error
+}
+
+// begin starts a transaction on db. If db is already a transaction the
+// returned one joins it instead: committing & rolling back are left to
+// whoever started it, who sees any error returned by this step.
+func begin(db DBTX) (txn, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		return db.Begin()
+	case *sql.Tx:
+		return joinedTx{db}, nil
+	case joinedTx:
+		return db, nil
+	default:
+		return nil, fmt.Errorf("cannot begin a transaction on %T", db)
+	}
+}
+
+type joinedTx struct {
+	*sql.Tx
+}
+
+func (joinedTx) Commit() error {
+	return nil
+}
+
+func (joinedTx) Rollback() error {
+	return nil
+}
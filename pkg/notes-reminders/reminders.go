@@ -0,0 +1,191 @@
+// Package notesreminders fires the reminders set on notes: a Scheduler polls
+// the store for due reminders & hands each one to a set of Sinks, e.g. the
+// log, a webhook or the owner's in-app notifications.
+package notesreminders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	notesdb "github.com/mrshanahan/notes-api/pkg/notes-db"
+)
+
+// DefaultPollInterval is the longest a Scheduler sleeps between checks for
+// due reminders, so that reminders set by other processes sharing the DB
+// aren't missed for long.
+const DefaultPollInterval = time.Minute
+
+// minSleep keeps a reminder that can't be marked as fired from being fired
+// over & over in a tight loop.
+const minSleep = time.Second
+
+// Sink delivers a reminder that has fired.
+type Sink interface {
+	Deliver(ctx context.Context, r *notesdb.DueReminder) error
+}
+
+// LogSink logs reminders.
+type LogSink struct{}
+
+func (LogSink) Deliver(ctx context.Context, r *notesdb.DueReminder) error {
+	slog.Info("reminder fired", "owner", r.Owner, "noteID", r.NoteID, "title", r.Title, "at", r.At)
+	return nil
+}
+
+// WebhookSink POSTs reminders as JSON to URL.
+type WebhookSink struct {
+	URL string
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+// WebhookPayload is the body of the requests made by WebhookSink.
+type WebhookPayload struct {
+	Owner      string     `json:"owner"`
+	NoteID     int64      `json:"note_id"`
+	Title      string     `json:"title"`
+	At         time.Time  `json:"at"`
+	DueOn      *time.Time `json:"due_on"`
+	Recurrence string     `json:"recurrence,omitempty"`
+}
+
+var defaultWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *WebhookSink) Deliver(ctx context.Context, r *notesdb.DueReminder) error {
+	body, err := json.Marshal(&WebhookPayload{
+		Owner:      r.Owner,
+		NoteID:     r.NoteID,
+		Title:      r.Title,
+		At:         r.At,
+		DueOn:      r.DueOn,
+		Recurrence: r.Recurrence,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// NotificationSink adds reminders to their owner's notifications.
+type NotificationSink struct {
+	Store notesdb.Store
+}
+
+func (s *NotificationSink) Deliver(ctx context.Context, r *notesdb.DueReminder) error {
+	message := "Reminder: " + r.Title
+	if r.DueOn != nil {
+		message += fmt.Sprintf(" (due %s)", r.DueOn.Format(time.RFC3339))
+	}
+	_, err := s.Store.AddNotification(r.Owner, r.NoteID, r.Title, message)
+	return err
+}
+
+// Scheduler fires reminders as they come due. Since reminders are kept in the
+// store along with when they fire next, ones that came due while the server
+// was down fire as soon as it is back; recurring ones then skip to their next
+// occurrence after that.
+type Scheduler struct {
+	Store        notesdb.Store
+	Sinks        []Sink
+	PollInterval time.Duration
+	wake         chan struct{}
+}
+
+func NewScheduler(store notesdb.Store, sinks ...Sink) *Scheduler {
+	return &Scheduler{
+		Store:        store,
+		Sinks:        sinks,
+		PollInterval: DefaultPollInterval,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake makes the scheduler check for due reminders right away, e.g. after a
+// reminder has been set. It never blocks & does nothing on a nil Scheduler.
+func (s *Scheduler) Wake() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run fires reminders until the context is done. Each due reminder is handed
+// to every sink, then moved on to its next occurrence; sinks that fail are
+// logged rather than retried, so one broken sink can't hold up the others.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		s.fireDue(ctx)
+
+		sleep := s.PollInterval
+		next, err := s.Store.GetNextReminderTime()
+		if err != nil {
+			slog.Error("failed to get next reminder time",
+				"err", err)
+		} else if next != nil {
+			sleep = min(sleep, max(time.Until(*next), minSleep))
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Private
+
+func (s *Scheduler) fireDue(ctx context.Context) {
+	now := time.Now().UTC()
+	due, err := s.Store.GetDueReminders(now)
+	if err != nil {
+		slog.Error("failed to get due reminders",
+			"err", err)
+		return
+	}
+	for _, r := range due {
+		for _, sink := range s.Sinks {
+			if err := sink.Deliver(ctx, r); err != nil {
+				slog.Error("failed to deliver reminder",
+					"noteID", r.NoteID,
+					"sink", fmt.Sprintf("%T", sink),
+					"err", err)
+			}
+		}
+		if err := s.Store.MarkReminderFired(r, now); err != nil {
+			slog.Error("failed to mark reminder as fired",
+				"noteID", r.NoteID,
+				"err", err)
+		}
+	}
+}
@@ -7,4 +7,157 @@ type Note struct {
     Title       string `json:"title"`
     CreatedOn   time.Time `json:"created_on"`
     UpdatedOn   time.Time `json:"updated_on"`
+    Tags        []string `json:"tags"`
+    // Properties maps names to strings, numbers (float64), bools or dates
+    // (strings formatted as YYYY-MM-DD).
+    Properties  map[string]any `json:"properties"`
+    NotebookID  *int64 `json:"notebook_id"`
+    Pinned      bool `json:"pinned"`
+    Favorite    bool `json:"favorite"`
+    Archived    bool `json:"archived"`
+    DueOn       *time.Time `json:"due_on"`
+    // RemindAt is when the note's reminder was set for & Recurrence is "",
+    // "daily", "weekly" or "monthly". NextReminderAt is when it fires next.
+    RemindAt    *time.Time `json:"remind_at"`
+    Recurrence  string `json:"recurrence"`
+    NextReminderAt  *time.Time `json:"next_reminder_at"`
+    Version     int64 `json:"version"`
+}
+
+type SearchResult struct {
+    *Note
+    TitleHighlight  string `json:"title_highlight"`
+    Snippet         string `json:"snippet"`
+    Rank            float64 `json:"rank"`
+}
+
+type Revision struct {
+    NoteID      int64 `json:"note_id"`
+    Number      int64 `json:"revision"`
+    Title       string `json:"title"`
+    CreatedOn   time.Time `json:"created_on"`
+}
+
+type RevisionWithContent struct {
+    *Revision
+    Content     string `json:"content"`
+}
+
+type TrashedNote struct {
+    *Note
+    DeletedOn   time.Time `json:"deleted_on"`
+}
+
+type Tag struct {
+    Name        string `json:"name"`
+    Count       int64 `json:"count"`
+}
+
+type Notebook struct {
+    ID          int64 `json:"id"`
+    ParentID    *int64 `json:"parent_id"`
+    Name        string `json:"name"`
+    CreatedOn   time.Time `json:"created_on"`
+    UpdatedOn   time.Time `json:"updated_on"`
+}
+
+// Template is used to create notes. Its Title & Content are text/template
+// source.
+type Template struct {
+    ID          int64 `json:"id"`
+    Name        string `json:"name"`
+    Title       string `json:"title"`
+    Content     string `json:"content"`
+    CreatedOn   time.Time `json:"created_on"`
+    UpdatedOn   time.Time `json:"updated_on"`
+}
+
+// Reminder is the next firing of a note's reminder.
+type Reminder struct {
+    NoteID      int64 `json:"note_id"`
+    Title       string `json:"title"`
+    At          time.Time `json:"at"`
+    DueOn       *time.Time `json:"due_on"`
+    Recurrence  string `json:"recurrence"`
+}
+
+type Notification struct {
+    ID          int64 `json:"id"`
+    NoteID      int64 `json:"note_id"`
+    Title       string `json:"title"`
+    Message     string `json:"message"`
+    CreatedOn   time.Time `json:"created_on"`
+    ReadOn      *time.Time `json:"read_on"`
+}
+
+// Share is a public link to a note. Token is only set when the share is
+// created, since only a hash of it is kept.
+type Share struct {
+    ID          int64 `json:"id"`
+    NoteID      int64 `json:"note_id"`
+    Token       string `json:"token,omitempty"`
+    Mode        string `json:"mode"`
+    HasPassword bool `json:"has_password"`
+    ExpiresOn   *time.Time `json:"expires_on"`
+    CreatedOn   time.Time `json:"created_on"`
+    RevokedOn   *time.Time `json:"revoked_on"`
+    AccessCount int64 `json:"access_count"`
+    LastAccessedOn *time.Time `json:"last_accessed_on"`
+}
+
+type NotesPage struct {
+    Notes       []*Note `json:"notes"`
+    NextCursor  string `json:"next_cursor,omitempty"`
+}
+
+type Attachment struct {
+    ID          int64 `json:"id"`
+    NoteID      int64 `json:"note_id"`
+    Name        string `json:"name"`
+    MimeType    string `json:"mime_type"`
+    Size        int64 `json:"size"`
+    SHA256      string `json:"sha256"`
+    CreatedOn   time.Time `json:"created_on"`
+}
+
+type NoteLink struct {
+    SourceID    int64 `json:"source_id"`
+    SourceTitle string `json:"source_title"`
+    Text        string `json:"text"`
+    TargetID    *int64 `json:"target_id"`
+    TargetTitle *string `json:"target_title"`
+}
+
+type NoteGraph struct {
+    Nodes       []*NoteGraphNode `json:"nodes"`
+    Edges       []*NoteGraphEdge `json:"edges"`
+}
+
+type NoteGraphNode struct {
+    ID          int64 `json:"id"`
+    Title       string `json:"title"`
+}
+
+type NoteGraphEdge struct {
+    Source      int64 `json:"source"`
+    Target      int64 `json:"target"`
+}
+
+type Backup struct {
+    Name        string `json:"name"`
+    Size        int64 `json:"size"`
+    CreatedOn   time.Time `json:"created_on"`
+}
+
+type ImportReport struct {
+    DryRun      bool `json:"dry_run"`
+    Results     []*ImportResult `json:"results"`
+}
+
+type ImportResult struct {
+    Path        string `json:"path"`
+    Status      string `json:"status"`
+    NoteID      *int64 `json:"note_id"`
+    Title       string `json:"title,omitempty"`
+    Error       string `json:"error,omitempty"`
 }